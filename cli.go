@@ -0,0 +1,337 @@
+// Copyright (C) 2025 Ethan Cheng <ethan@nijika.org>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+
+package main
+
+import (
+	"io"
+	"os"
+	"fmt"
+	"context"
+	"errors"
+	"strings"
+	"database/sql"
+
+	"github.com/yadayadajaychan/playlog/database"
+	"github.com/yadayadajaychan/playlog/internal/app"
+	"github.com/yadayadajaychan/playlog/internal/config"
+	"github.com/pborman/getopt/v2"
+)
+
+// command is a subcommand, e.g. 'playlog songs sync'
+type command struct {
+	name     string // with the command it's under, e.g. "songs sync"
+	params   string // after the options, e.g. "<songs.json>"
+	summary  string
+	run      func(c *cli, set *getopt.Set, args []string) error
+	noConfig bool // runs without loading the configuration
+}
+
+// commands are listed by 'playlog help' in this order
+var commands = []command{
+	{name: "serve", summary: "run the backend, updating the play db every update interval", run: serveCommand},
+	{name: "update", summary: "update the play db once & exit", run: updateCommand},
+	{name: "import", params: "<file>", summary: "import plays from a solips playlog json file or a playlog export", run: importCommand},
+	{name: "export", params: "[file]", summary: "export the plays of a player as json", run: exportCommand},
+	{name: "show recent", summary: "show the latest plays", run: showRecentCommand},
+	{name: "show bests", summary: "show the best play of each chart, highest rating first", run: showBestsCommand},
+	{name: "show song", params: "<name>", summary: "show the charts of a song and the best plays on them", run: showSongCommand},
+	{name: "songs sync", params: "<songs.json>", summary: "add and update songs from songs.json", run: songsSyncCommand},
+	{name: "songs add", params: "<songs.json>", summary: "only add the songs from songs.json that aren't in the song db", run: songsAddCommand},
+	{name: "songs levels", params: "<levels.json>", summary: "add the internal levels of charts per game version", run: songsLevelsCommand},
+	{name: "aliases list", params: "[song id]", summary: "list the aliases of every song, or of one song", run: aliasesListCommand},
+	{name: "aliases add", params: "<song id> <alias>", summary: "add an alias to a song", run: aliasesAddCommand},
+	{name: "aliases remove", params: "<song id> <alias>", summary: "remove an alias from a song", run: aliasesRemoveCommand},
+	{name: "aliases import", params: "<file>", summary: "add the aliases from a file made by aliases export", run: aliasesImportCommand},
+	{name: "aliases export", params: "[file]", summary: "export the aliases of every song as json", run: aliasesExportCommand},
+	{name: "players list", summary: "list the players in the play db", run: playersListCommand},
+	{name: "players add", params: "<name> solips|kamai <access code|username>", summary: "add a player to the play db", run: playersAddCommand},
+	{name: "db migrate", summary: "migrate the databases, or print pending migrations", run: migrateCommand},
+	{name: "db fsck", summary: "check the plays of every player", run: fsckCommand},
+	{name: "db backup", params: "[dir]", summary: "back up the play db to dir, or to the backup dir", run: backupCommand},
+	{name: "db restore", params: "<backup>", summary: "replace the play db with a backup", run: restoreCommand},
+	{name: "config check", summary: "print the configuration, or what's wrong with it", run: configCommand, noConfig: true},
+}
+
+// aliases are the commands from before they were grouped
+var aliases = map[string]string{
+	"fsck":    "db fsck",
+	"backup":  "db backup",
+	"restore": "db restore",
+}
+
+// errHelp is returned by parse once it has printed the help of a command
+var errHelp = errors.New("help requested")
+
+// cli is what commands are run with.
+// The configuration and databases are only loaded when a command needs them.
+type cli struct {
+	ctx    context.Context
+	dryRun bool // -n before the command
+
+	dotenv     *dotenv
+	loadConfig func() (config.Config, error) // from the file, env and flags
+	logOut     *logOutput
+
+	cmd command
+	cfg config.Config
+	env app.Env // settings only, see stores
+
+	playdbConn *sql.DB
+	songdbConn *sql.DB
+	playdb     *database.PlayDB
+	songdb     *database.SongDB
+}
+
+// run runs the command in args, e.g. ["songs", "sync", "songs.json"]
+func (c *cli) run(args []string) error {
+	if args[0] == "help" {
+		return c.help(args[1:])
+	}
+
+	if name, ok := aliases[args[0]]; ok {
+		args = append(strings.Fields(name), args[1:]...)
+	}
+
+	cmd, rest, err := findCommand(args)
+	if err != nil {
+		return err
+	}
+
+	c.cmd = cmd
+	set := getopt.New()
+	set.SetProgram("playlog " + cmd.name)
+	set.SetParameters(cmd.params)
+
+	err = cmd.run(c, set, append([]string{"playlog " + cmd.name}, rest...))
+	if err == errHelp {
+		return nil
+	}
+	return err
+}
+
+// findCommand returns the command named at the start of args,
+// and the args after its name
+func findCommand(args []string) (command, []string, error) {
+	if len(args) >= 2 {
+		for _, cmd := range commands {
+			if cmd.name == args[0]+" "+args[1] {
+				return cmd, args[2:], nil
+			}
+		}
+	}
+
+	for _, cmd := range commands {
+		if cmd.name == args[0] {
+			return cmd, args[1:], nil
+		}
+	}
+
+	// e.g. 'playlog songs', without a command under it
+	if sub := subcommands(args[0]); len(sub) > 0 {
+		var b strings.Builder
+		fmt.Fprintf(&b, "%s needs a command:\n", args[0])
+		printCommands(&b, sub)
+		return command{}, nil, errors.New(strings.TrimSuffix(b.String(), "\n"))
+	}
+
+	return command{}, nil, fmt.Errorf("unknown command: %s, see 'playlog help'", strings.Join(args, " "))
+}
+
+// subcommands returns the commands under group, e.g. "songs"
+func subcommands(group string) []command {
+	sub := make([]command, 0)
+	for _, cmd := range commands {
+		if strings.HasPrefix(cmd.name, group+" ") {
+			sub = append(sub, cmd)
+		}
+	}
+	return sub
+}
+
+func printCommands(w io.Writer, cmds []command) {
+	width := 0
+	for _, cmd := range cmds {
+		width = max(width, len(cmd.name))
+	}
+	for _, cmd := range cmds {
+		fmt.Fprintf(w, "  %-*s  %s\n", width, cmd.name, cmd.summary)
+	}
+}
+
+// usage prints the global options and the commands
+func usage() {
+	getopt.PrintUsage(os.Stderr)
+	fmt.Fprintln(os.Stderr, "\nCommands (serve if none is given):")
+	printCommands(os.Stderr, commands)
+	fmt.Fprintln(os.Stderr, "\nSee 'playlog help <command>' for the options of a command.")
+}
+
+// help prints the help of the command in args, or of every command
+func (c *cli) help(args []string) error {
+	if len(args) == 0 {
+		fmt.Println("Usage: playlog [options] <command> [command options] [args]")
+		fmt.Println("\nCommands:")
+		printCommands(os.Stdout, commands)
+		fmt.Println("\nSee 'playlog help <command>' for the options of a command, and 'playlog -h' for the global options.")
+		return nil
+	}
+
+	return c.run(append(args, "-h"))
+}
+
+// parse parses the options of the command in set, adding -h,
+// then loads the configuration unless the command doesn't need it
+func (c *cli) parse(set *getopt.Set, args []string) error {
+	help := set.BoolLong("help", 'h', "display help")
+
+	err := set.Getopt(args, nil)
+	if err != nil {
+		set.PrintUsage(os.Stderr)
+		return fmt.Errorf("%w (options of every command go before it, see 'playlog -h')", err)
+	}
+	if *help {
+		set.PrintUsage(os.Stdout)
+		return errHelp
+	}
+
+	if c.cmd.noConfig {
+		return nil
+	}
+	return c.setup()
+}
+
+// nargs returns an error with the usage of the command
+// unless it was given between min and max arguments
+func nargs(set *getopt.Set, min, max int) error {
+	n := set.NArgs()
+	if n >= min && n <= max {
+		return nil
+	}
+
+	set.PrintUsage(os.Stderr)
+	if n < min {
+		return errors.New("missing arguments")
+	}
+	return errors.New("unexpected arguments: " + strings.Join(set.Args()[max:], " "))
+}
+
+// dryRunFlag adds -n to set. The returned func reports whether
+// it was given, either to the command or before it.
+func (c *cli) dryRunFlag(set *getopt.Set, help string) func() bool {
+	n := set.BoolLong("dry-run", 'n', help)
+	return func() bool {
+		return *n || c.dryRun
+	}
+}
+
+// setup loads the configuration, and starts logging to the log file
+func (c *cli) setup() error {
+	var err error
+	c.cfg, err = c.loadConfig()
+	if err != nil {
+		return err
+	}
+
+	c.env, err = c.cfg.Env()
+	if err != nil {
+		return err
+	}
+
+	return c.logOut.open(c.cfg.Log.File)
+}
+
+// conns opens the play and song db, without migrating them
+func (c *cli) conns() (playdbConn, songdbConn *sql.DB, err error) {
+	if c.playdbConn == nil {
+		c.playdbConn, err = database.Open(c.cfg.Playdb)
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+
+	if c.songdbConn == nil {
+		c.songdbConn, err = database.Open(c.cfg.Songdb)
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+
+	return c.playdbConn, c.songdbConn, nil
+}
+
+// stores opens and migrates the play and song db, and puts them in c.env.
+// The play db is scoped to the default player.
+func (c *cli) stores() (app.Env, error) {
+	if c.playdb != nil {
+		return c.env, nil
+	}
+
+	playdbConn, songdbConn, err := c.conns()
+	if err != nil {
+		return c.env, err
+	}
+
+	playdb, err := database.NewPlayDB(playdbConn)
+	if err != nil {
+		return c.env, err
+	}
+	c.playdb = playdb
+
+	songdb, err := database.NewSongDB(songdbConn)
+	if err != nil {
+		return c.env, err
+	}
+	c.songdb = songdb
+
+	c.env.Playdb = c.playdb.WithValidationMode(c.env.ValidationMode)
+	c.env.Songdb = c.songdb
+	return c.env, nil
+}
+
+// playerStore returns the play db of the player named name,
+// or of the default player if name is ""
+func (c *cli) playerStore(name string) (database.PlayStore, error) {
+	env, err := c.stores()
+	if err != nil {
+		return nil, err
+	}
+	if name == "" {
+		return env.Playdb, nil
+	}
+
+	player, err := env.Playdb.GetPlayerByName(name)
+	if err != nil {
+		return nil, err
+	}
+	return env.Playdb.WithPlayer(player.PlayerId), nil
+}
+
+// close closes the databases that were opened
+func (c *cli) close() {
+	if c.playdb != nil {
+		c.playdb.Close()
+	}
+	if c.songdb != nil {
+		c.songdb.Close()
+	}
+	if c.playdbConn != nil {
+		c.playdbConn.Close()
+	}
+	if c.songdbConn != nil {
+		c.songdbConn.Close()
+	}
+}
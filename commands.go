@@ -0,0 +1,850 @@
+// Copyright (C) 2025 Ethan Cheng <ethan@nijika.org>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+
+package main
+
+import (
+	"io"
+	"os"
+	"log"
+	"fmt"
+	"bufio"
+	"errors"
+	"context"
+	"time"
+	"strconv"
+	"database/sql"
+	"encoding/json"
+	"path/filepath"
+
+	"github.com/yadayadajaychan/playlog/database"
+	"github.com/yadayadajaychan/playlog/internal/songs"
+	"github.com/yadayadajaychan/playlog/internal/app"
+	"github.com/yadayadajaychan/playlog/internal/backup"
+	"github.com/yadayadajaychan/playlog/internal/update"
+	"github.com/yadayadajaychan/playlog/internal/update/solips"
+	"github.com/pborman/getopt/v2"
+)
+
+// updateCommand updates the play db once
+func updateCommand(c *cli, set *getopt.Set, args []string) error {
+	err := c.parse(set, args)
+	if err != nil {
+		return err
+	}
+	err = nargs(set, 0, 0)
+	if err != nil {
+		return err
+	}
+
+	env, err := c.stores()
+	if err != nil {
+		return err
+	}
+
+	err = update.Update(c.ctx, env)
+	if err != nil {
+		return err
+	}
+
+	if env.Verbose >= 1 {
+		log.Print("finished update")
+	}
+	return nil
+}
+
+// importCommand adds the plays in a file to the play db.
+// The file is either the playlog detail json of solips,
+// or the output of 'playlog export'.
+func importCommand(c *cli, set *getopt.Set, args []string) error {
+	player := set.StringLong("player", 'P', "", "import the plays of this player instead of the default player")
+	err := c.parse(set, args)
+	if err != nil {
+		return err
+	}
+	err = nargs(set, 1, 1)
+	if err != nil {
+		return err
+	}
+
+	playdb, err := c.playerStore(*player)
+	if err != nil {
+		return err
+	}
+
+	file, err := os.Open(set.Arg(0))
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	// exports are arrays, solips' playlog detail is an object
+	r := bufio.NewReader(file)
+	first, err := firstByte(r)
+	if err != nil {
+		return err
+	}
+	if first != '[' {
+		return solips.Import(playdb, r)
+	}
+
+	plays := make([]database.PlayInfo, 0)
+	err = json.NewDecoder(r).Decode(&plays)
+	if err != nil {
+		return err
+	}
+
+	counts := make(map[database.AddPlayResult]int)
+	rejected := 0
+	for _, play := range plays {
+		result, err := playdb.AddPlayWithResult(play)
+		if e, ok := err.(*database.InvalidPlayError); ok {
+			log.Print("warning: ", e)
+			rejected++
+			continue
+		} else if err != nil {
+			return err
+		}
+		counts[result]++
+	}
+
+	fmt.Printf("%d added, %d duplicates, %d conflicts, %d rejected\n",
+		counts[database.PlayAdded], counts[database.PlayDuplicate],
+		counts[database.PlayConflict], rejected)
+	return nil
+}
+
+// firstByte returns the first byte of r that isn't whitespace, without reading it
+func firstByte(r *bufio.Reader) (byte, error) {
+	for {
+		b, err := r.Peek(1)
+		if err != nil {
+			return 0, err
+		}
+
+		switch b[0] {
+		case ' ', '\t', '\r', '\n':
+			r.ReadByte()
+		default:
+			return b[0], nil
+		}
+	}
+}
+
+// exportCommand writes the plays of a player as a json array, oldest first,
+// which 'playlog import' reads back
+func exportCommand(c *cli, set *getopt.Set, args []string) error {
+	player := set.StringLong("player", 'P', "", "export the plays of this player instead of the default player")
+	err := c.parse(set, args)
+	if err != nil {
+		return err
+	}
+	err = nargs(set, 0, 1)
+	if err != nil {
+		return err
+	}
+
+	playdb, err := c.playerStore(*player)
+	if err != nil {
+		return err
+	}
+
+	count, err := playdb.GetCount()
+	if err != nil {
+		return err
+	}
+	plays, err := playdb.GetPlays(true, count, 0)
+	if err != nil {
+		return err
+	}
+
+	var w io.Writer = os.Stdout
+	if set.NArgs() == 1 {
+		file, err := os.Create(set.Arg(0))
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		w = file
+	}
+
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "\t")
+	return enc.Encode(plays)
+}
+
+// songsSyncCommand prints the difference between the song db and songs.json,
+// then applies it unless -n is given. Quarantined plays of songs
+// that were added are then added to the play db.
+func songsSyncCommand(c *cli, set *getopt.Set, args []string) error {
+	dryRun := c.dryRunFlag(set, "only print the changes")
+	err := c.parse(set, args)
+	if err != nil {
+		return err
+	}
+	err = nargs(set, 1, 1)
+	if err != nil {
+		return err
+	}
+	filename := set.Arg(0)
+
+	if dryRun() {
+		_, songdbConn, err := c.conns()
+		if err != nil {
+			return err
+		}
+
+		pending, err := database.PendingSongMigrations(songdbConn)
+		if err != nil {
+			return err
+		}
+		if len(pending) > 0 {
+			for _, m := range pending {
+				fmt.Printf("song db: version %d: %s\n", m.Version, m.Description)
+			}
+			return errors.New("song db must be migrated before it can be compared with " + filename)
+		}
+	}
+
+	env, err := c.stores()
+	if err != nil {
+		return err
+	}
+
+	songInfos, err := loadSongs(filename)
+	if err != nil {
+		return err
+	}
+
+	diff, err := env.Songdb.DiffSongs(songInfos)
+	if err != nil {
+		return err
+	}
+
+	songs.PrintDiff(os.Stdout, diff, songInfos)
+	if dryRun() {
+		return nil
+	}
+
+	err = songs.Sync(env.Songdb, songInfos)
+	if err != nil {
+		return err
+	}
+
+	return promoteQuarantined(c.ctx, env)
+}
+
+// songsAddCommand adds the songs in songs.json that aren't in the song db,
+// leaving the others alone
+func songsAddCommand(c *cli, set *getopt.Set, args []string) error {
+	err := c.parse(set, args)
+	if err != nil {
+		return err
+	}
+	err = nargs(set, 1, 1)
+	if err != nil {
+		return err
+	}
+
+	env, err := c.stores()
+	if err != nil {
+		return err
+	}
+
+	songInfos, err := loadSongs(set.Arg(0))
+	if err != nil {
+		return err
+	}
+
+	for _, song := range songInfos {
+		err = env.Songdb.AddSong(song)
+		if err != nil {
+			return err
+		}
+	}
+
+	return promoteQuarantined(c.ctx, env)
+}
+
+func loadSongs(filename string) ([]database.SongInfo, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	return songs.Load(file)
+}
+
+// promoteQuarantined adds the quarantined plays of every player
+// whose song is now in env.Songdb to the play db
+func promoteQuarantined(ctx context.Context, env app.Env) error {
+	playdb := env.Playdb
+
+	players, err := playdb.GetPlayers()
+	if err != nil {
+		return err
+	}
+
+	for _, player := range players {
+		env.Playdb = playdb.WithPlayer(player.PlayerId)
+		err = solips.PromoteQuarantined(ctx, env)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// songsLevelsCommand adds the internal levels of charts per game version
+// from a json file to the song db
+func songsLevelsCommand(c *cli, set *getopt.Set, args []string) error {
+	dryRun := c.dryRunFlag(set, "only print the levels")
+	err := c.parse(set, args)
+	if err != nil {
+		return err
+	}
+	err = nargs(set, 1, 1)
+	if err != nil {
+		return err
+	}
+
+	file, err := os.Open(set.Arg(0))
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	levels, err := songs.LoadLevels(file)
+	if err != nil {
+		return err
+	}
+
+	for _, level := range levels {
+		fmt.Printf("%d %s: %d.%d from %s (%s)\n", level.SongId, level.Difficulty,
+			level.InternalLevel/10, level.InternalLevel%10,
+			time.Unix(level.EffectiveFrom, 0).Format(time.DateOnly), level.GameVersion)
+	}
+	if dryRun() {
+		return nil
+	}
+
+	env, err := c.stores()
+	if err != nil {
+		return err
+	}
+
+	for _, level := range levels {
+		err = env.Songdb.AddChartLevel(level)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// playersListCommand prints the id, name and data source of every player
+func playersListCommand(c *cli, set *getopt.Set, args []string) error {
+	err := c.parse(set, args)
+	if err != nil {
+		return err
+	}
+	err = nargs(set, 0, 0)
+	if err != nil {
+		return err
+	}
+
+	env, err := c.stores()
+	if err != nil {
+		return err
+	}
+
+	players, err := env.Playdb.GetPlayers()
+	if err != nil {
+		return err
+	}
+
+	for _, p := range players {
+		source := p.DataSource
+		if source == "" {
+			source = "(default)"
+		}
+		fmt.Printf("%d\t%s\t%s\n", p.PlayerId, p.Name, source)
+	}
+	return nil
+}
+
+// playersAddCommand adds a player that is updated from solips or kamaitachi
+func playersAddCommand(c *cli, set *getopt.Set, args []string) error {
+	err := c.parse(set, args)
+	if err != nil {
+		return err
+	}
+	err = nargs(set, 3, 3)
+	if err != nil {
+		return err
+	}
+
+	player := database.PlayerInfo{
+		Name:       set.Arg(0),
+		DataSource: set.Arg(1),
+	}
+
+	switch player.DataSource {
+	case "solips":
+		player.AccessCode = set.Arg(2)
+	case "kamai":
+		player.KamaiUser = set.Arg(2)
+	default:
+		return errors.New("invalid data source: " + player.DataSource)
+	}
+
+	env, err := c.stores()
+	if err != nil {
+		return err
+	}
+
+	id, err := env.Playdb.AddPlayer(player)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("added player %s with id %d\n", player.Name, id)
+	return nil
+}
+
+// aliasesListCommand prints the aliases of every song, or of one song
+func aliasesListCommand(c *cli, set *getopt.Set, args []string) error {
+	err := c.parse(set, args)
+	if err != nil {
+		return err
+	}
+	err = nargs(set, 0, 1)
+	if err != nil {
+		return err
+	}
+
+	env, err := c.stores()
+	if err != nil {
+		return err
+	}
+
+	var aliases []database.SongAlias
+	if set.NArgs() == 1 {
+		songId, err := parseSongId(set.Arg(0))
+		if err != nil {
+			return err
+		}
+		names, err := env.Songdb.GetSongAliases(songId)
+		if err != nil {
+			return err
+		}
+		for _, name := range names {
+			aliases = append(aliases, database.SongAlias{SongId: songId, Alias: name})
+		}
+	} else {
+		aliases, err = env.Songdb.GetAllSongAliases()
+		if err != nil {
+			return err
+		}
+	}
+
+	songs := songCache{songdb: env.Songdb}
+	for _, a := range aliases {
+		song, _, err := songs.chart(a.SongId, 0, 0)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("%d\t%q\t%s\n", a.SongId, a.Alias, song.Name)
+	}
+	return nil
+}
+
+// aliasesAddCommand adds an alias to a song
+func aliasesAddCommand(c *cli, set *getopt.Set, args []string) error {
+	err := c.parse(set, args)
+	if err != nil {
+		return err
+	}
+	err = nargs(set, 2, 2)
+	if err != nil {
+		return err
+	}
+
+	songId, err := parseSongId(set.Arg(0))
+	if err != nil {
+		return err
+	}
+
+	env, err := c.stores()
+	if err != nil {
+		return err
+	}
+
+	return env.Songdb.AddSongAlias(songId, set.Arg(1))
+}
+
+// aliasesRemoveCommand removes an alias from a song
+func aliasesRemoveCommand(c *cli, set *getopt.Set, args []string) error {
+	err := c.parse(set, args)
+	if err != nil {
+		return err
+	}
+	err = nargs(set, 2, 2)
+	if err != nil {
+		return err
+	}
+
+	songId, err := parseSongId(set.Arg(0))
+	if err != nil {
+		return err
+	}
+
+	env, err := c.stores()
+	if err != nil {
+		return err
+	}
+
+	return env.Songdb.RemoveSongAlias(songId, set.Arg(1))
+}
+
+// aliasesImportCommand adds the aliases in a file made by 'aliases export'
+// that songs don't have yet
+func aliasesImportCommand(c *cli, set *getopt.Set, args []string) error {
+	err := c.parse(set, args)
+	if err != nil {
+		return err
+	}
+	err = nargs(set, 1, 1)
+	if err != nil {
+		return err
+	}
+
+	data, err := os.ReadFile(set.Arg(0))
+	if err != nil {
+		return err
+	}
+
+	var aliases []database.SongAlias
+	err = json.Unmarshal(data, &aliases)
+	if err != nil {
+		return err
+	}
+
+	env, err := c.stores()
+	if err != nil {
+		return err
+	}
+
+	added, err := env.Songdb.ImportSongAliases(aliases)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("%d added, %d already there\n", added, len(aliases)-added)
+	return nil
+}
+
+// aliasesExportCommand writes the aliases of every song as json
+func aliasesExportCommand(c *cli, set *getopt.Set, args []string) error {
+	err := c.parse(set, args)
+	if err != nil {
+		return err
+	}
+	err = nargs(set, 0, 1)
+	if err != nil {
+		return err
+	}
+
+	env, err := c.stores()
+	if err != nil {
+		return err
+	}
+
+	aliases, err := env.Songdb.GetAllSongAliases()
+	if err != nil {
+		return err
+	}
+
+	var w io.Writer = os.Stdout
+	if set.NArgs() == 1 {
+		file, err := os.Create(set.Arg(0))
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		w = file
+	}
+
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "\t")
+	enc.SetEscapeHTML(false)
+	return enc.Encode(aliases)
+}
+
+func parseSongId(s string) (int, error) {
+	songId, err := strconv.Atoi(s)
+	if err != nil {
+		return 0, errors.New("invalid song id: " + s)
+	}
+	return songId, nil
+}
+
+// migrateCommand migrates both databases, printing the migrations applied.
+// With -n, the pending migrations are only printed.
+func migrateCommand(c *cli, set *getopt.Set, args []string) error {
+	dryRun := c.dryRunFlag(set, "only print the pending migrations")
+	err := c.parse(set, args)
+	if err != nil {
+		return err
+	}
+	err = nargs(set, 0, 0)
+	if err != nil {
+		return err
+	}
+
+	playdbConn, songdbConn, err := c.conns()
+	if err != nil {
+		return err
+	}
+
+	err = printPendingMigrations(playdbConn, songdbConn)
+	if err != nil || dryRun() {
+		return err
+	}
+
+	_, err = c.stores()
+	return err
+}
+
+func printPendingMigrations(playdb, songdb *sql.DB) error {
+	playMigrations, err := database.PendingPlayMigrations(playdb)
+	if err != nil {
+		return err
+	}
+
+	songMigrations, err := database.PendingSongMigrations(songdb)
+	if err != nil {
+		return err
+	}
+
+	if len(playMigrations) == 0 && len(songMigrations) == 0 {
+		fmt.Println("databases are up to date")
+	}
+	for _, m := range playMigrations {
+		fmt.Printf("play db: version %d: %s\n", m.Version, m.Description)
+	}
+	for _, m := range songMigrations {
+		fmt.Printf("song db: version %d: %s\n", m.Version, m.Description)
+	}
+
+	return nil
+}
+
+type fsckPlayerReport struct {
+	Player string
+	database.FsckReport
+}
+
+// fsckCommand checks the plays of every player, see database.PlayDB.Fsck.
+// It fails if any problem is left unrepaired.
+func fsckCommand(c *cli, set *getopt.Set, args []string) error {
+	jsonOutput := set.BoolLong("json", 'j', "print the report as json")
+	repair := set.BoolLong("repair", 'r', "recompute the totals of judgements that don't add up")
+	dryRun := c.dryRunFlag(set, "don't repair anything")
+	err := c.parse(set, args)
+	if err != nil {
+		return err
+	}
+	err = nargs(set, 0, 0)
+	if err != nil {
+		return err
+	}
+
+	_, err = c.stores()
+	if err != nil {
+		return err
+	}
+
+	players, err := c.playdb.GetPlayers()
+	if err != nil {
+		return err
+	}
+
+	reports := make([]fsckPlayerReport, 0, len(players))
+	unrepaired := 0
+	for _, player := range players {
+		report, err := c.playdb.ForPlayer(player.PlayerId).Fsck(c.songdb, *repair && !dryRun())
+		if err != nil {
+			return err
+		}
+
+		reports = append(reports, fsckPlayerReport{Player: player.Name, FsckReport: report})
+		unrepaired += report.Unrepaired()
+	}
+
+	if *jsonOutput {
+		j, err := json.MarshalIndent(reports, "", "\t")
+		if err != nil {
+			return err
+		}
+		fmt.Println(string(j))
+	} else {
+		for _, r := range reports {
+			fmt.Printf("player %s: %d plays checked, %d problems\n", r.Player, r.Plays, len(r.Problems))
+			for _, p := range r.Problems {
+				repaired := ""
+				if p.Repaired {
+					repaired = " (repaired)"
+				}
+				fmt.Printf("  play %d (id %d): %s: %s%s\n", p.UserPlayDate, p.PlayId, p.Kind, p.Message, repaired)
+			}
+		}
+	}
+
+	if unrepaired > 0 {
+		return fmt.Errorf("fsck: %d problems found", unrepaired)
+	}
+	return nil
+}
+
+// backupCommand backs up the play db to dir and deletes old backups.
+// With -n, the existing backups are listed instead.
+func backupCommand(c *cli, set *getopt.Set, args []string) error {
+	dryRun := c.dryRunFlag(set, "only list the backups")
+	err := c.parse(set, args)
+	if err != nil {
+		return err
+	}
+	err = nargs(set, 0, 1)
+	if err != nil {
+		return err
+	}
+
+	env := c.env
+
+	dir := env.BackupDir
+	if set.NArgs() == 1 {
+		dir = set.Arg(0)
+	}
+	if dir == "" {
+		return errors.New("backup: no directory given, and the backup dir isn't set")
+	}
+
+	if dryRun() {
+		files, err := backup.List(dir, filepath.Base(env.PlaydbFilename))
+		if err != nil {
+			return err
+		}
+		for _, f := range files {
+			fmt.Printf("%s\t%s\n", f.Time.Format(time.DateTime), f.Name)
+		}
+		return nil
+	}
+
+	db, _, err := c.conns()
+	if err != nil {
+		return err
+	}
+
+	env.Verbose = max(env.Verbose, 1)
+	return backupPlaydb(env, db, dir)
+}
+
+// restoreCommand replaces the play db with a backup. The play db is backed up
+// next to the backup first, so the restore can be undone.
+// With -n, the backup is only verified.
+func restoreCommand(c *cli, set *getopt.Set, args []string) error {
+	dryRun := c.dryRunFlag(set, "only verify the backup")
+	err := c.parse(set, args)
+	if err != nil {
+		return err
+	}
+	err = nargs(set, 1, 1)
+	if err != nil {
+		return err
+	}
+
+	env := c.env
+	file := set.Arg(0)
+
+	err = backup.Verify(file)
+	if err != nil {
+		return fmt.Errorf("verifying backup %s: %w", file, err)
+	}
+	if dryRun() {
+		fmt.Println(file + ": ok")
+		return nil
+	}
+
+	db, _, err := c.conns()
+	if err != nil {
+		return err
+	}
+
+	current, err := backup.Backup(db, filepath.Dir(file), filepath.Base(env.PlaydbFilename), time.Now())
+	if err != nil {
+		return err
+	}
+	fmt.Println("backed up play db to " + current)
+
+	err = backup.Restore(db, file)
+	if err != nil {
+		return err
+	}
+	fmt.Println("restored play db from " + file)
+
+	return nil
+}
+
+// configCommand runs 'config check', which prints the settings playlog
+// would run with, from the config file, environment and flags,
+// or everything that's wrong with them
+func configCommand(c *cli, set *getopt.Set, args []string) error {
+	err := c.parse(set, args)
+	if err != nil {
+		return err
+	}
+	err = nargs(set, 0, 0)
+	if err != nil {
+		return err
+	}
+
+	cfg, err := c.loadConfig()
+	if err != nil {
+		return err
+	}
+
+	err = cfg.Validate()
+	if err != nil {
+		return err
+	}
+
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetEscapeHTML(false)
+	enc.SetIndent("", "  ")
+	err = enc.Encode(cfg.Redacted())
+	if err != nil {
+		return err
+	}
+
+	if cfg.Filename != "" {
+		fmt.Fprintf(os.Stderr, "%s: ok\n", cfg.Filename)
+	} else {
+		fmt.Fprintln(os.Stderr, "no config file, ok")
+	}
+	return nil
+}
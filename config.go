@@ -0,0 +1,180 @@
+// Copyright (C) 2025 Ethan Cheng <ethan@nijika.org>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+
+package main
+
+import (
+	"os"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/yadayadajaychan/playlog/internal/app"
+	"github.com/yadayadajaychan/playlog/internal/config"
+	"github.com/pborman/getopt/v2"
+)
+
+// flags are the settings given as options,
+// which take precedence over the environment and the config file
+type flags struct {
+	songdb         *string
+	playdb         *string
+	dataSource     *string
+	verbose        *int
+	listenPort     *int
+	updateInterval *int
+	apiInterval    *int
+	backupDir      *string
+	backupInterval *int
+	keepDaily      *int
+	keepWeekly     *int
+	validation     *string
+	logFile        *string
+}
+
+// defineFlags adds the options of flags, with the defaults of config.Default
+func defineFlags() flags {
+	d := config.Default()
+	seconds := func(d config.Duration) int {
+		return int(time.Duration(d) / time.Second)
+	}
+
+	return flags{
+		songdb:         getopt.StringLong("songdb", 's', d.Songdb, "filename of song db"),
+		playdb:         getopt.StringLong("playdb", 'p', d.Playdb, "filename of play db"),
+		dataSource:     getopt.StringLong("data-source", 'd', d.Source.DataSource, "valid options: solips, kamai"),
+
+		verbose:        getopt.CounterLong("verbose", 'v', "verbosity level (errors only, info, debug)"),
+		listenPort:     getopt.IntLong("listen-port", 'l', d.Listen.Port, "port to listen on"),
+
+		updateInterval: getopt.IntLong("update-interval", 't', seconds(d.Update.Interval), "seconds to wait between updates"),
+		apiInterval:    getopt.IntLong("api-interval", 'a', seconds(d.Update.ApiInterval), "seconds to wait between api requests"),
+		backupDir:      getopt.StringLong("backup-dir", 0, d.Backup.Dir, "back up the play db to this directory while running"),
+		backupInterval: getopt.IntLong("backup-interval", 0, seconds(d.Backup.Interval), "seconds to wait between backups"),
+		keepDaily:      getopt.IntLong("keep-daily", 0, d.Backup.KeepDaily, "no. of days to keep a daily backup for"),
+		keepWeekly:     getopt.IntLong("keep-weekly", 0, d.Backup.KeepWeekly, "no. of weeks to keep a weekly backup for"),
+		validation:     getopt.StringLong("validation", 'c', d.Validation, "what to do with plays whose score, combo or dx score don't match their judgements: reject, warn, flag"),
+		logFile:        getopt.StringLong("log-file", 0, d.Log.File, "append the log to this file instead of stderr"),
+	}
+}
+
+// apply overrides cfg with the options that were given
+func (f flags) apply(cfg *config.Config) {
+	duration := func(secs int) config.Duration {
+		return config.Duration(time.Duration(secs) * time.Second)
+	}
+
+	if getopt.IsSet("songdb") {
+		cfg.Songdb = *f.songdb
+	}
+	if getopt.IsSet("playdb") {
+		cfg.Playdb = *f.playdb
+	}
+	if getopt.IsSet("data-source") {
+		cfg.Source.DataSource = *f.dataSource
+	}
+	if getopt.IsSet("verbose") {
+		cfg.Log.Verbose = *f.verbose
+	}
+	if getopt.IsSet("listen-port") {
+		cfg.Listen.Port = *f.listenPort
+	}
+	if getopt.IsSet("update-interval") {
+		cfg.Update.Interval = duration(*f.updateInterval)
+	}
+	if getopt.IsSet("api-interval") {
+		cfg.Update.ApiInterval = duration(*f.apiInterval)
+	}
+	if getopt.IsSet("backup-dir") {
+		cfg.Backup.Dir = *f.backupDir
+	}
+	if getopt.IsSet("backup-interval") {
+		cfg.Backup.Interval = duration(*f.backupInterval)
+	}
+	if getopt.IsSet("keep-daily") {
+		cfg.Backup.KeepDaily = *f.keepDaily
+	}
+	if getopt.IsSet("keep-weekly") {
+		cfg.Backup.KeepWeekly = *f.keepWeekly
+	}
+	if getopt.IsSet("validation") {
+		cfg.Validation = *f.validation
+	}
+	if getopt.IsSet("log-file") {
+		cfg.Log.File = *f.logFile
+	}
+}
+
+// loadConfig reads the config file and the environment, then applies the flags
+func loadConfig(filename string, f flags) (config.Config, error) {
+	cfg, err := config.Load(filename)
+	if err != nil {
+		return cfg, err
+	}
+
+	f.apply(&cfg)
+	return cfg, nil
+}
+
+// liveEnv is the env of the backend, updates and backups,
+// which is replaced when the configuration is reloaded
+type liveEnv struct {
+	mu  sync.Mutex
+	env app.Env
+}
+
+func (l *liveEnv) get() app.Env {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.env
+}
+
+func (l *liveEnv) set(env app.Env) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.env = env
+}
+
+// logOutput is where the log is written to
+type logOutput struct {
+	file *os.File // nil for stderr
+}
+
+// open sends the log to filename, or to stderr if it's "".
+// The file is reopened even if it's the same, so it can be rotated.
+func (o *logOutput) open(filename string) error {
+	var file *os.File
+	if filename != "" {
+		var err error
+		file, err = os.OpenFile(filename, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+		if err != nil {
+			return err
+		}
+		log.SetOutput(file)
+	} else {
+		log.SetOutput(os.Stderr)
+	}
+
+	o.close()
+	o.file = file
+	return nil
+}
+
+func (o *logOutput) close() {
+	if o.file != nil {
+		o.file.Close()
+	}
+}
@@ -0,0 +1,222 @@
+// Copyright (C) 2025 Ethan Cheng <ethan@nijika.org>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+// Package database handles the playlog and song database
+package database
+
+import (
+	"fmt"
+	"database/sql"
+)
+
+// kamaitachi trims the spaces song names in songs.json start or end with.
+// The name of 11422 is only a space, which the kamai updater handles itself.
+func addSourceTitleAliases(tx *sql.Tx) error {
+	_, err := tx.Exec(`
+	INSERT INTO song_aliases (song_id, alias) VALUES
+		(59, 'PON PON PON')
+	ON CONFLICT DO NOTHING;`)
+	if err != nil {
+		return err
+	}
+
+	return indexSong(tx, 59)
+}
+
+// removeEmptyAliases removes the empty alias of 11422,
+// which earlier versions of addSourceTitleAliases added
+func removeEmptyAliases(tx *sql.Tx) error {
+	rows, err := tx.Query(`SELECT DISTINCT song_id FROM song_aliases WHERE alias=''`)
+	if err != nil {
+		return err
+	}
+	var songIds []int
+	for rows.Next() {
+		var songId int
+		err = rows.Scan(&songId)
+		if err != nil {
+			rows.Close()
+			return err
+		}
+		songIds = append(songIds, songId)
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(`DELETE FROM song_aliases WHERE alias=''`)
+	if err != nil {
+		return err
+	}
+
+	for _, songId := range songIds {
+		err = indexSong(tx, songId)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// AddSongAlias adds alias as another name of a song.
+// Aliases are matched exactly by GetSongsByName, and like names by SearchSongs.
+// An empty alias results in an *EmptyAliasError.
+func (songdb *SongDB) AddSongAlias(songId int, alias string) error {
+	tx, err := songdb.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = addSongAlias(tx, SongAlias{SongId: songId, Alias: alias})
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// addSongAlias returns false if the song already had the alias
+func addSongAlias(tx *poolTx, alias SongAlias) (bool, error) {
+	if alias.Alias == "" {
+		return false, &EmptyAliasError{SongId: alias.SongId}
+	}
+
+	var exists int
+	err := tx.QueryRow(`SELECT COUNT(*) FROM songs WHERE song_id=?`, alias.SongId).Scan(&exists)
+	if err != nil {
+		return false, err
+	}
+	if exists == 0 {
+		return false, &SongNotFoundError{SongId: alias.SongId}
+	}
+
+	result, err := tx.Exec(`
+	INSERT INTO song_aliases (song_id, alias) VALUES (?, ?)
+	ON CONFLICT DO NOTHING;`, alias.SongId, alias.Alias)
+	if err != nil {
+		return false, err
+	}
+	added, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+
+	return added > 0, indexSong(tx, alias.SongId)
+}
+
+// ImportSongAliases adds the aliases that songs don't have yet,
+// and returns how many were added. Either every alias is imported,
+// or none are, e.g. if one is of a song that isn't in the song db
+// or is empty.
+func (songdb *SongDB) ImportSongAliases(aliases []SongAlias) (int, error) {
+	tx, err := songdb.db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	count := 0
+	for _, alias := range aliases {
+		added, err := addSongAlias(tx, alias)
+		if err != nil {
+			return 0, err
+		}
+		if added {
+			count++
+		}
+	}
+
+	return count, tx.Commit()
+}
+
+// RemoveSongAlias removes alias from the names of a song.
+// Removing an alias the song doesn't have isn't an error.
+func (songdb *SongDB) RemoveSongAlias(songId int, alias string) error {
+	tx, err := songdb.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec(`DELETE FROM song_aliases WHERE song_id=? AND alias=?`, songId, alias)
+	if err != nil {
+		return err
+	}
+
+	err = indexSong(tx, songId)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// GetSongAliases returns the aliases of a song in alphabetical order
+func (songdb *SongDB) GetSongAliases(songId int) ([]string, error) {
+	return getSongAliases(songdb.rdb, songId)
+}
+
+func getSongAliases(db queryer, songId int) ([]string, error) {
+	rows, err := db.Query(`SELECT alias FROM song_aliases WHERE song_id=? ORDER BY alias ASC`, songId)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	aliases := make([]string, 0)
+	for rows.Next() {
+		var alias string
+		err = rows.Scan(&alias)
+		if err != nil {
+			return nil, err
+		}
+		aliases = append(aliases, alias)
+	}
+
+	return aliases, rows.Err()
+}
+
+// GetAllSongAliases returns the aliases of every song, ordered by songId
+func (songdb *SongDB) GetAllSongAliases() ([]SongAlias, error) {
+	rows, err := songdb.rdb.Query(`
+		SELECT song_id, alias FROM song_aliases
+		ORDER BY song_id ASC, alias ASC`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	aliases := make([]SongAlias, 0)
+	for rows.Next() {
+		var alias SongAlias
+		err = rows.Scan(&alias.SongId, &alias.Alias)
+		if err != nil {
+			return nil, err
+		}
+		aliases = append(aliases, alias)
+	}
+
+	return aliases, rows.Err()
+}
+
+type EmptyAliasError struct {
+	SongId int
+}
+
+func (e *EmptyAliasError) Error() string {
+	return fmt.Sprintf("empty alias for song with id %d", e.SongId)
+}
@@ -0,0 +1,154 @@
+// Copyright (C) 2025 Ethan Cheng <ethan@nijika.org>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+// Package database handles the playlog and song database
+package database_test
+
+import (
+	"testing"
+	"reflect"
+	"path/filepath"
+	"database/sql"
+	_ "github.com/mattn/go-sqlite3"
+
+	"github.com/yadayadajaychan/playlog/database"
+)
+
+func TestSongAliases(t *testing.T) {
+	db, err := sql.Open("sqlite3", copyDB(t, "../songs.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	songdb, err := database.NewSongDB(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer songdb.Close()
+
+	// the titles kamaitachi uses are aliases from the start
+	for title, songId := range map[string]int{"PON PON PON": 59} {
+		songs, err := songdb.GetSongsByName(title)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(songs) != 1 || songs[0].SongId != songId {
+			t.Errorf("%q: expected song %d, got %+v", title, songId, songs)
+		}
+	}
+
+	aliases := []database.SongAlias{
+		{SongId: 792, Alias: "hibana"},
+		{SongId: 793, Alias: "loki"},
+		{SongId: 59, Alias: "PON PON PON"}, // already there
+	}
+	added, err := songdb.ImportSongAliases(aliases)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if added != 2 {
+		t.Errorf("expected 2 aliases to be added, got %d", added)
+	}
+
+	songs, err := songdb.GetSongsByName("hibana")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(songs) != 1 || songs[0].SongId != 792 {
+		t.Errorf("expected song 792 by its alias, got %+v", songs)
+	}
+
+	matches, err := songdb.SearchSongs("LOKI", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(matches) != 1 || matches[0].SongInfo.SongId != 793 || matches[0].Field != "alias" {
+		t.Errorf("expected song 793 by its alias, got %+v", matches)
+	}
+
+	got, err := songdb.GetAllSongAliases()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []database.SongAlias{
+		{SongId: 59, Alias: "PON PON PON"},
+		{SongId: 792, Alias: "hibana"},
+		{SongId: 793, Alias: "loki"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("expected %+v, got %+v", want, got)
+	}
+
+	// nothing is imported if a song isn't in the song db
+	_, err = songdb.ImportSongAliases([]database.SongAlias{
+		{SongId: 190, Alias: "mosaic roll"},
+		{SongId: 999999, Alias: "nothing"},
+	})
+	if _, ok := err.(*database.SongNotFoundError); !ok {
+		t.Errorf("expected SongNotFoundError, got %v", err)
+	}
+
+	names, err := songdb.GetSongAliases(190)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(names) != 0 {
+		t.Errorf("expected no aliases of song 190, got %v", names)
+	}
+	err = songdb.AddSongAlias(190, "")
+	if _, ok := err.(*database.EmptyAliasError); !ok {
+		t.Errorf("expected EmptyAliasError, got %v", err)
+	}
+}
+
+func TestRemoveEmptyAliases(t *testing.T) {
+	db, err := sql.Open("sqlite3", filepath.Join(t.TempDir(), "songs.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	songdb, err := database.NewSongDB(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = songdb.AddSong(database.SongInfo{SongId: 11422, Name: "\u3000", Type: "dx"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	songdb.Close()
+
+	// as left by version 7
+	_, err = db.Exec(`INSERT INTO song_aliases (song_id, alias) VALUES (11422, '')`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = db.Exec(`PRAGMA user_version = 7`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	songdb, err = database.NewSongDB(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer songdb.Close()
+
+	songs, err := songdb.GetSongsByName("")
+	if _, ok := err.(*database.SongNotFoundError); !ok {
+		t.Errorf("expected SongNotFoundError, got %+v, %v", songs, err)
+	}
+}
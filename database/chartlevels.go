@@ -0,0 +1,220 @@
+// Copyright (C) 2025 Ethan Cheng <ethan@nijika.org>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+// Package database handles the playlog and song database
+package database
+
+import (
+	"time"
+	"database/sql"
+)
+
+// chart_levels keeps the internal levels a chart has had over time.
+// charts.internal_level is always the current one, and is used for
+// charts without any history.
+func createChartLevelsTable(tx *sql.Tx) error {
+	_, err := tx.Exec(`
+	CREATE TABLE IF NOT EXISTS chart_levels (
+		song_id        INTEGER NOT NULL,
+		difficulty     INTEGER NOT NULL,
+		game_version   TEXT NOT NULL DEFAULT '',
+		effective_from INTEGER NOT NULL,
+		internal_level INTEGER NOT NULL,
+		PRIMARY KEY (song_id, difficulty, effective_from)
+	);`)
+	return err
+}
+
+// AddChartLevel records the internal level of a chart from level.EffectiveFrom,
+// replacing any level recorded for the same chart at the same time.
+// If the newest recorded level of the chart isn't its current level then,
+// e.g. because level is of a past game version, the current level is
+// recorded from now on, like songs sync does when a level changes.
+func (songdb *SongDB) AddChartLevel(level ChartLevel) error {
+	tx, err := songdb.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	err = addChartLevel(tx, level)
+	if err != nil {
+		return err
+	}
+
+	err = recordCurrentLevel(tx, level.SongId, level.Difficulty, time.Now().Unix())
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// recordCurrentLevel records the current internal level of a chart from now,
+// unless it already is the newest recorded level of the chart.
+// Charts that aren't in the charts table are left alone.
+func recordCurrentLevel(db queryer, songId int, difficulty Difficulty, now int64) error {
+	var current int
+	err := db.QueryRow(`
+		SELECT internal_level FROM charts WHERE song_id=? AND difficulty=? AND variant=0`,
+		songId, difficulty).Scan(&current)
+	if err == sql.ErrNoRows {
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	var newest int
+	err = db.QueryRow(`
+		SELECT internal_level FROM chart_levels
+		WHERE song_id=? AND difficulty=?
+		ORDER BY effective_from DESC LIMIT 1`,
+		songId, difficulty).Scan(&newest)
+	if err == nil && newest == current {
+		return nil
+	} else if err != nil && err != sql.ErrNoRows {
+		return err
+	}
+
+	return addChartLevel(db, ChartLevel{
+		SongId:        songId,
+		Difficulty:    difficulty,
+		EffectiveFrom: now,
+		InternalLevel: current,
+	})
+}
+
+// recordCurrentLevels is recordCurrentLevel for every chart with recorded
+// levels, for song dbs whose past levels were added before AddChartLevel
+// recorded the current ones
+func recordCurrentLevels(tx *sql.Tx) error {
+	type chart struct {
+		songId     int
+		difficulty Difficulty
+	}
+
+	rows, err := tx.Query(`SELECT DISTINCT song_id, difficulty FROM chart_levels`)
+	if err != nil {
+		return err
+	}
+	var charts []chart
+	for rows.Next() {
+		var c chart
+		err = rows.Scan(&c.songId, &c.difficulty)
+		if err != nil {
+			rows.Close()
+			return err
+		}
+		charts = append(charts, c)
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		return err
+	}
+
+	now := time.Now().Unix()
+	for _, c := range charts {
+		err = recordCurrentLevel(tx, c.songId, c.difficulty, now)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// execer is implemented by pools, transactions and *sql.Tx
+type execer interface {
+	Exec(query string, args ...any) (sql.Result, error)
+}
+
+func addChartLevel(db execer, level ChartLevel) error {
+	_, err := db.Exec(`
+	INSERT INTO chart_levels (
+		song_id, difficulty, game_version,
+		effective_from, internal_level) VALUES (
+		?, ?, ?,
+		?, ?
+	) ON CONFLICT (song_id, difficulty, effective_from) DO UPDATE SET
+		game_version=excluded.game_version,
+		internal_level=excluded.internal_level;`,
+		level.SongId, level.Difficulty, level.GameVersion,
+		level.EffectiveFrom, level.InternalLevel)
+	return err
+}
+
+// GetChartLevels returns the recorded internal levels of a chart, oldest first
+func (songdb *SongDB) GetChartLevels(songId int, difficulty Difficulty) ([]ChartLevel, error) {
+	rows, err := songdb.rdb.Query(`
+		SELECT song_id, difficulty, game_version, effective_from, internal_level
+		FROM chart_levels WHERE song_id=? AND difficulty=?
+		ORDER BY effective_from ASC`, songId, difficulty)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	levels := make([]ChartLevel, 0)
+	for rows.Next() {
+		level := ChartLevel{}
+		err = rows.Scan(&level.SongId, &level.Difficulty, &level.GameVersion,
+				&level.EffectiveFrom, &level.InternalLevel)
+		if err != nil {
+			return nil, err
+		}
+
+		levels = append(levels, level)
+	}
+
+	return levels, rows.Err()
+}
+
+// GetInternalLevelAt returns the internal level a chart had at date.
+// Dates before the first recorded level get the first recorded level,
+// and charts without recorded levels get their current level.
+// Levels are those of the difficulty's main chart (variant 0).
+func (songdb *SongDB) GetInternalLevelAt(songId int, difficulty Difficulty, date int64) (int, error) {
+	var level int
+
+	err := songdb.rdb.QueryRow(`
+		SELECT internal_level FROM chart_levels
+		WHERE song_id=? AND difficulty=? AND effective_from<=?
+		ORDER BY effective_from DESC LIMIT 1`,
+		songId, difficulty, date).Scan(&level)
+	if err == nil {
+		return level, nil
+	} else if err != sql.ErrNoRows {
+		return level, err
+	}
+
+	err = songdb.rdb.QueryRow(`
+		SELECT internal_level FROM chart_levels
+		WHERE song_id=? AND difficulty=?
+		ORDER BY effective_from ASC LIMIT 1`,
+		songId, difficulty).Scan(&level)
+	if err == nil {
+		return level, nil
+	} else if err != sql.ErrNoRows {
+		return level, err
+	}
+
+	err = songdb.rdb.QueryRow(`
+		SELECT internal_level FROM charts WHERE song_id=? AND difficulty=? AND variant=0`,
+		songId, difficulty).Scan(&level)
+	if err == sql.ErrNoRows {
+		return level, &ChartNotFoundError{SongId: songId, Difficulty: difficulty}
+	}
+
+	return level, err
+}
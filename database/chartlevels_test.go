@@ -0,0 +1,200 @@
+// Copyright (C) 2025 Ethan Cheng <ethan@nijika.org>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package database_test
+
+import (
+	"testing"
+	"time"
+	"path/filepath"
+	"database/sql"
+	_ "github.com/mattn/go-sqlite3"
+
+	"github.com/yadayadajaychan/playlog/database"
+)
+
+func TestGetInternalLevelAt(t *testing.T) {
+	db, err := sql.Open("sqlite3", filepath.Join(t.TempDir(), "songs.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	songdb, err := database.NewSongDB(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	song := database.SongInfo{
+		SongId: 11441,
+		Name:   "終焉逃避行",
+		Type:   "dx",
+		Charts: []database.ChartInfo{
+			{Difficulty: database.Expert, Level: 11, InternalLevel: 114},
+			{Difficulty: database.Master, Level: 13, InternalLevel: 137},
+		},
+	}
+	err = songdb.AddSong(song)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// no history, so the current level applies
+	level, err := songdb.GetInternalLevelAt(11441, database.Master, 1743108003)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if level != 137 {
+		t.Error("expected 137, got", level)
+	}
+
+	_, err = songdb.GetInternalLevelAt(11441, database.ReMaster, 1743108003)
+	if _, ok := err.(*database.ChartNotFoundError); !ok {
+		t.Error("expected ChartNotFoundError, got:", err)
+	}
+
+	// re-rated by songs sync
+	before := time.Now().Unix() - 1
+	song.Charts[1].InternalLevel = 138
+	_, err = songdb.UpsertSong(song)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	level, err = songdb.GetInternalLevelAt(11441, database.Master, before)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if level != 137 {
+		t.Error("before re-rating: expected 137, got", level)
+	}
+
+	level, err = songdb.GetInternalLevelAt(11441, database.Master, time.Now().Unix())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if level != 138 {
+		t.Error("after re-rating: expected 138, got", level)
+	}
+
+	// only a level of a past game version, the current level applies from now
+	err = songdb.AddChartLevel(database.ChartLevel{
+		SongId:        11441,
+		Difficulty:    database.Expert,
+		GameVersion:   "BUDDiES PLUS",
+		EffectiveFrom: 1710000000,
+		InternalLevel: 112,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, test := range []struct{ date int64; level int }{
+		{1720000000, 112},
+		{time.Now().Unix(), 114},
+	} {
+		level, err = songdb.GetInternalLevelAt(11441, database.Expert, test.date)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if level != test.level {
+			t.Errorf("expert at %d: expected %d, got %d", test.date, test.level, level)
+		}
+	}
+
+	err = songdb.AddChartLevel(database.ChartLevel{
+		SongId:        11441,
+		Difficulty:    database.Expert,
+		GameVersion:   "PRiSM",
+		EffectiveFrom: 1740000000,
+		InternalLevel: 114,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	levels, err := songdb.GetChartLevels(11441, database.Expert)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(levels) != 3 || levels[0].GameVersion != "BUDDiES PLUS" ||
+		levels[1].GameVersion != "PRiSM" || levels[2].InternalLevel != 114 {
+		t.Error("GetChartLevels:", levels)
+	}
+
+	for _, test := range []struct{ date int64; level int }{
+		{1700000000, 112}, // before the first recorded level
+		{1720000000, 112},
+		{1743108003, 114},
+		{time.Now().Unix(), 114},
+	} {
+		level, err = songdb.GetInternalLevelAt(11441, database.Expert, test.date)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if level != test.level {
+			t.Errorf("expert at %d: expected %d, got %d", test.date, test.level, level)
+		}
+	}
+}
+
+func TestRecordCurrentLevels(t *testing.T) {
+	db, err := sql.Open("sqlite3", filepath.Join(t.TempDir(), "songs.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	songdb, err := database.NewSongDB(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = songdb.AddSong(database.SongInfo{
+		SongId: 11441,
+		Name:   "終焉逃避行",
+		Type:   "dx",
+		Charts: []database.ChartInfo{{Difficulty: database.Master, Level: 13, InternalLevel: 137}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	songdb.Close()
+
+	// a level of a past game version, as added before version 9
+	_, err = db.Exec(`
+		INSERT INTO chart_levels (song_id, difficulty, game_version, effective_from, internal_level)
+		VALUES (11441, ?, 'PRiSM', 1740000000, 135)`, database.Master)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = db.Exec(`PRAGMA user_version = 8`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	songdb, err = database.NewSongDB(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer songdb.Close()
+
+	level, err := songdb.GetInternalLevelAt(11441, database.Master, time.Now().Unix())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if level != 137 {
+		t.Error("expected the current level 137, got", level)
+	}
+}
@@ -0,0 +1,143 @@
+// Copyright (C) 2025 Ethan Cheng <ethan@nijika.org>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package database_test
+
+import (
+	"testing"
+	"sync"
+	"path/filepath"
+	"database/sql"
+	_ "github.com/mattn/go-sqlite3"
+
+	"github.com/yadayadajaychan/playlog/database"
+)
+
+// TestConcurrentAccess adds plays from several goroutines and two
+// connections to the same file, as two playlog processes would,
+// while others read. None of them may fail with "database is locked".
+func TestConcurrentAccess(t *testing.T) {
+	src, err := sql.Open("sqlite3", copyDB(t, "../test/test-plays.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer src.Close()
+
+	srcdb, err := database.NewPlayDB(src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer srcdb.Close()
+
+	plays, err := srcdb.GetPlays(true, 1000, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	filename := filepath.Join(t.TempDir(), "plays.db")
+	playdbs := make([]*database.PlayDB, 2)
+	for i := range playdbs {
+		db, err := sql.Open("sqlite3", filename)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer db.Close()
+
+		playdbs[i], err = database.NewPlayDB(db)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer playdbs[i].Close()
+	}
+
+	const writers = 8
+	const readers = 8
+
+	var wg sync.WaitGroup
+	errs := make(chan error, writers+readers)
+	done := make(chan struct{})
+
+	for w := 0; w < writers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			playdb := playdbs[w%len(playdbs)]
+			for i := w; i < len(plays); i += writers {
+				play := plays[i]
+				play.PlayId = 0
+				err := playdb.AddPlay(play)
+				if err != nil {
+					errs <- err
+					return
+				}
+			}
+		}(w)
+	}
+
+	var readWg sync.WaitGroup
+	for r := 0; r < readers; r++ {
+		readWg.Add(1)
+		go func(r int) {
+			defer readWg.Done()
+			playdb := playdbs[r%len(playdbs)]
+			for {
+				select {
+				case <-done:
+					return
+				default:
+				}
+
+				_, err := playdb.GetCount()
+				if err != nil {
+					errs <- err
+					return
+				}
+
+				page, err := playdb.GetPlays(r%2 == 0, 50, 0)
+				if err != nil {
+					errs <- err
+					return
+				}
+
+				if len(page) > 0 {
+					_, err = playdb.GetPlay(page[0].UserPlayDate)
+					if err != nil {
+						errs <- err
+						return
+					}
+				}
+			}
+		}(r)
+	}
+
+	wg.Wait()
+	close(done)
+	readWg.Wait()
+	close(errs)
+
+	for err := range errs {
+		t.Error(err)
+	}
+
+	for _, playdb := range playdbs {
+		count, err := playdb.GetCount()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if count != len(plays) {
+			t.Errorf("expected %d plays, got %d", len(plays), count)
+		}
+	}
+}
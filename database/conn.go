@@ -0,0 +1,169 @@
+// Copyright (C) 2025 Ethan Cheng <ethan@nijika.org>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+// Package database handles the playlog and song database
+package database
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"net/url"
+	"strconv"
+)
+
+// busyTimeout is how many milliseconds a connection waits for a lock
+// held by another connection, e.g. of another playlog process,
+// before failing with "database is locked"
+const busyTimeout = 5000
+
+// dsnConnector opens connections to dsn with the driver of an existing *sql.DB,
+// so the database package doesn't depend on the name of the sqlite driver
+type dsnConnector struct {
+	dsn    string
+	driver driver.Driver
+}
+
+func (c dsnConnector) Connect(context.Context) (driver.Conn, error) {
+	return c.driver.Open(c.dsn)
+}
+
+func (c dsnConnector) Driver() driver.Driver {
+	return c.driver
+}
+
+// openPools sets up the sqlite database db for use by one writer and many
+// readers at once, and returns a pool to write to and a pool to read from.
+//
+// The database is put in WAL mode, so readers don't block the writer
+// and the other way around. The write pool has a single connection, so writes
+// of this process wait for each other, and starts transactions with
+// BEGIN IMMEDIATE, so a transaction that reads before it writes waits for
+// writers of other processes instead of failing when it tries to write.
+// Reads use a separate pool of read-only connections.
+// In-memory databases can't be shared between connections,
+// so db itself is used for both.
+func openPools(db *sql.DB) (wdb, rdb *pool, err error) {
+	file, err := databaseFile(db)
+	if err != nil {
+		return nil, nil, err
+	}
+	if file == "" {
+		p := &pool{DB: db}
+		return p, p, nil
+	}
+
+	var mode string
+	err = db.QueryRow(`PRAGMA journal_mode = WAL`).Scan(&mode)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	u := url.URL{Scheme: "file", Path: file}
+	dsn := u.String() + "?_busy_timeout=" + strconv.Itoa(busyTimeout)
+
+	wdb = &pool{DB: sql.OpenDB(dsnConnector{dsn: dsn + "&_txlock=immediate", driver: db.Driver()})}
+	wdb.SetMaxOpenConns(1)
+
+	rdb = &pool{DB: sql.OpenDB(dsnConnector{dsn: dsn + "&mode=ro", driver: db.Driver()})}
+
+	for _, p := range []*pool{wdb, rdb} {
+		err = p.Ping()
+		if err != nil {
+			wdb.Close()
+			rdb.Close()
+			return nil, nil, err
+		}
+	}
+
+	return wdb, rdb, nil
+}
+
+// closePools closes the pools returned by openPools for conn
+func closePools(wdb, rdb *pool, conn *sql.DB) error {
+	if wdb.DB == conn {
+		return nil
+	}
+
+	err := rdb.Close()
+	if err2 := wdb.Close(); err == nil {
+		err = err2
+	}
+	return err
+}
+
+// pool is a *sql.DB whose queries run with its context
+type pool struct {
+	*sql.DB
+	ctx context.Context // nil for context.Background()
+}
+
+// withContext returns a copy of p whose queries run with ctx
+func (p *pool) withContext(ctx context.Context) *pool {
+	q := *p
+	q.ctx = ctx
+	return &q
+}
+
+func (p *pool) context() context.Context {
+	if p.ctx == nil {
+		return context.Background()
+	}
+	return p.ctx
+}
+
+func (p *pool) Exec(query string, args ...any) (sql.Result, error) {
+	return p.DB.ExecContext(p.context(), query, args...)
+}
+
+func (p *pool) Query(query string, args ...any) (*sql.Rows, error) {
+	return p.DB.QueryContext(p.context(), query, args...)
+}
+
+func (p *pool) QueryRow(query string, args ...any) *sql.Row {
+	return p.DB.QueryRowContext(p.context(), query, args...)
+}
+
+func (p *pool) Prepare(query string) (*sql.Stmt, error) {
+	return p.DB.PrepareContext(p.context(), query)
+}
+
+// Begin starts a transaction, which is rolled back if the context of p
+// is cancelled before it is committed
+func (p *pool) Begin() (*poolTx, error) {
+	tx, err := p.DB.BeginTx(p.context(), nil)
+	if err != nil {
+		return nil, err
+	}
+	return &poolTx{Tx: tx, ctx: p.context()}, nil
+}
+
+// poolTx is a transaction of a pool
+type poolTx struct {
+	*sql.Tx
+	ctx context.Context
+}
+
+func (tx *poolTx) Exec(query string, args ...any) (sql.Result, error) {
+	return tx.Tx.ExecContext(tx.ctx, query, args...)
+}
+
+func (tx *poolTx) Query(query string, args ...any) (*sql.Rows, error) {
+	return tx.Tx.QueryContext(tx.ctx, query, args...)
+}
+
+func (tx *poolTx) QueryRow(query string, args ...any) *sql.Row {
+	return tx.Tx.QueryRowContext(tx.ctx, query, args...)
+}
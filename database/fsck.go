@@ -0,0 +1,183 @@
+// Copyright (C) 2025 Ethan Cheng <ethan@nijika.org>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+// Package database handles the playlog and song database
+package database
+
+import (
+	"fmt"
+)
+
+// DuplicateWindow is how many seconds apart two plays of the same chart
+// can be before Fsck no longer reports them as a suspected duplicate.
+// No chart is that short, so such plays are usually imported twice.
+const DuplicateWindow = 60
+
+// kinds of FsckProblem
+const (
+	FsckTotals     = "totals"     // Total* don't match the detailed judgements
+	FsckValidation = "validation" // score, combo or dx score don't match the judgements
+	FsckChart      = "chart"      // the chart isn't in the song db or doesn't match the play
+	FsckDuplicate  = "duplicate"  // same chart played again within DuplicateWindow
+)
+
+// FsckProblem is a problem with one play found by Fsck
+type FsckProblem struct {
+	PlayId       int64
+	UserPlayDate int64
+	Kind         string
+	Message      string
+	Repaired     bool
+}
+
+// FsckReport is the result of Fsck
+type FsckReport struct {
+	Plays    int // no. of plays checked
+	Problems []FsckProblem
+}
+
+// Unrepaired returns the no. of problems that weren't repaired
+func (r FsckReport) Unrepaired() int {
+	n := 0
+	for _, p := range r.Problems {
+		if !p.Repaired {
+			n++
+		}
+	}
+	return n
+}
+
+// Fsck checks every play of the player against the validation of AddPlay
+// and the charts in songdb, and looks for suspected duplicates.
+// With repair, problems that can be fixed safely are: Total* fields are
+// recomputed from the detailed judgements. Nothing else is changed.
+func (playdb *PlayDB) Fsck(songdb SongStore, repair bool) (FsckReport, error) {
+	report := FsckReport{Problems: make([]FsckProblem, 0)}
+
+	count, err := playdb.GetCount()
+	if err != nil {
+		return report, err
+	}
+
+	plays, err := playdb.GetPlays(true, count, 0)
+	if err != nil {
+		return report, err
+	}
+	report.Plays = len(plays)
+
+	songs := make(map[int]SongInfo)
+	type chartKey struct {
+		songId     int
+		difficulty Difficulty
+		variant    int
+	}
+	lastPlayed := make(map[chartKey]PlayInfo)
+
+	for _, play := range plays {
+		problem := func(kind, msg string) *FsckProblem {
+			report.Problems = append(report.Problems, FsckProblem{
+				PlayId: play.PlayId, UserPlayDate: play.UserPlayDate,
+				Kind: kind, Message: msg,
+			})
+			return &report.Problems[len(report.Problems)-1]
+		}
+
+		if totals, ok := detailedTotals(play); ok && totals != currentTotals(play) {
+			p := problem(FsckTotals, fmt.Sprintf("totals %v do not match detailed judgements %v",
+				currentTotals(play), totals))
+
+			play.TotalCriticalPerfect, play.TotalPerfect, play.TotalGreat,
+				play.TotalGood, play.TotalMiss = totals[0], totals[1], totals[2], totals[3], totals[4]
+
+			if repair {
+				err = playdb.setTotals(play)
+				if err != nil {
+					return report, err
+				}
+				p.Repaired = true
+			}
+		}
+
+		for _, msg := range checkPlay(play) {
+			problem(FsckValidation, msg)
+		}
+
+		song, ok := songs[play.SongId]
+		if !ok {
+			song, err = songdb.GetSong(play.SongId)
+			if _, notFound := err.(*SongNotFoundError); notFound {
+				song = SongInfo{SongId: play.SongId}
+			} else if err != nil {
+				return report, err
+			}
+			songs[play.SongId] = song
+		}
+
+		chart, ok := song.Chart(play.Difficulty, play.Variant)
+		if song.Charts == nil {
+			problem(FsckChart, fmt.Sprintf("song %d not found in song db", play.SongId))
+		} else if !ok {
+			problem(FsckChart, fmt.Sprintf("song %d has no %s chart (variant %d) in song db",
+				play.SongId, play.Difficulty, play.Variant))
+		} else if err := ValidatePlayAgainstChart(play, chart); err != nil {
+			problem(FsckChart, err.Error())
+		}
+
+		key := chartKey{play.SongId, play.Difficulty, play.Variant}
+		if last, ok := lastPlayed[key]; ok && play.UserPlayDate-last.UserPlayDate < DuplicateWindow {
+			problem(FsckDuplicate, fmt.Sprintf("same chart played %d seconds after play %d (id %d)",
+				play.UserPlayDate-last.UserPlayDate, last.UserPlayDate, last.PlayId))
+		}
+		lastPlayed[key] = play
+	}
+
+	return report, nil
+}
+
+// detailedTotals returns the Total* fields computed from the judgements
+// of each note type, or false if play has no detailed judgements
+func detailedTotals(play PlayInfo) ([5]int, bool) {
+	totals := [5]int{
+		play.TapCriticalPerfect + play.HoldCriticalPerfect + play.SlideCriticalPerfect +
+			play.TouchCriticalPerfect + play.BreakCriticalPerfect,
+		play.TapPerfect + play.HoldPerfect + play.SlidePerfect +
+			play.TouchPerfect + play.BreakPerfect,
+		play.TapGreat + play.HoldGreat + play.SlideGreat +
+			play.TouchGreat + play.BreakGreat,
+		play.TapGood + play.HoldGood + play.SlideGood +
+			play.TouchGood + play.BreakGood,
+		play.TapMiss + play.HoldMiss + play.SlideMiss +
+			play.TouchMiss + play.BreakMiss,
+	}
+
+	return totals, totals != [5]int{}
+}
+
+func currentTotals(play PlayInfo) [5]int {
+	return [5]int{play.TotalCriticalPerfect, play.TotalPerfect, play.TotalGreat,
+		play.TotalGood, play.TotalMiss}
+}
+
+func (playdb *PlayDB) setTotals(play PlayInfo) error {
+	_, err := playdb.db.Exec(`
+	UPDATE plays SET
+		total_critical_perfect=?, total_perfect=?, total_great=?,
+		total_good=?, total_miss=?
+	WHERE player_id=? AND play_id=?`,
+		play.TotalCriticalPerfect, play.TotalPerfect, play.TotalGreat,
+		play.TotalGood, play.TotalMiss,
+		playdb.playerId, play.PlayId)
+	return err
+}
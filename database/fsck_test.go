@@ -0,0 +1,108 @@
+// Copyright (C) 2025 Ethan Cheng <ethan@nijika.org>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package database_test
+
+import (
+	"testing"
+	"database/sql"
+	_ "github.com/mattn/go-sqlite3"
+
+	"github.com/yadayadajaychan/playlog/database"
+)
+
+func TestFsck(t *testing.T) {
+	db, err := sql.Open("sqlite3", copyDB(t, "../test/test-plays.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	db2, err := sql.Open("sqlite3", copyDB(t, "../songs.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db2.Close()
+
+	playdb, err := database.NewPlayDB(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	songdb, err := database.NewSongDB(db2)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	report, err := playdb.Fsck(songdb, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Plays != 200 || len(report.Problems) != 0 {
+		t.Fatal("unexpected report for test-plays.db:", report)
+	}
+
+	// break the first 2 plays, and import the second one again 30 seconds later
+	_, err = db.Exec(`UPDATE plays SET total_perfect=total_perfect+1 WHERE play_id=1`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = db.Exec(`UPDATE plays SET song_id=999999 WHERE play_id=2`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	play, err := playdb.GetPlay(1743108219)
+	if err != nil {
+		t.Fatal(err)
+	}
+	play.UserPlayDate += 30
+	play.SourceId = ""
+	err = playdb.AddPlay(play)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	report, err = playdb.Fsck(songdb, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	kinds := make(map[string]int)
+	for _, p := range report.Problems {
+		kinds[p.Kind]++
+		if p.Repaired {
+			t.Error("problem repaired without repair:", p)
+		}
+	}
+	if kinds[database.FsckTotals] != 1 || kinds[database.FsckChart] != 2 ||
+	   kinds[database.FsckDuplicate] != 1 || len(report.Problems) != 4 {
+		t.Error("unexpected problems:", report.Problems)
+	}
+
+	report, err = playdb.Fsck(songdb, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Unrepaired() != 3 {
+		t.Error("expected 3 unrepaired problems, got", report.Unrepaired())
+	}
+
+	play1, err := playdb.GetPlay(1743108003)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if play1.TotalPerfect != 290 {
+		t.Error("TotalPerfect not repaired:", play1.TotalPerfect)
+	}
+}
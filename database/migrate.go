@@ -0,0 +1,211 @@
+// Copyright (C) 2025 Ethan Cheng <ethan@nijika.org>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+// Package database handles the playlog and song database
+package database
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+// migration upgrades a database schema by one version.
+// Migrations are applied in order and each one runs in its own transaction,
+// which also sets PRAGMA user_version to the migration's version.
+type migration struct {
+	version     int
+	description string
+	up          func(tx *sql.Tx) error
+}
+
+// Migration describes a pending schema migration
+type Migration struct {
+	Version     int
+	Description string
+}
+
+type SchemaTooNewError struct {
+	Database  string // "play" or "song"
+	Version   int    // schema version of the database
+	Supported int    // newest schema version supported by this program
+}
+
+func (e *SchemaTooNewError) Error() string {
+	return fmt.Sprintf("%s db has schema version %d, but this version of playlog only supports up to %d",
+		e.Database, e.Version, e.Supported)
+}
+
+// PendingPlayMigrations returns the migrations NewPlayDB would apply to db
+// without changing anything
+func PendingPlayMigrations(db *sql.DB) ([]Migration, error) {
+	return pendingMigrations(db, "play", playMigrations)
+}
+
+// PendingSongMigrations returns the migrations NewSongDB would apply to db
+// without changing anything
+func PendingSongMigrations(db *sql.DB) ([]Migration, error) {
+	return pendingMigrations(db, "song", songMigrations)
+}
+
+func schemaVersion(db *sql.DB) (int, error) {
+	var version int
+	err := db.QueryRow(`PRAGMA user_version`).Scan(&version)
+	return version, err
+}
+
+func latestVersion(migrations []migration) int {
+	if len(migrations) == 0 {
+		return 0
+	}
+	return migrations[len(migrations)-1].version
+}
+
+func pendingMigrations(db *sql.DB, name string, migrations []migration) ([]Migration, error) {
+	version, err := schemaVersion(db)
+	if err != nil {
+		return nil, err
+	}
+
+	latest := latestVersion(migrations)
+	if version > latest {
+		return nil, &SchemaTooNewError{Database: name, Version: version, Supported: latest}
+	}
+
+	pending := make([]Migration, 0, len(migrations))
+	for _, m := range migrations {
+		if m.version > version {
+			pending = append(pending, Migration{Version: m.version, Description: m.description})
+		}
+	}
+
+	return pending, nil
+}
+
+// migrate applies all migrations newer than the current schema version.
+// If the database already contains tables, it is backed up first.
+func migrate(db *sql.DB, name string, migrations []migration) error {
+	pending, err := pendingMigrations(db, name, migrations)
+	if err != nil {
+		return err
+	}
+	if len(pending) == 0 {
+		return nil
+	}
+
+	version, err := schemaVersion(db)
+	if err != nil {
+		return err
+	}
+
+	empty, err := isEmpty(db)
+	if err != nil {
+		return err
+	}
+	if !empty {
+		_, err = backupBeforeMigrate(db, version)
+		if err != nil {
+			return fmt.Errorf("backup before migrating %s db: %w", name, err)
+		}
+	}
+
+	for _, m := range migrations {
+		if m.version <= version {
+			continue
+		}
+
+		err = applyMigration(db, m)
+		if err != nil {
+			return fmt.Errorf("migrating %s db to version %d (%s): %w",
+				name, m.version, m.description, err)
+		}
+	}
+
+	return nil
+}
+
+func applyMigration(db *sql.DB, m migration) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	err = m.up(tx)
+	if err != nil {
+		return err
+	}
+
+	// user_version is stored in the database header, so setting it
+	// inside the transaction keeps it in step with the schema
+	_, err = tx.Exec(fmt.Sprintf(`PRAGMA user_version = %d`, m.version))
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// isEmpty reports whether db has no tables yet
+func isEmpty(db *sql.DB) (bool, error) {
+	var count int
+	err := db.QueryRow(`SELECT COUNT(*) FROM sqlite_master WHERE type='table'`).Scan(&count)
+	return count == 0, err
+}
+
+// databaseFile returns the filename of the main database,
+// or "" if it is an in-memory or temporary database
+func databaseFile(db *sql.DB) (string, error) {
+	rows, err := db.Query(`PRAGMA database_list`)
+	if err != nil {
+		return "", err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var seq int
+		var name, file string
+		err = rows.Scan(&seq, &name, &file)
+		if err != nil {
+			return "", err
+		}
+
+		if name == "main" {
+			return file, nil
+		}
+	}
+
+	return "", rows.Err()
+}
+
+// backupBeforeMigrate copies db next to the original file as
+// <file>.v<version>.<unix time>.bak and returns the backup's filename
+func backupBeforeMigrate(db *sql.DB, version int) (string, error) {
+	file, err := databaseFile(db)
+	if err != nil {
+		return "", err
+	}
+	if file == "" {
+		return "", nil
+	}
+
+	backup := fmt.Sprintf("%s.v%d.%d.bak", file, version, time.Now().Unix())
+	_, err = db.Exec(`VACUUM INTO ?`, backup)
+	if err != nil {
+		return "", err
+	}
+
+	return backup, nil
+}
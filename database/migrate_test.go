@@ -0,0 +1,164 @@
+// Copyright (C) 2025 Ethan Cheng <ethan@nijika.org>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package database_test
+
+import (
+	"testing"
+	"path/filepath"
+	"database/sql"
+	_ "github.com/mattn/go-sqlite3"
+
+	"github.com/yadayadajaychan/playlog/database"
+)
+
+func userVersion(t *testing.T, db *sql.DB) int {
+	t.Helper()
+
+	var version int
+	err := db.QueryRow(`PRAGMA user_version`).Scan(&version)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return version
+}
+
+func TestMigrateNewPlayDB(t *testing.T) {
+	dir := t.TempDir()
+	db, err := sql.Open("sqlite3", filepath.Join(dir, "plays.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	pending, err := database.PendingPlayMigrations(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pending) == 0 {
+		t.Fatal("expected pending migrations for a new db")
+	}
+
+	_, err = database.NewPlayDB(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if v := userVersion(t, db); v != pending[len(pending)-1].Version {
+		t.Errorf("user_version: expected %d, got %d", pending[len(pending)-1].Version, v)
+	}
+
+	pending, err = database.PendingPlayMigrations(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pending) != 0 {
+		t.Error("expected no pending migrations after NewPlayDB")
+	}
+
+	// nothing to back up in a new db
+	backups, err := filepath.Glob(filepath.Join(dir, "*.bak"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(backups) != 0 {
+		t.Error("unexpected backup of new db:", backups)
+	}
+}
+
+func TestMigrateExistingPlayDB(t *testing.T) {
+	filename := copyDB(t, "../test/test-plays.db")
+	db, err := sql.Open("sqlite3", filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	// dry run must not touch the db
+	pending, err := database.PendingPlayMigrations(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pending) == 0 {
+		t.Fatal("expected pending migrations for test-plays.db")
+	}
+	if v := userVersion(t, db); v != 0 {
+		t.Fatalf("dry run changed user_version to %d", v)
+	}
+
+	playdb, err := database.NewPlayDB(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	count, err := playdb.GetCount()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != 200 {
+		t.Error("count != 200 after migrating")
+	}
+
+	backups, err := filepath.Glob(filename + ".v0.*.bak")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(backups) != 1 {
+		t.Fatal("expected one backup, got", backups)
+	}
+
+	bak, err := sql.Open("sqlite3", backups[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer bak.Close()
+
+	if v := userVersion(t, bak); v != 0 {
+		t.Errorf("backup has user_version %d, expected 0", v)
+	}
+	var bakCount int
+	err = bak.QueryRow(`SELECT COUNT(*) FROM plays`).Scan(&bakCount)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bakCount != 200 {
+		t.Error("backup count != 200")
+	}
+}
+
+func TestSchemaTooNew(t *testing.T) {
+	db, err := sql.Open("sqlite3", filepath.Join(t.TempDir(), "songs.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	_, err = db.Exec(`PRAGMA user_version = 9999`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = database.NewSongDB(db)
+	if e, ok := err.(*database.SchemaTooNewError); ok {
+		t.Log("correctly returned SchemaTooNewError:", e)
+	} else {
+		t.Error("expected SchemaTooNewError, got:", err)
+	}
+
+	_, err = database.PendingSongMigrations(db)
+	if _, ok := err.(*database.SchemaTooNewError); !ok {
+		t.Error("expected SchemaTooNewError from PendingSongMigrations, got:", err)
+	}
+}
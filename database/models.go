@@ -16,6 +16,11 @@
 // Package database handles the playlog and song database
 package database
 
+import (
+	"fmt"
+	"strings"
+)
+
 type Difficulty int
 const (
 	Basic Difficulty = iota
@@ -26,6 +31,15 @@ const (
 	Utage
 )
 
+var difficultyNames = []string{"basic", "advanced", "expert", "master", "re:master", "utage"}
+
+func (d Difficulty) String() string {
+	if d >= 0 && int(d) < len(difficultyNames) {
+		return difficultyNames[d]
+	}
+	return fmt.Sprintf("Difficulty(%d)", int(d))
+}
+
 type ComboStatus int
 const (
 	NoCombo ComboStatus = iota
@@ -44,6 +58,14 @@ const (
 	FullSyncDxPlus
 )
 
+type PlayerInfo struct {
+	PlayerId	int64
+	Name		string
+	DataSource	string // "solips", "kamai", or "" to use the program's default
+	AccessCode	string // Mythos Access Code, used by solips
+	KamaiUser	string // kamaitachi username
+}
+
 type SongInfo struct {
 	SongId		int
 	Name		string
@@ -56,18 +78,82 @@ type SongInfo struct {
 	Charts		[]ChartInfo
 }
 
+// Chart returns the chart of song with the given difficulty and variant
+func (song SongInfo) Chart(difficulty Difficulty, variant int) (ChartInfo, bool) {
+	for _, chart := range song.Charts {
+		if chart.Difficulty == difficulty && chart.Variant == variant {
+			return chart, true
+		}
+	}
+
+	return ChartInfo{}, false
+}
+
+// ParseUtageKind returns the kind of an utage song from the kanji
+// its name starts with, e.g. "協" for "[協]Love You", or "" if there is none
+func ParseUtageKind(name string) string {
+	if !strings.HasPrefix(name, "[") {
+		return ""
+	}
+
+	end := strings.Index(name, "]")
+	if end < 0 {
+		return ""
+	}
+
+	return name[1:end]
+}
+
+// BuddyUtageKind is the UtageKind of buddy charts
+const BuddyUtageKind = "協"
+
 type ChartInfo struct {
 	Difficulty	Difficulty
+	// tells charts of the same difficulty apart, e.g. the utage charts
+	// of a song or the two sides of a buddy chart. 0 for most charts.
+	Variant		int
+	UtageKind	string // e.g. "協" or "蛸" for utage charts, "" otherwise
+	Buddy		bool // 2 player chart, each player plays a different variant
+	Label		string // display name of the variant, e.g. "[協] 1P"
 	Level		int
 	InternalLevel	int // multiplied by 10
 	NotesDesigner	string
 	MaxNotes	int
+
+	// no. of notes of each type, 0 if unknown
+	TapNotes	int
+	HoldNotes	int
+	SlideNotes	int
+	TouchNotes	int
+	BreakNotes	int
+}
+
+// ChartLevel is the internal level of a chart from EffectiveFrom until
+// the next ChartLevel of the same chart
+type ChartLevel struct {
+	SongId		int
+	Difficulty	Difficulty
+	GameVersion	string // e.g. "PRiSM", or "" if unknown
+	EffectiveFrom	int64 // Unix timestamp
+	InternalLevel	int // multiplied by 10
+}
+
+// SongAlias is another name of a song, e.g. a nickname,
+// or its title in a data source that names it differently
+type SongAlias struct {
+	SongId	int
+	Alias	string
 }
 
 type PlayInfo struct {
+	PlayId		int64 // assigned by the database
 	UserPlayDate	int64 // Unix timestamp
 	SongId		int
 	Difficulty	Difficulty
+	Variant		int // see ChartInfo.Variant
+
+	Source		string // where the play was imported from, e.g. "solips"
+	SourceId	string // id of the play at Source, if any
 
 	Score		int
 	DxScore		int
@@ -124,4 +210,7 @@ type PlayInfo struct {
 	TotalGreat		int
 	TotalGood		int
 	TotalMiss		int
+
+	// why the play failed validation, if it was added with FlagInvalid
+	ValidationErrors	[]string
 }
@@ -21,28 +21,101 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"log"
 )
 
+// PlayDB holds the plays of every player. Its methods only see the plays of
+// one player, which is DefaultPlayerId unless the PlayDB came from ForPlayer.
 type PlayDB struct {
-	db *sql.DB
+	conn *sql.DB // passed to NewPlayDB
+	db   *pool   // pool to write to, see openPools
+	rdb  *pool   // pool to read from
 	ascStmt  *sql.Stmt // used to query playlog entries by ascending order
 	descStmt *sql.Stmt // used to query playlog entries by descending order
+
+	playerId int64
+
+	validationMode ValidationMode
 }
 
+// NewPlayDB creates a PlayDB object and initializes the database.
+// After migrating, db is only used to find the database file, which is then
+// read and written through pools of its own. Close closes them.
 func NewPlayDB(db *sql.DB) (*PlayDB, error) {
-	playdb := &PlayDB{db: db}
+	playdb := &PlayDB{conn: db, playerId: DefaultPlayerId}
 	err := playdb.initDB()
 	return playdb, err
 }
 
+// Close closes the pools of playdb and of the PlayDBs derived from it
+// with ForPlayer and WithValidationMode. db passed to NewPlayDB is left open.
+func (playdb *PlayDB) Close() error {
+	return closePools(playdb.db, playdb.rdb, playdb.conn)
+}
+
+// ForPlayer returns a PlayDB that shares the database with playdb,
+// but reads and writes the plays of playerId
+func (playdb *PlayDB) ForPlayer(playerId int64) *PlayDB {
+	p := *playdb
+	p.playerId = playerId
+	return &p
+}
+
+// WithValidationMode returns a PlayDB that shares the database with playdb,
+// but handles plays that fail validation according to mode
+func (playdb *PlayDB) WithValidationMode(mode ValidationMode) *PlayDB {
+	p := *playdb
+	p.validationMode = mode
+	return &p
+}
+
+// PlayerId returns the id of the player whose plays playdb reads and writes
+func (playdb *PlayDB) PlayerId() int64 {
+	return playdb.playerId
+}
+
+var playMigrations = []migration{
+	{1, "create plays table", createPlaysTable},
+	{2, "identify plays by play_id and (player, date, song, difficulty)", addPlayIds},
+	{3, "create players table", createPlayersTable},
+	{4, "add chart variant to plays", addPlayVariant},
+	{5, "create quarantine table", createQuarantineTable},
+	{6, "add validation errors to plays", addPlayValidationErrors},
+	{7, "make source ids unique per player", indexSourceIdsPerPlayer},
+}
+
 func (playdb *PlayDB) initDB() error {
-	tx, err := playdb.db.Begin()
+	err := migrate(playdb.conn, "play", playMigrations)
 	if err != nil {
 		return err
 	}
-	defer tx.Rollback()
 
-	_, err = tx.Exec(`
+	playdb.db, playdb.rdb, err = openPools(playdb.conn)
+	if err != nil {
+		return err
+	}
+
+	playdb.ascStmt, err = playdb.rdb.Prepare(
+		`SELECT `+playColumns+` FROM plays WHERE player_id=?
+		ORDER BY user_play_date ASC, play_id ASC
+		LIMIT ? OFFSET ?`)
+	if err != nil {
+		return err
+	}
+
+	playdb.descStmt, err = playdb.rdb.Prepare(
+		`SELECT `+playColumns+` FROM plays WHERE player_id=?
+		ORDER BY user_play_date DESC, play_id DESC
+		LIMIT ? OFFSET ?`)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func createPlaysTable(tx *sql.Tx) error {
+	_, err := tx.Exec(`
 	CREATE TABLE IF NOT EXISTS plays (
 		user_play_date INTEGER PRIMARY KEY NOT NULL,
 		song_id        INTEGER NOT NULL,
@@ -104,32 +177,202 @@ func (playdb *PlayDB) initDB() error {
 		total_good             INTEGER,
 		total_miss             INTEGER
 	);`)
+	return err
+}
+
+// addPlayIds replaces user_play_date as the primary key of plays.
+// Plays are identified by a surrogate play_id, and deduplicated on
+// (player_id, user_play_date, song_id, difficulty). The id of the play
+// at its source (e.g. solips' PlaylogApiId) is kept in source_id.
+func addPlayIds(tx *sql.Tx) error {
+	const legacyColumns = `
+		user_play_date, song_id, difficulty,
+
+		score, dx_score, combo_status, sync_status,
+		is_clear, is_new_record, is_dx_new_record,
+		track, matching_users,
+
+		max_combo, total_combo, max_sync, total_sync,
+
+		fast_count, late_count, before_rating, after_rating,
+
+		tap_critical_perfect, tap_perfect, tap_great,
+		tap_good, tap_miss,
+
+		hold_critical_perfect, hold_perfect, hold_great,
+		hold_good, hold_miss,
+
+		slide_critical_perfect, slide_perfect, slide_great,
+		slide_good, slide_miss,
+
+		touch_critical_perfect, touch_perfect, touch_great,
+		touch_good, touch_miss,
+
+		break_critical_perfect, break_perfect, break_great,
+		break_good, break_miss,
+
+		total_critical_perfect, total_perfect, total_great,
+		total_good, total_miss`
+
+	_, err := tx.Exec(`
+	CREATE TABLE plays_new (
+		play_id        INTEGER PRIMARY KEY AUTOINCREMENT,
+		player_id      INTEGER NOT NULL DEFAULT 1,
+		user_play_date INTEGER NOT NULL,
+		song_id        INTEGER NOT NULL,
+		difficulty     INTEGER NOT NULL,
+
+		source    TEXT,
+		source_id TEXT,
+
+		score            INTEGER,
+		dx_score         INTEGER,
+		combo_status     INTEGER,
+		sync_status      INTEGER,
+		is_clear         INTEGER,
+		is_new_record    INTEGER,
+		is_dx_new_record INTEGER,
+		track            INTEGER,
+		matching_users   TEXT,
+
+		max_combo   INTEGER,
+		total_combo INTEGER,
+		max_sync    INTEGER,
+		total_sync  INTEGER,
+
+		fast_count    INTEGER,
+		late_count    INTEGER,
+		before_rating INTEGER,
+		after_rating  INTEGER,
+
+		tap_critical_perfect INTEGER,
+		tap_perfect          INTEGER,
+		tap_great            INTEGER,
+		tap_good             INTEGER,
+		tap_miss             INTEGER,
+
+		hold_critical_perfect INTEGER,
+		hold_perfect          INTEGER,
+		hold_great            INTEGER,
+		hold_good             INTEGER,
+		hold_miss             INTEGER,
+
+		slide_critical_perfect INTEGER,
+		slide_perfect          INTEGER,
+		slide_great            INTEGER,
+		slide_good             INTEGER,
+		slide_miss             INTEGER,
+
+		touch_critical_perfect INTEGER,
+		touch_perfect          INTEGER,
+		touch_great            INTEGER,
+		touch_good             INTEGER,
+		touch_miss             INTEGER,
+
+		break_critical_perfect INTEGER,
+		break_perfect          INTEGER,
+		break_great            INTEGER,
+		break_good             INTEGER,
+		break_miss             INTEGER,
+
+		total_critical_perfect INTEGER,
+		total_perfect          INTEGER,
+		total_great            INTEGER,
+		total_good             INTEGER,
+		total_miss             INTEGER,
+
+		UNIQUE (player_id, user_play_date, song_id, difficulty)
+	);`)
 	if err != nil {
 		return err
 	}
 
-	err = tx.Commit()
+	_, err = tx.Exec(`
+	INSERT INTO plays_new (` + legacyColumns + `)
+	SELECT ` + legacyColumns + ` FROM plays ORDER BY user_play_date ASC;`)
 	if err != nil {
 		return err
 	}
 
-	playdb.ascStmt, err = playdb.db.Prepare(
-		`SELECT * FROM plays ORDER BY user_play_date ASC
-		LIMIT ? OFFSET ?`)
+	_, err = tx.Exec(`DROP TABLE plays;`)
 	if err != nil {
 		return err
 	}
 
-	playdb.descStmt, err = playdb.db.Prepare(
-		`SELECT * FROM plays ORDER BY user_play_date DESC
-		LIMIT ? OFFSET ?`)
+	_, err = tx.Exec(`ALTER TABLE plays_new RENAME TO plays;`)
 	if err != nil {
 		return err
 	}
 
-	return nil
+	_, err = tx.Exec(`CREATE INDEX plays_user_play_date ON plays (user_play_date);`)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(`
+	CREATE UNIQUE INDEX plays_source_id ON plays (source, source_id)
+		WHERE source_id IS NOT NULL;`)
+	return err
+}
+
+func addPlayVariant(tx *sql.Tx) error {
+	_, err := tx.Exec(`ALTER TABLE plays ADD COLUMN variant INTEGER NOT NULL DEFAULT 0;`)
+	return err
 }
 
+func addPlayValidationErrors(tx *sql.Tx) error {
+	_, err := tx.Exec(`ALTER TABLE plays ADD COLUMN validation_errors TEXT;`)
+	return err
+}
+
+// indexSourceIdsPerPlayer lets players share a play upstream,
+// e.g. after exporting the plays of one player and importing them
+// as another's
+func indexSourceIdsPerPlayer(tx *sql.Tx) error {
+	_, err := tx.Exec(`DROP INDEX plays_source_id;`)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(`
+	CREATE UNIQUE INDEX plays_source_id ON plays (player_id, source, source_id)
+		WHERE source_id IS NOT NULL;`)
+	return err
+}
+
+// playColumns lists the columns of plays in the order rowsToPlayInfos scans them
+const playColumns = `
+	play_id, user_play_date, song_id, difficulty, variant,
+	COALESCE(source, ''), COALESCE(source_id, ''),
+		score, dx_score, combo_status, sync_status,
+		is_clear, is_new_record, is_dx_new_record,
+		track, matching_users,
+
+		max_combo, total_combo, max_sync, total_sync,
+
+		fast_count, late_count, before_rating, after_rating,
+
+		tap_critical_perfect, tap_perfect, tap_great,
+		tap_good, tap_miss,
+
+		hold_critical_perfect, hold_perfect, hold_great,
+		hold_good, hold_miss,
+
+		slide_critical_perfect, slide_perfect, slide_great,
+		slide_good, slide_miss,
+
+		touch_critical_perfect, touch_perfect, touch_great,
+		touch_good, touch_miss,
+
+		break_critical_perfect, break_perfect, break_great,
+		break_good, break_miss,
+
+		total_critical_perfect, total_perfect, total_great,
+		total_good, total_miss,
+
+		validation_errors
+`
+
 func validatePlay(play PlayInfo) error {
 	// check note counts add up to total
 
@@ -168,31 +411,156 @@ func validatePlay(play PlayInfo) error {
 		   return errors.New(fmt.Sprintf("error validating PlayInfo %d: no. of Misses does not add up", play.UserPlayDate))
 	}
 
-	// TODO: more validation on combo status, score, etc.
+	return nil
+}
+
+// ValidatePlayAgainstChart checks that the judgements of play add up to
+// the note counts of chart. Note types without a count in the chart, and
+// plays without detailed judgements, are only checked against MaxNotes.
+// A mismatch is returned as an *InvalidPlayError.
+func ValidatePlayAgainstChart(play PlayInfo, chart ChartInfo) error {
+	total := play.TotalCriticalPerfect + play.TotalPerfect + play.TotalGreat +
+			play.TotalGood + play.TotalMiss
+	if chart.MaxNotes != 0 && total != 0 && total != chart.MaxNotes {
+		return &InvalidPlayError{UserPlayDate: play.UserPlayDate, Problems: []string{
+			fmt.Sprintf("%d judgements, but chart has %d notes", total, chart.MaxNotes)}}
+	}
+
+	if chart.MaxNotes != 0 && play.DxScore > chart.MaxNotes*3 {
+		return &InvalidPlayError{UserPlayDate: play.UserPlayDate, Problems: []string{
+			fmt.Sprintf("dx score %d, but chart has a max of %d", play.DxScore, chart.MaxNotes*3)}}
+	}
+
+	noteTypes := []struct {
+		name   string
+		judged int
+		notes  int
+	}{
+		{"Tap", play.TapCriticalPerfect + play.TapPerfect + play.TapGreat +
+			play.TapGood + play.TapMiss, chart.TapNotes},
+		{"Hold", play.HoldCriticalPerfect + play.HoldPerfect + play.HoldGreat +
+			play.HoldGood + play.HoldMiss, chart.HoldNotes},
+		{"Slide", play.SlideCriticalPerfect + play.SlidePerfect + play.SlideGreat +
+			play.SlideGood + play.SlideMiss, chart.SlideNotes},
+		{"Touch", play.TouchCriticalPerfect + play.TouchPerfect + play.TouchGreat +
+			play.TouchGood + play.TouchMiss, chart.TouchNotes},
+		{"Break", play.BreakCriticalPerfect + play.BreakPerfect + play.BreakGreat +
+			play.BreakGood + play.BreakMiss, chart.BreakNotes},
+	}
+
+	detailed := false
+	for _, t := range noteTypes {
+		if t.judged != 0 {
+			detailed = true
+		}
+	}
+	if !detailed {
+		return nil
+	}
+
+	for _, t := range noteTypes {
+		if t.notes != 0 && t.judged != t.notes {
+			return &InvalidPlayError{UserPlayDate: play.UserPlayDate, Problems: []string{
+				fmt.Sprintf("%d %s judgements, but chart has %d %s notes", t.judged, t.name, t.notes, t.name)}}
+		}
+	}
 
 	return nil
 }
 
+// AddPlayResult describes what AddPlayWithResult did with a play
+type AddPlayResult int
+const (
+	PlayNotAdded  AddPlayResult = iota // the play wasn't added because of an error
+	PlayAdded                          // the play was inserted
+	PlayDuplicate                      // the same play is already in the database
+	PlayConflict                       // a different play with the same identity is in the database, so the play was dropped
+)
+
+func (r AddPlayResult) String() string {
+	switch r {
+	case PlayNotAdded:
+		return "not added"
+	case PlayAdded:
+		return "added"
+	case PlayDuplicate:
+		return "duplicate"
+	case PlayConflict:
+		return "conflict"
+	default:
+		return fmt.Sprintf("AddPlayResult(%d)", int(r))
+	}
+}
+
+// AddPlay adds a play to the database, ignoring it if it already exists.
+// Use AddPlayWithResult to find out whether the play was added.
 func (playdb *PlayDB) AddPlay(play PlayInfo) error {
+	_, err := playdb.AddPlayWithResult(play)
+	return err
+}
+
+// AddPlayWithResult adds a play to the database unless a play with the same
+// (date, song, difficulty) or the same (Source, SourceId) already exists.
+// If the existing play has the same score, it is a duplicate and has its
+// source id filled in if it didn't have one. Otherwise it is a conflict
+// and the existing play is left alone.
+// Plays whose score, combo or dx score don't agree with their judgements
+// are handled according to the ValidationMode of playdb.
+// The result is PlayNotAdded whenever err isn't nil.
+func (playdb *PlayDB) AddPlayWithResult(play PlayInfo) (AddPlayResult, error) {
 	err := validatePlay(play)
 	if err != nil {
-		return err
+		return PlayNotAdded, err
+	}
+
+	play.ValidationErrors = nil
+	if problems := checkPlay(play); len(problems) > 0 {
+		switch playdb.validationMode {
+		case WarnInvalid:
+			log.Printf("warning: %s\n", &InvalidPlayError{UserPlayDate: play.UserPlayDate, Problems: problems})
+		case FlagInvalid:
+			play.ValidationErrors = problems
+		default:
+			return PlayNotAdded, &InvalidPlayError{UserPlayDate: play.UserPlayDate, Problems: problems}
+		}
 	}
 
 	matchingUsersJSON, err := json.Marshal(play.MatchingUsers)
 	if err != nil {
-		return err
+		return PlayNotAdded, err
+	}
+
+	var validationErrorsJSON sql.NullString
+	if len(play.ValidationErrors) > 0 {
+		j, err := json.Marshal(play.ValidationErrors)
+		if err != nil {
+			return PlayNotAdded, err
+		}
+		validationErrorsJSON = sql.NullString{String: string(j), Valid: true}
 	}
 
 	tx, err := playdb.db.Begin()
 	if err != nil {
-		return err
+		return PlayNotAdded, err
 	}
 	defer tx.Rollback()
 
+	result, err := findDuplicatePlay(tx, playdb.playerId, play)
+	if err != nil {
+		return result, err
+	}
+	if result != PlayAdded {
+		err = tx.Commit()
+		if err != nil {
+			return PlayNotAdded, err
+		}
+		return result, nil
+	}
+
 	_, err = tx.Exec(`
-	INSERT OR IGNORE INTO plays (
-		user_play_date, song_id, difficulty,
+	INSERT INTO plays (
+		player_id, user_play_date, song_id, difficulty, variant,
+		source, source_id,
 
 		score, dx_score, combo_status, sync_status,
 		is_clear, is_new_record, is_dx_new_record,
@@ -218,9 +586,12 @@ func (playdb *PlayDB) AddPlay(play PlayInfo) error {
 		break_good, break_miss,
 
 		total_critical_perfect, total_perfect, total_great,
-		total_good, total_miss
+		total_good, total_miss,
+
+		validation_errors
 	) VALUES (
-		?, ?, ?,
+		?, ?, ?, ?, ?,
+		?, ?,
 
 		?, ?, ?, ?,
 		?, ?, ?,
@@ -246,13 +617,16 @@ func (playdb *PlayDB) AddPlay(play PlayInfo) error {
 		?, ?,
 
 		?, ?, ?,
-		?, ?
+		?, ?,
+
+		?
 	);`,
-		play.UserPlayDate, play.SongId, play.Difficulty,
+		playdb.playerId, play.UserPlayDate, play.SongId, play.Difficulty, play.Variant,
+		nullString(play.Source), nullString(play.SourceId),
 
 		play.Score, play.DxScore, play.ComboStatus, play.SyncStatus,
 		play.IsClear, play.IsNewRecord, play.IsDxNewRecord,
-		play.Track, matchingUsersJSON,
+		play.Track, string(matchingUsersJSON),
 
 		play.MaxCombo, play.TotalCombo, play.MaxSync, play.TotalSync,
 
@@ -274,19 +648,71 @@ func (playdb *PlayDB) AddPlay(play PlayInfo) error {
 		play.BreakGood, play.BreakMiss,
 
 		play.TotalCriticalPerfect, play.TotalPerfect, play.TotalGreat,
-		play.TotalGood, play.TotalMiss)
+		play.TotalGood, play.TotalMiss,
+
+		validationErrorsJSON)
 
 	if err != nil {
-		return err
+		return PlayNotAdded, err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return PlayNotAdded, err
+	}
+	return PlayAdded, nil
+}
+
+// findDuplicatePlay looks for a play that has the same identity as play.
+// It returns PlayAdded if there is none, and PlayNotAdded with any error.
+func findDuplicatePlay(tx *poolTx, playerId int64, play PlayInfo) (AddPlayResult, error) {
+	var playId int64
+	var score, dxScore int
+	var sourceId sql.NullString
+
+	err := tx.QueryRow(`
+		SELECT play_id, score, dx_score, source_id FROM plays
+		WHERE player_id=? AND user_play_date=? AND song_id=? AND difficulty=?`,
+		playerId, play.UserPlayDate, play.SongId, play.Difficulty).Scan(&playId, &score, &dxScore, &sourceId)
+	if err == nil {
+		if score != play.Score || dxScore != play.DxScore {
+			return PlayConflict, nil
+		}
+
+		if !sourceId.Valid && play.SourceId != "" {
+			_, err = tx.Exec(`UPDATE plays SET source=?, source_id=? WHERE play_id=?`,
+				nullString(play.Source), play.SourceId, playId)
+			if err != nil {
+				return PlayNotAdded, err
+			}
+		}
+
+		return PlayDuplicate, nil
+	} else if err != sql.ErrNoRows {
+		return PlayNotAdded, err
+	}
+
+	if play.SourceId == "" {
+		return PlayAdded, nil
+	}
+
+	// same play upstream, but stored under a different date, song or difficulty
+	err = tx.QueryRow(`SELECT play_id FROM plays WHERE player_id=? AND source=? AND source_id=?`,
+		playerId, nullString(play.Source), play.SourceId).Scan(&playId)
+	if err == nil {
+		return PlayConflict, nil
+	} else if err != sql.ErrNoRows {
+		return PlayNotAdded, err
 	}
 
-	return tx.Commit()
+	return PlayAdded, nil
 }
 
 // GetPlay returns a PlayInfo that corresponds to date
 func (playdb *PlayDB) GetPlay(date int64) (PlayInfo, error) {
-	rows, err := playdb.db.Query(`
-	SELECT * FROM plays WHERE user_play_date=?`, date)
+	rows, err := playdb.rdb.Query(`
+	SELECT `+playColumns+` FROM plays WHERE player_id=? AND user_play_date=?
+	ORDER BY play_id ASC`, playdb.playerId, date)
 	if err != nil {
 		return PlayInfo{}, err
 	}
@@ -304,6 +730,29 @@ func (playdb *PlayDB) GetPlay(date int64) (PlayInfo, error) {
 	return plays[0], nil
 }
 
+// GetPlayBySourceId returns the PlayInfo that was imported from source
+// with the id sourceId
+func (playdb *PlayDB) GetPlayBySourceId(source, sourceId string) (PlayInfo, error) {
+	rows, err := playdb.rdb.Query(`
+	SELECT `+playColumns+` FROM plays WHERE player_id=? AND source=? AND source_id=?`,
+		playdb.playerId, source, sourceId)
+	if err != nil {
+		return PlayInfo{}, err
+	}
+	defer rows.Close()
+
+	plays, err := rowsToPlayInfos(rows)
+	if err != nil {
+		return PlayInfo{}, err
+	}
+
+	if len(plays) < 1 {
+		return PlayInfo{}, &PlayNotFoundError{Source: source, SourceId: sourceId}
+	}
+
+	return plays[0], nil
+}
+
 // GetPlays returns a slice of PlayInfos.
 // ascending: whether dates are ascending or descending.
 // limit: the maximum length of the slice.
@@ -316,7 +765,7 @@ func (playdb *PlayDB) GetPlays(ascending bool, limit, offset int) ([]PlayInfo, e
 		stmt = playdb.descStmt
 	}
 
-	rows, err := stmt.Query(limit, offset)
+	rows, err := stmt.QueryContext(playdb.rdb.context(), playdb.playerId, limit, offset)
 	if err != nil {
 		return nil, err
 	}
@@ -332,17 +781,27 @@ func (playdb *PlayDB) GetPlays(ascending bool, limit, offset int) ([]PlayInfo, e
 
 type PlayNotFoundError struct {
 	UserPlayDate int64
+	Source       string
+	SourceId     string
 }
 
 func (e *PlayNotFoundError) Error() string {
+	if e.SourceId != "" {
+		return fmt.Sprintf("Playlog entry with %s id '%s' not found in database", e.Source, e.SourceId)
+	}
 	return fmt.Sprintf("Playlog entry with date %d not found in database", e.UserPlayDate)
 }
 
+// nullString stores empty strings as NULL
+func nullString(s string) sql.NullString {
+	return sql.NullString{String: s, Valid: s != ""}
+}
+
 // GetCount returns the number of plays in the database
 func (playdb *PlayDB) GetCount() (int, error) {
 	var count int
 
-	rows, err := playdb.db.Query(`SELECT COUNT(*) FROM plays`)
+	rows, err := playdb.rdb.Query(`SELECT COUNT(*) FROM plays WHERE player_id=?`, playdb.playerId)
 	if err != nil {
 		return count, err
 	}
@@ -362,11 +821,17 @@ func (playdb *PlayDB) GetCount() (int, error) {
 
 // GetBestScoreBeforeDate returns the best score for a given song and difficulty before the specified date
 func (playdb *PlayDB) GetBestScoreBeforeDate(songId int, difficulty Difficulty, date int64) (int, error) {
+	return playdb.GetBestScoreOfVariantBeforeDate(songId, difficulty, 0, date)
+}
+
+// GetBestScoreOfVariantBeforeDate is GetBestScoreBeforeDate for charts with more than one variant
+func (playdb *PlayDB) GetBestScoreOfVariantBeforeDate(songId int, difficulty Difficulty, variant int, date int64) (int, error) {
 	var score int
 
-	rows, err := playdb.db.Query(`
-		SELECT score FROM plays WHERE song_id=? AND difficulty=? AND user_play_date<? ORDER BY score DESC LIMIT 1`,
-		songId, difficulty, date)
+	rows, err := playdb.rdb.Query(`
+		SELECT score FROM plays WHERE player_id=? AND song_id=? AND difficulty=? AND variant=? AND user_play_date<?
+		ORDER BY score DESC LIMIT 1`,
+		playdb.playerId, songId, difficulty, variant, date)
 	if err != nil {
 		return score, err
 	}
@@ -386,14 +851,60 @@ func (playdb *PlayDB) GetBestScoreBeforeDate(songId int, difficulty Difficulty,
 	return score, nil
 }
 
+// GetPlaysOfVariantBeforeDate returns the plays of a chart before date, oldest first
+func (playdb *PlayDB) GetPlaysOfVariantBeforeDate(songId int, difficulty Difficulty, variant int, date int64) ([]PlayInfo, error) {
+	rows, err := playdb.rdb.Query(`
+	SELECT `+playColumns+` FROM plays WHERE player_id=? AND song_id=? AND difficulty=? AND variant=? AND user_play_date<?
+	ORDER BY user_play_date ASC, play_id ASC`,
+		playdb.playerId, songId, difficulty, variant, date)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	return rowsToPlayInfos(rows)
+}
+
+// GetPlayBefore returns the latest play before date
+func (playdb *PlayDB) GetPlayBefore(date int64) (PlayInfo, error) {
+	return playdb.getAdjacentPlay(`user_play_date<? ORDER BY user_play_date DESC, play_id DESC`, date)
+}
+
+// GetPlayAfter returns the earliest play after date
+func (playdb *PlayDB) GetPlayAfter(date int64) (PlayInfo, error) {
+	return playdb.getAdjacentPlay(`user_play_date>? ORDER BY user_play_date ASC, play_id ASC`, date)
+}
+
+func (playdb *PlayDB) getAdjacentPlay(where string, date int64) (PlayInfo, error) {
+	rows, err := playdb.rdb.Query(`
+	SELECT `+playColumns+` FROM plays WHERE player_id=? AND `+where+` LIMIT 1`,
+		playdb.playerId, date)
+	if err != nil {
+		return PlayInfo{}, err
+	}
+	defer rows.Close()
+
+	plays, err := rowsToPlayInfos(rows)
+	if err != nil {
+		return PlayInfo{}, err
+	}
+
+	if len(plays) < 1 {
+		return PlayInfo{}, &PlayNotFoundError{UserPlayDate: date}
+	}
+
+	return plays[0], nil
+}
+
 func rowsToPlayInfos(rows *sql.Rows) ([]PlayInfo, error) {
 	plays := make([]PlayInfo, 0, 50)
 
 	for rows.Next() {
-		var matchingUsersJSON []byte
+		var matchingUsersJSON, validationErrorsJSON []byte
 		play := PlayInfo{}
 		err := rows.Scan(
-			&play.UserPlayDate, &play.SongId, &play.Difficulty,
+			&play.PlayId, &play.UserPlayDate, &play.SongId, &play.Difficulty, &play.Variant,
+			&play.Source, &play.SourceId,
 
 			&play.Score, &play.DxScore, &play.ComboStatus, &play.SyncStatus,
 			&play.IsClear, &play.IsNewRecord, &play.IsDxNewRecord,
@@ -419,7 +930,9 @@ func rowsToPlayInfos(rows *sql.Rows) ([]PlayInfo, error) {
 			&play.BreakGreat, &play.BreakGood, &play.BreakMiss,
 
 			&play.TotalCriticalPerfect, &play.TotalPerfect,
-			&play.TotalGreat, &play.TotalGood, &play.TotalMiss)
+			&play.TotalGreat, &play.TotalGood, &play.TotalMiss,
+
+			&validationErrorsJSON)
 		if err != nil {
 			return nil, err
 		}
@@ -429,6 +942,13 @@ func rowsToPlayInfos(rows *sql.Rows) ([]PlayInfo, error) {
 			return nil, err
 		}
 
+		if validationErrorsJSON != nil {
+			err = json.Unmarshal(validationErrorsJSON, &play.ValidationErrors)
+			if err != nil {
+				return nil, err
+			}
+		}
+
 		plays = append(plays, play)
 	}
 
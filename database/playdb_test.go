@@ -17,8 +17,11 @@ package database_test
 
 import (
 	"testing"
+	"context"
+	"errors"
 	"os"
 	"reflect"
+	"path/filepath"
 	"database/sql"
 	_ "github.com/mattn/go-sqlite3"
 
@@ -48,6 +51,9 @@ func TestAddAndGetPlay(t *testing.T) {
 		SongId		: 11441,
 		Difficulty	: database.Master,
 
+		Source		: "solips",
+		SourceId	: "b8e8d5a1-playlog",
+
 		Score		: 971017,
 		DxScore		: 1841,
 		ComboStatus	: database.NoCombo,
@@ -109,6 +115,7 @@ func TestAddAndGetPlay(t *testing.T) {
 	if err != nil {
 		t.Fatal(err)
 	}
+	play1.PlayId = 1 // assigned by the database
 
 	play1g, err := playdb.GetPlay(1743108003)
 	if err != nil {
@@ -144,10 +151,53 @@ func TestAddAndGetPlay(t *testing.T) {
 		t.Log(plays2[0])
 		t.Error("play1 not equal to plays2[0]")
 	}
+
+	stats, err := playdb.GetNoteTypeStats()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(stats) != 5 || stats[0].NoteType != "Tap" || stats[0].Total() != 539 ||
+	   stats[4].NoteType != "Break" || stats[4].CriticalPerfect != 15 || stats[4].Total() != 46 {
+		t.Error("unexpected note type stats:", stats)
+	}
+	if a := stats[3].Accuracy(); a != 0.95 {
+		t.Error("expected touch accuracy of 0.95, got", a)
+	}
+
+	chart := database.ChartInfo{
+		Difficulty	: database.Master,
+		MaxNotes	: 783,
+		TapNotes	: 539,
+		HoldNotes	: 79,
+		SlideNotes	: 99,
+		TouchNotes	: 20,
+		BreakNotes	: 46,
+	}
+	err = database.ValidatePlayAgainstChart(play1, chart)
+	if err != nil {
+		t.Error("valid play failed validation against chart:", err)
+	}
+
+	// a hold counted as a tap still adds up to MaxNotes
+	chart.TapNotes, chart.HoldNotes = 540, 78
+	err = database.ValidatePlayAgainstChart(play1, chart)
+	if _, ok := err.(*database.InvalidPlayError); !ok {
+		t.Error("expected InvalidPlayError for play not matching the chart's note counts, got", err)
+	}
+
+	// charts without note counts are only checked against MaxNotes
+	err = database.ValidatePlayAgainstChart(play1, database.ChartInfo{MaxNotes: 783})
+	if err != nil {
+		t.Error("play failed validation against chart without note counts:", err)
+	}
+	err = database.ValidatePlayAgainstChart(play1, database.ChartInfo{MaxNotes: 600})
+	if err == nil {
+		t.Error("expected error for play not matching MaxNotes")
+	}
 }
 
 func TestGetPlays(t *testing.T) {
-	db, err := sql.Open("sqlite3", "../test/test-plays.db")
+	db, err := sql.Open("sqlite3", copyDB(t, "../test/test-plays.db"))
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -205,7 +255,7 @@ func TestGetPlays(t *testing.T) {
 }
 
 func TestGetCount(t *testing.T) {
-	db, err := sql.Open("sqlite3", "../test/test-plays.db")
+	db, err := sql.Open("sqlite3", copyDB(t, "../test/test-plays.db"))
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -226,8 +276,56 @@ func TestGetCount(t *testing.T) {
 	}
 }
 
+func TestWithContext(t *testing.T) {
+	db, err := sql.Open("sqlite3", copyDB(t, "../test/test-plays.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	playdb, err := database.NewPlayDB(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer playdb.Close()
+
+	plays, err := playdb.GetPlays(true, 1, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	cancelled := playdb.WithContext(ctx)
+	_, err = cancelled.GetPlays(true, 50, 0)
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("GetPlays: expected %v, got %v", context.Canceled, err)
+	}
+
+	play := plays[0]
+	play.UserPlayDate++
+	play.SourceId = ""
+	result, err := cancelled.AddPlayWithResult(play)
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("AddPlayWithResult: expected %v, got %v", context.Canceled, err)
+	}
+	if result != database.PlayNotAdded {
+		t.Errorf("AddPlayWithResult: expected %s, got %s", database.PlayNotAdded, result)
+	}
+
+	// playdb itself isn't affected
+	count, err := playdb.GetCount()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != 200 {
+		t.Errorf("expected 200 plays, got %d", count)
+	}
+}
+
 func TestGetBestScoreBeforeDate(t *testing.T) {
-	db, err := sql.Open("sqlite3", "../test/test-plays.db")
+	db, err := sql.Open("sqlite3", copyDB(t, "../test/test-plays.db"))
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -311,6 +409,80 @@ func TestGetBestScoreBeforeDate(t *testing.T) {
 	}
 }
 
+func TestGetPlaysOfVariantBeforeDate(t *testing.T) {
+	db, err := sql.Open("sqlite3", copyDB(t, "../test/test-plays.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	playdb, err := database.NewPlayDB(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Override
+	plays, err := playdb.GetPlaysOfVariantBeforeDate(11794, database.Master, 0, 1745701086)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(plays) != 3 {
+		t.Fatalf("expected 3 plays, got %d", len(plays))
+	}
+	if plays[0].UserPlayDate != 1743569808 ||
+	   plays[1].UserPlayDate != 1744401821 ||
+	   plays[2].UserPlayDate != 1744922642 {
+		t.Error("plays incorrect")
+	}
+
+	plays, err = playdb.GetPlaysOfVariantBeforeDate(11794, database.Master, 0, 1743569808)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(plays) != 0 {
+		t.Errorf("expected no plays before the first, got %d", len(plays))
+	}
+}
+
+func TestGetPlayBeforeAndAfter(t *testing.T) {
+	db, err := sql.Open("sqlite3", copyDB(t, "../test/test-plays.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	playdb, err := database.NewPlayDB(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	prev, err := playdb.GetPlayBefore(1743108219)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if prev.UserPlayDate != 1743108003 {
+		t.Errorf("expected play 1743108003 before, got %d", prev.UserPlayDate)
+	}
+
+	next, err := playdb.GetPlayAfter(1743108003)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if next.UserPlayDate != 1743108219 {
+		t.Errorf("expected play 1743108219 after, got %d", next.UserPlayDate)
+	}
+
+	_, err = playdb.GetPlayBefore(1743108003)
+	if _, ok := err.(*database.PlayNotFoundError); !ok {
+		t.Errorf("expected PlayNotFoundError before the first play, got %v", err)
+	}
+
+	_, err = playdb.GetPlayAfter(1746510678)
+	if _, ok := err.(*database.PlayNotFoundError); !ok {
+		t.Errorf("expected PlayNotFoundError after the last play, got %v", err)
+	}
+}
+
 func TestAddInvalidPlays(t *testing.T) {
 	tmpFile, err := os.CreateTemp("", "playdb-")
 	if err != nil {
@@ -527,3 +699,98 @@ func TestAddPlayWithNoDetailedJudgement(t *testing.T) {
 		t.Error("non-nil error for play1")
 	}
 }
+
+func TestAddDuplicatePlays(t *testing.T) {
+	db, err := sql.Open("sqlite3", filepath.Join(t.TempDir(), "plays.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	playdb, err := database.NewPlayDB(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	play1 := database.PlayInfo{
+		UserPlayDate	: 1743108003,
+		SongId		: 11441,
+		Difficulty	: database.Master,
+		Score		: 971017,
+		DxScore		: 1841,
+	}
+
+	result, err := playdb.AddPlayWithResult(play1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result != database.PlayAdded {
+		t.Error("play1: expected added, got", result)
+	}
+
+	// same play, now with an upstream id
+	play1s := play1
+	play1s.Source = "solips"
+	play1s.SourceId = "1"
+	result, err = playdb.AddPlayWithResult(play1s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result != database.PlayDuplicate {
+		t.Error("play1s: expected duplicate, got", result)
+	}
+
+	play1g, err := playdb.GetPlayBySourceId("solips", "1")
+	if err != nil {
+		t.Fatal("source id not filled in:", err)
+	}
+	if play1g.UserPlayDate != play1.UserPlayDate {
+		t.Error("GetPlayBySourceId returned the wrong play")
+	}
+
+	// same identity, different score
+	play2 := play1
+	play2.Score = 1000000
+	result, err = playdb.AddPlayWithResult(play2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result != database.PlayConflict {
+		t.Error("play2: expected conflict, got", result)
+	}
+
+	// same second, different song
+	play3 := play1
+	play3.SongId = 11442
+	result, err = playdb.AddPlayWithResult(play3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result != database.PlayAdded {
+		t.Error("play3: expected added, got", result)
+	}
+
+	// same upstream id, different date
+	play4 := play1s
+	play4.UserPlayDate += 1
+	result, err = playdb.AddPlayWithResult(play4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result != database.PlayConflict {
+		t.Error("play4: expected conflict, got", result)
+	}
+
+	count, err := playdb.GetCount()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != 2 {
+		t.Error("count: expected 2, got", count)
+	}
+
+	_, err = playdb.GetPlayBySourceId("kamai", "1")
+	if _, ok := err.(*database.PlayNotFoundError); !ok {
+		t.Error("expected PlayNotFoundError, got:", err)
+	}
+}
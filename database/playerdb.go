@@ -0,0 +1,169 @@
+// Copyright (C) 2025 Ethan Cheng <ethan@nijika.org>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+// Package database handles the playlog and song database
+package database
+
+import (
+	"database/sql"
+	"fmt"
+)
+
+// DefaultPlayerId is the player that plays from before multi-player
+// support belong to, and the one NewPlayDB reads and writes
+const DefaultPlayerId int64 = 1
+
+func createPlayersTable(tx *sql.Tx) error {
+	_, err := tx.Exec(`
+	CREATE TABLE IF NOT EXISTS players (
+		player_id   INTEGER PRIMARY KEY AUTOINCREMENT,
+		name        TEXT UNIQUE NOT NULL,
+		data_source TEXT,
+		access_code TEXT,
+		kamai_user  TEXT
+	);`)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(`
+	INSERT OR IGNORE INTO players (player_id, name) VALUES (?, 'default');`,
+		DefaultPlayerId)
+	return err
+}
+
+// AddPlayer adds a player and returns the new player's id
+func (playdb *PlayDB) AddPlayer(player PlayerInfo) (int64, error) {
+	var playerId int64
+	err := playdb.db.QueryRow(`
+	INSERT INTO players (
+		name, data_source, access_code, kamai_user) VALUES (
+		?, ?, ?, ?
+	) RETURNING player_id;`,
+		player.Name, nullString(player.DataSource),
+		nullString(player.AccessCode), nullString(player.KamaiUser)).Scan(&playerId)
+	return playerId, err
+}
+
+// UpdatePlayer replaces the name, data source and credentials of
+// the player with id player.PlayerId
+func (playdb *PlayDB) UpdatePlayer(player PlayerInfo) error {
+	result, err := playdb.db.Exec(`
+	UPDATE players SET name=?, data_source=?, access_code=?, kamai_user=?
+	WHERE player_id=?`,
+		player.Name, nullString(player.DataSource),
+		nullString(player.AccessCode), nullString(player.KamaiUser),
+		player.PlayerId)
+	if err != nil {
+		return err
+	}
+
+	n, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return &PlayerNotFoundError{PlayerId: player.PlayerId}
+	}
+
+	return nil
+}
+
+// GetPlayers returns all players ordered by id
+func (playdb *PlayDB) GetPlayers() ([]PlayerInfo, error) {
+	rows, err := playdb.rdb.Query(`
+	SELECT `+playerColumns+` FROM players ORDER BY player_id ASC`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	return rowsToPlayerInfos(rows)
+}
+
+// GetPlayer returns the player with id playerId
+func (playdb *PlayDB) GetPlayer(playerId int64) (PlayerInfo, error) {
+	rows, err := playdb.rdb.Query(`
+	SELECT `+playerColumns+` FROM players WHERE player_id=?`, playerId)
+	if err != nil {
+		return PlayerInfo{}, err
+	}
+	defer rows.Close()
+
+	players, err := rowsToPlayerInfos(rows)
+	if err != nil {
+		return PlayerInfo{}, err
+	}
+
+	if len(players) < 1 {
+		return PlayerInfo{}, &PlayerNotFoundError{PlayerId: playerId}
+	}
+
+	return players[0], nil
+}
+
+// GetPlayerByName returns the player called name
+func (playdb *PlayDB) GetPlayerByName(name string) (PlayerInfo, error) {
+	rows, err := playdb.rdb.Query(`
+	SELECT `+playerColumns+` FROM players WHERE name=?`, name)
+	if err != nil {
+		return PlayerInfo{}, err
+	}
+	defer rows.Close()
+
+	players, err := rowsToPlayerInfos(rows)
+	if err != nil {
+		return PlayerInfo{}, err
+	}
+
+	if len(players) < 1 {
+		return PlayerInfo{}, &PlayerNotFoundError{Name: name}
+	}
+
+	return players[0], nil
+}
+
+type PlayerNotFoundError struct {
+	PlayerId int64
+	Name     string
+}
+
+func (e *PlayerNotFoundError) Error() string {
+	if e.Name != "" {
+		return fmt.Sprintf("Player '%s' not found in database", e.Name)
+	}
+	return fmt.Sprintf("Player with id %d not found in database", e.PlayerId)
+}
+
+const playerColumns = `
+	player_id, name, COALESCE(data_source, ''),
+	COALESCE(access_code, ''), COALESCE(kamai_user, '')`
+
+func rowsToPlayerInfos(rows *sql.Rows) ([]PlayerInfo, error) {
+	players := make([]PlayerInfo, 0, 1)
+
+	for rows.Next() {
+		player := PlayerInfo{}
+		err := rows.Scan(&player.PlayerId, &player.Name, &player.DataSource,
+				&player.AccessCode, &player.KamaiUser)
+		if err != nil {
+			return nil, err
+		}
+
+		players = append(players, player)
+	}
+
+	return players, rows.Err()
+}
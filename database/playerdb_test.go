@@ -0,0 +1,124 @@
+// Copyright (C) 2025 Ethan Cheng <ethan@nijika.org>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package database_test
+
+import (
+	"testing"
+	"path/filepath"
+	"database/sql"
+	_ "github.com/mattn/go-sqlite3"
+
+	"github.com/yadayadajaychan/playlog/database"
+)
+
+func TestPlayers(t *testing.T) {
+	db, err := sql.Open("sqlite3", filepath.Join(t.TempDir(), "plays.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	playdb, err := database.NewPlayDB(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	players, err := playdb.GetPlayers()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(players) != 1 || players[0].PlayerId != database.DefaultPlayerId {
+		t.Fatal("expected only the default player, got", players)
+	}
+
+	alice := database.PlayerInfo{
+		Name:       "alice",
+		DataSource: "kamai",
+		KamaiUser:  "alice",
+	}
+	alice.PlayerId, err = playdb.AddPlayer(alice)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	aliceg, err := playdb.GetPlayerByName("alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if aliceg != alice {
+		t.Error("alice not equal to aliceg:", aliceg)
+	}
+
+	alice.DataSource = "solips"
+	alice.AccessCode = "12345"
+	err = playdb.UpdatePlayer(alice)
+	if err != nil {
+		t.Fatal(err)
+	}
+	aliceg, err = playdb.GetPlayer(alice.PlayerId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if aliceg != alice {
+		t.Error("alice not updated:", aliceg)
+	}
+
+	_, err = playdb.GetPlayerByName("bob")
+	if _, ok := err.(*database.PlayerNotFoundError); !ok {
+		t.Error("expected PlayerNotFoundError, got:", err)
+	}
+
+	// both players play the same chart at the same time
+	play := database.PlayInfo{
+		UserPlayDate	: 1743108003,
+		SongId		: 11441,
+		Difficulty	: database.Master,
+		Score		: 971017,
+		DxScore		: 1841,
+	}
+
+	alicedb := playdb.ForPlayer(alice.PlayerId)
+	for _, p := range []*database.PlayDB{playdb, alicedb} {
+		result, err := p.AddPlayWithResult(play)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if result != database.PlayAdded {
+			t.Errorf("player %d: expected added, got %s", p.PlayerId(), result)
+		}
+
+		count, err := p.GetCount()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if count != 1 {
+			t.Errorf("player %d: expected 1 play, got %d", p.PlayerId(), count)
+		}
+	}
+
+	bobdb := playdb.ForPlayer(alice.PlayerId + 1)
+	_, err = bobdb.GetPlay(play.UserPlayDate)
+	if _, ok := err.(*database.PlayNotFoundError); !ok {
+		t.Error("expected PlayNotFoundError for another player's play, got:", err)
+	}
+	plays, err := bobdb.GetPlays(false, 10, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(plays) != 0 {
+		t.Error("expected no plays for bob, got", len(plays))
+	}
+}
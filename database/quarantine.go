@@ -0,0 +1,144 @@
+// Copyright (C) 2025 Ethan Cheng <ethan@nijika.org>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+// Package database handles the playlog and song database
+package database
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+// QuarantinedPlay is a play that couldn't be added to the play db yet,
+// usually because its song isn't in the song db. Payload is the play
+// as it was received from Source, so it can be added once the song db
+// is updated.
+type QuarantinedPlay struct {
+	QuarantineId	int64 // assigned by the database
+	UserPlayDate	int64 // Unix timestamp
+	SongId		int
+	Source		string
+	SourceId	string
+	Reason		string // why the play was quarantined
+	Payload		[]byte
+	QuarantinedAt	int64 // Unix timestamp, set by QuarantinePlay
+}
+
+func createQuarantineTable(tx *sql.Tx) error {
+	_, err := tx.Exec(`
+	CREATE TABLE IF NOT EXISTS quarantine (
+		quarantine_id  INTEGER PRIMARY KEY AUTOINCREMENT,
+		player_id      INTEGER NOT NULL DEFAULT 1,
+		user_play_date INTEGER NOT NULL,
+		song_id        INTEGER NOT NULL,
+		source         TEXT NOT NULL,
+		source_id      TEXT NOT NULL,
+		reason         TEXT NOT NULL,
+		payload        BLOB NOT NULL,
+		quarantined_at INTEGER NOT NULL,
+		UNIQUE (player_id, source, source_id)
+	);`)
+	return err
+}
+
+// QuarantinePlay stores play in quarantine. If a play with the same
+// (Source, SourceId) is already quarantined, only its reason is updated.
+func (playdb *PlayDB) QuarantinePlay(play QuarantinedPlay) error {
+	_, err := playdb.db.Exec(`
+	INSERT INTO quarantine (
+		player_id, user_play_date, song_id,
+		source, source_id, reason,
+		payload, quarantined_at) VALUES (
+		?, ?, ?,
+		?, ?, ?,
+		?, ?
+	) ON CONFLICT (player_id, source, source_id) DO UPDATE SET
+		reason=excluded.reason;`,
+		playdb.playerId, play.UserPlayDate, play.SongId,
+		play.Source, play.SourceId, play.Reason,
+		play.Payload, time.Now().Unix())
+	return err
+}
+
+const quarantineColumns = `
+	quarantine_id, user_play_date, song_id,
+	source, source_id, reason,
+	payload, quarantined_at`
+
+// GetQuarantinedPlays returns the quarantined plays from source, oldest first
+func (playdb *PlayDB) GetQuarantinedPlays(source string) ([]QuarantinedPlay, error) {
+	rows, err := playdb.rdb.Query(`
+		SELECT `+quarantineColumns+` FROM quarantine
+		WHERE player_id=? AND source=? ORDER BY user_play_date ASC`,
+		playdb.playerId, source)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	plays := make([]QuarantinedPlay, 0)
+	for rows.Next() {
+		play := QuarantinedPlay{}
+		err = rows.Scan(&play.QuarantineId, &play.UserPlayDate, &play.SongId,
+				&play.Source, &play.SourceId, &play.Reason,
+				&play.Payload, &play.QuarantinedAt)
+		if err != nil {
+			return nil, err
+		}
+
+		plays = append(plays, play)
+	}
+
+	return plays, rows.Err()
+}
+
+// IsQuarantined reports whether the play with sourceId at source is in quarantine
+func (playdb *PlayDB) IsQuarantined(source, sourceId string) (bool, error) {
+	var count int
+	err := playdb.rdb.QueryRow(`
+		SELECT COUNT(*) FROM quarantine WHERE player_id=? AND source=? AND source_id=?`,
+		playdb.playerId, source, sourceId).Scan(&count)
+	return count > 0, err
+}
+
+// ReleaseQuarantinedPlay removes a play from quarantine,
+// e.g. once it has been added to the play db
+func (playdb *PlayDB) ReleaseQuarantinedPlay(quarantineId int64) error {
+	result, err := playdb.db.Exec(`
+		DELETE FROM quarantine WHERE player_id=? AND quarantine_id=?`,
+		playdb.playerId, quarantineId)
+	if err != nil {
+		return err
+	}
+
+	n, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return &QuarantinedPlayNotFoundError{QuarantineId: quarantineId}
+	}
+
+	return nil
+}
+
+type QuarantinedPlayNotFoundError struct {
+	QuarantineId int64
+}
+
+func (e *QuarantinedPlayNotFoundError) Error() string {
+	return fmt.Sprintf("Quarantined play with id %d not found in database", e.QuarantineId)
+}
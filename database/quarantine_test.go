@@ -0,0 +1,110 @@
+// Copyright (C) 2025 Ethan Cheng <ethan@nijika.org>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package database_test
+
+import (
+	"testing"
+	"path/filepath"
+	"database/sql"
+	_ "github.com/mattn/go-sqlite3"
+
+	"github.com/yadayadajaychan/playlog/database"
+)
+
+func TestQuarantine(t *testing.T) {
+	db, err := sql.Open("sqlite3", filepath.Join(t.TempDir(), "plays.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	playdb, err := database.NewPlayDB(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	play := database.QuarantinedPlay{
+		UserPlayDate : 1743108003,
+		SongId       : 11999,
+		Source       : "solips",
+		SourceId     : "b8e8d5a1-playlog",
+		Reason       : "song not found",
+		Payload      : []byte(`{"Info":{"MusicId":11999}}`),
+	}
+
+	err = playdb.QuarantinePlay(play)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// quarantining the same play again only updates the reason
+	play.Reason = "chart not found"
+	err = playdb.QuarantinePlay(play)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	plays, err := playdb.GetQuarantinedPlays("solips")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(plays) != 1 {
+		t.Fatal("expected 1 quarantined play, got", len(plays))
+	}
+	if plays[0].Reason != "chart not found" || string(plays[0].Payload) != string(play.Payload) ||
+	   plays[0].SongId != play.SongId || plays[0].QuarantinedAt == 0 {
+		t.Error("unexpected quarantined play:", plays[0])
+	}
+
+	quarantined, err := playdb.IsQuarantined("solips", "b8e8d5a1-playlog")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !quarantined {
+		t.Error("play not quarantined")
+	}
+
+	// quarantine is per player
+	other, err := playdb.AddPlayer(database.PlayerInfo{Name: "other"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	plays2, err := playdb.ForPlayer(other).GetQuarantinedPlays("solips")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(plays2) != 0 {
+		t.Error("other player sees quarantined plays of default player")
+	}
+
+	err = playdb.ReleaseQuarantinedPlay(plays[0].QuarantineId)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = playdb.ReleaseQuarantinedPlay(plays[0].QuarantineId)
+	if _, ok := err.(*database.QuarantinedPlayNotFoundError); !ok {
+		t.Error("expected QuarantinedPlayNotFoundError, got:", err)
+	}
+
+	quarantined, err = playdb.IsQuarantined("solips", "b8e8d5a1-playlog")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if quarantined {
+		t.Error("play still quarantined after release")
+	}
+}
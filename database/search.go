@@ -0,0 +1,344 @@
+// Copyright (C) 2025 Ethan Cheng <ethan@nijika.org>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+// Package database handles the playlog and song database
+package database
+
+import (
+	"database/sql"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+// Songs are searched by their name, artist and aliases after normalizing
+// them with NormalizeName. An FTS index keeps the normalized text,
+// split into overlapping pairs of characters since Japanese names have
+// no spaces between words.
+//
+// song_aliases keeps other names of songs, e.g. nicknames, which are
+// searched like their names.
+func createSongSearchTables(tx *sql.Tx) error {
+	_, err := tx.Exec(`
+	CREATE TABLE IF NOT EXISTS song_aliases (
+		song_id INTEGER NOT NULL,
+		alias   TEXT NOT NULL,
+		PRIMARY KEY (song_id, alias)
+	);`)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(`CREATE INDEX IF NOT EXISTS song_aliases_alias ON song_aliases (alias);`)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(`CREATE VIRTUAL TABLE IF NOT EXISTS songs_fts USING fts4(name, artist, aliases);`)
+	if err != nil {
+		return err
+	}
+
+	rows, err := tx.Query(`SELECT song_id FROM songs`)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	var songIds []int
+	for rows.Next() {
+		var songId int
+		err = rows.Scan(&songId)
+		if err != nil {
+			return err
+		}
+		songIds = append(songIds, songId)
+	}
+	if err = rows.Err(); err != nil {
+		return err
+	}
+	rows.Close()
+
+	for _, songId := range songIds {
+		err = indexSong(tx, songId)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// queryer is implemented by pools, transactions and *sql.Tx
+type queryer interface {
+	execer
+	Query(query string, args ...any) (*sql.Rows, error)
+	QueryRow(query string, args ...any) *sql.Row
+}
+
+// indexSong updates the search index
+// to the current name, artist and aliases of a song
+func indexSong(db queryer, songId int) error {
+	_, err := db.Exec(`DELETE FROM songs_fts WHERE docid=?`, songId)
+	if err != nil {
+		return err
+	}
+
+	var name, artist string
+	err = db.QueryRow(`SELECT name, artist FROM songs WHERE song_id=?`, songId).Scan(&name, &artist)
+	if err == sql.ErrNoRows {
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	aliases, err := getSongAliases(db, songId)
+	if err != nil {
+		return err
+	}
+
+	var aliasTokens []string
+	for _, alias := range aliases {
+		aliasTokens = append(aliasTokens, searchTokens(NormalizeName(alias))...)
+	}
+
+	_, err = db.Exec(`INSERT INTO songs_fts (docid, name, artist, aliases) VALUES (?, ?, ?, ?)`,
+		songId,
+		strings.Join(searchTokens(NormalizeName(name)), " "),
+		strings.Join(searchTokens(NormalizeName(artist)), " "),
+		strings.Join(aliasTokens, " "))
+	return err
+}
+
+// NormalizeName returns name without the differences that don't matter
+// when searching for a song: full-width and half-width forms are folded,
+// katakana becomes hiragana, letters are lower-cased, and punctuation,
+// symbols and spaces are removed. For example, "ＰＯＰ ｽﾀｰ!" and "pop すたー"
+// both become "popすたー".
+func NormalizeName(name string) string {
+	runes := make([]rune, 0, len(name))
+	for _, r := range name {
+		switch {
+		case r >= 0xFF01 && r <= 0xFF5E: // full-width ASCII
+			r -= 0xFEE0
+		case r >= 0xFF66 && r <= 0xFF9D: // half-width katakana
+			r = fullwidthKana[r-0xFF66]
+		case r == 0xFF9E:
+			r = 0x3099 // combining voiced sound mark
+		case r == 0xFF9F:
+			r = 0x309A // combining semi-voiced sound mark
+		}
+
+		// katakana to hiragana
+		if r >= 0x30A1 && r <= 0x30F6 || r == 0x30FD || r == 0x30FE {
+			r -= 0x60
+		}
+
+		// combine voiced sound marks with the kana before them
+		if n := len(runes); n > 0 {
+			if r == 0x3099 || r == 0x309B {
+				if v, ok := voicedKana[runes[n-1]]; ok {
+					runes[n-1] = v
+					continue
+				}
+			} else if r == 0x309A || r == 0x309C {
+				if v, ok := semiVoicedKana[runes[n-1]]; ok {
+					runes[n-1] = v
+					continue
+				}
+			}
+		}
+
+		r = unicode.ToLower(r)
+		if unicode.IsLetter(r) || unicode.IsNumber(r) {
+			runes = append(runes, r)
+		}
+	}
+
+	return string(runes)
+}
+
+// fullwidthKana are the katakana of U+FF66 to U+FF9D
+var fullwidthKana = []rune("ヲァィゥェォャュョッーアイウエオカキクケコサシスセソタチツテトナニヌネノハヒフヘホマミムメモヤユヨラリルレロワン")
+
+var voicedKana = kanaPairs("かきくけこさしすせそたちつてとはひふへほう", "がぎぐげござじずぜぞだぢづでどばびぶべぼゔ")
+var semiVoicedKana = kanaPairs("はひふへほ", "ぱぴぷぺぽ")
+
+func kanaPairs(from, to string) map[rune]rune {
+	f, t := []rune(from), []rune(to)
+	pairs := make(map[rune]rune, len(f))
+	for i := range f {
+		pairs[f[i]] = t[i]
+	}
+	return pairs
+}
+
+// searchTokens splits normalized text into its overlapping pairs of
+// characters, or returns text itself if it is one character long
+func searchTokens(normalized string) []string {
+	runes := []rune(normalized)
+	if len(runes) == 1 {
+		return []string{normalized}
+	}
+
+	tokens := make([]string, 0, len(runes))
+	for i := 0; i+1 < len(runes); i++ {
+		tokens = append(tokens, string(runes[i:i+2]))
+	}
+	return tokens
+}
+
+// How well a name, alias or artist matches a query, from best to worst
+const (
+	exactMatch     = 100
+	prefixMatch    = 80
+	substringMatch = 60
+	tokenMatch     = 40 // every pair of characters of the query is in the text
+)
+
+// matchScore rates how well normalized text matches a normalized query,
+// 0 if it doesn't match at all
+func matchScore(text, query string) int {
+	switch {
+	case text == "" || query == "":
+		return 0
+	case text == query:
+		return exactMatch
+	case strings.HasPrefix(text, query):
+		return prefixMatch
+	case strings.Contains(text, query):
+		return substringMatch
+	}
+
+	textTokens := searchTokens(text)
+	for _, token := range searchTokens(query) {
+		found := false
+		for _, t := range textTokens {
+			if t == token {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return 0
+		}
+	}
+	return tokenMatch
+}
+
+// SongMatch is a song found by SearchSongs
+type SongMatch struct {
+	SongInfo SongInfo
+	Score    int    // how well the song matches, higher is better
+	Field    string // of the song that matched best: "name", "alias" or "artist"
+	Match    string // the name, alias or artist that matched best
+}
+
+// matchSong rates how well song, with aliases, matches a normalized query.
+// Aliases rate slightly below names, and artists half as high.
+func matchSong(song SongInfo, aliases []string, query string) SongMatch {
+	m := SongMatch{SongInfo: song}
+
+	if s := matchScore(NormalizeName(song.Name), query); s > m.Score {
+		m.Score, m.Field, m.Match = s, "name", song.Name
+	}
+	for _, alias := range aliases {
+		if s := matchScore(NormalizeName(alias), query) - 5; s > m.Score {
+			m.Score, m.Field, m.Match = s, "alias", alias
+		}
+	}
+	if s := matchScore(NormalizeName(song.Artist), query) / 2; s > m.Score {
+		m.Score, m.Field, m.Match = s, "artist", song.Artist
+	}
+
+	return m
+}
+
+// SearchSongs returns at most limit songs whose name, artist or alias
+// matches query, best match first. A limit <= 0 returns every match.
+func (songdb *SongDB) SearchSongs(query string, limit int) ([]SongMatch, error) {
+	q := NormalizeName(query)
+	if q == "" {
+		return []SongMatch{}, nil
+	}
+
+	all, err := songdb.GetAllSongAliases()
+	if err != nil {
+		return nil, err
+	}
+	aliases := make(map[int][]string)
+	for _, a := range all {
+		aliases[a.SongId] = append(aliases[a.SongId], a.Alias)
+	}
+
+	// the index holds pairs of characters, so a single character
+	// at the end of a name can only be found by looking at every song
+	var songs []SongInfo
+	if len([]rune(q)) > 1 {
+		songs, err = songdb.searchIndex(q)
+	} else {
+		songs, err = songdb.GetSongs()
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	matches := make([]SongMatch, 0, len(songs))
+	for _, song := range songs {
+		m := matchSong(song, aliases[song.SongId], q)
+		if m.Score > 0 {
+			matches = append(matches, m)
+		}
+	}
+
+	sort.SliceStable(matches, func(i, j int) bool {
+		a, b := matches[i], matches[j]
+		if a.Score != b.Score {
+			return a.Score > b.Score
+		}
+		// the shorter of two names matching the same way is closer
+		if len(a.Match) != len(b.Match) {
+			return len(a.Match) < len(b.Match)
+		}
+		return a.SongInfo.SongId < b.SongInfo.SongId
+	})
+
+	if limit > 0 && len(matches) > limit {
+		matches = matches[:limit]
+	}
+	return matches, nil
+}
+
+// searchIndex returns the songs whose name, artist or aliases
+// contain every pair of characters of the normalized query,
+// which has at least two characters
+func (songdb *SongDB) searchIndex(query string) ([]SongInfo, error) {
+	match := strings.Join(searchTokens(query), " ")
+
+	rows, err := songdb.rdb.Query(`
+		SELECT `+songColumns+` FROM songs WHERE song_id IN (
+			SELECT docid FROM songs_fts WHERE songs_fts MATCH ?
+		) ORDER BY song_id ASC`, match)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	songs, err := songdb.rowsToSongInfos(rows)
+	if _, ok := err.(*SongNotFoundError); ok {
+		return songs, nil
+	}
+	return songs, err
+}
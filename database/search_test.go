@@ -0,0 +1,152 @@
+// Copyright (C) 2025 Ethan Cheng <ethan@nijika.org>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+// Package database handles the playlog and song database
+package database_test
+
+import (
+	"testing"
+	"database/sql"
+	_ "github.com/mattn/go-sqlite3"
+
+	"github.com/yadayadajaychan/playlog/database"
+)
+
+func TestNormalizeName(t *testing.T) {
+	tests := []struct {
+		name string
+		want string
+	}{
+		{"PON PON PON ", "ponponpon"},
+		{"ＰＯＮ　ＰＯＮ　ＰＯＮ", "ponponpon"},
+		{"ヒバナ", "ひばな"},
+		{"ﾋﾊﾞﾅ", "ひばな"},
+		{"ひばな", "ひばな"},
+		{"ﾊﾟﾗﾎﾟﾈ", "ぱらぽね"},
+		{"Ｂａｄ Ａｐｐｌｅ!! feat.nomico", "badapplefeatnomico"},
+		{"DECO*27", "deco27"},
+		{"ストリーミングハート", "すとりーみんぐはーと"},
+		{"　", ""},
+	}
+
+	for _, test := range tests {
+		got := database.NormalizeName(test.name)
+		if got != test.want {
+			t.Errorf("NormalizeName(%q): expected %q, got %q", test.name, test.want, got)
+		}
+	}
+}
+
+func TestSearchSongs(t *testing.T) {
+	db, err := sql.Open("sqlite3", copyDB(t, "../songs.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	songdb, err := database.NewSongDB(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer songdb.Close()
+
+	tests := []struct {
+		query string
+		first int // song id of the best match, 0 for any
+		field string
+	}{
+		{"ﾋﾊﾞﾅ", 792, "name"},
+		{"ひばな", 792, "name"},
+		{"pon pon pon", 59, "name"},
+		{"すとりーみんぐ", 419, "name"},
+		{"みきとp", 0, "artist"}, // more than one song
+	}
+
+	for _, test := range tests {
+		matches, err := songdb.SearchSongs(test.query, 10)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(matches) == 0 {
+			t.Errorf("%q: no matches", test.query)
+			continue
+		}
+		if test.first != 0 && matches[0].SongInfo.SongId != test.first || matches[0].Field != test.field {
+			t.Errorf("%q: expected song %d by %s first, got %d by %s",
+				test.query, test.first, test.field, matches[0].SongInfo.SongId, matches[0].Field)
+		}
+	}
+
+	matches, err := songdb.SearchSongs("ぽんぽん", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(matches) != 0 {
+		t.Errorf("expected no matches before adding an alias, got %d", len(matches))
+	}
+
+	err = songdb.AddSongAlias(59, "ぽんぽんぽん")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	matches, err = songdb.SearchSongs("ポンポン", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(matches) != 1 || matches[0].SongInfo.SongId != 59 || matches[0].Field != "alias" {
+		t.Errorf("expected song 59 by its alias, got %+v", matches)
+	}
+
+	err = songdb.RemoveSongAlias(59, "ぽんぽんぽん")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	matches, err = songdb.SearchSongs("ポンポン", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(matches) != 0 {
+		t.Errorf("expected no matches after removing the alias, got %d", len(matches))
+	}
+
+	err = songdb.AddSongAlias(999999, "nothing")
+	if _, ok := err.(*database.SongNotFoundError); !ok {
+		t.Errorf("expected SongNotFoundError, got %v", err)
+	}
+
+	// a single character only at the end of a name
+	err = songdb.AddSong(database.SongInfo{
+		SongId: 999998,
+		Name:   "ぷれいろぐゑ",
+		Type:   "dx",
+		Charts: []database.ChartInfo{{Difficulty: database.Master, Level: 13, InternalLevel: 130}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	matches, err = songdb.SearchSongs("ゑ", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	found := false
+	for _, m := range matches {
+		found = found || m.SongInfo.SongId == 999998
+	}
+	if !found {
+		t.Errorf("expected song 999998 among %+v", matches)
+	}
+}
@@ -0,0 +1,178 @@
+// Copyright (C) 2025 Ethan Cheng <ethan@nijika.org>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+// Package database handles the playlog and song database
+package database
+
+import (
+	"fmt"
+	"time"
+)
+
+// DefaultSessionGap is the longest time between two plays
+// of the same session, unless another gap is given
+const DefaultSessionGap = time.Hour
+
+// Session is a visit to the arcade: plays with no more than a gap between
+// one and the next. Its plays are split into credits by their track no.,
+// a credit starting whenever the track no. doesn't go up.
+// Plays without a track no., e.g. from kamaitachi, are in the credit
+// of the play before them.
+type Session struct {
+	SessionId    int64 // the UserPlayDate of its first play
+	Start        int64 // Unix timestamp of its first play
+	End          int64 // Unix timestamp of its last play
+	Plays        int
+	Credits      int
+	BeforeRating int // BeforeRating of its first play, 0 if unknown
+	AfterRating  int // AfterRating of its last play, 0 if unknown
+	NewRecords   int // no. of plays that are new records, see SessionPlay
+}
+
+// Duration returns the time from the first play to the last play of s
+func (s Session) Duration() time.Duration {
+	return time.Duration(s.End-s.Start) * time.Second
+}
+
+// RatingChange returns the difference in rating over s,
+// or 0 if the rating before or after it is unknown
+func (s Session) RatingChange() int {
+	if s.BeforeRating == 0 || s.AfterRating == 0 {
+		return 0
+	}
+	return s.AfterRating - s.BeforeRating
+}
+
+// SessionPlay is a play of a session. A play is a new record if it's
+// flagged as one or beats the score or DX score of every earlier play
+// of its chart, so, as in the game, the first play of a chart always is.
+type SessionPlay struct {
+	PlayInfo  PlayInfo
+	Credit    int  // of the session the play is in, starting at 1
+	NewRecord bool
+}
+
+// GetSessions returns the sessions of every play, latest first.
+// A gap <= 0 is DefaultSessionGap.
+func (playdb *PlayDB) GetSessions(gap time.Duration) ([]Session, error) {
+	sessions, _, err := playdb.sessions(gap)
+	if err != nil {
+		return nil, err
+	}
+
+	for i, j := 0, len(sessions)-1; i < j; i, j = i+1, j-1 {
+		sessions[i], sessions[j] = sessions[j], sessions[i]
+	}
+	return sessions, nil
+}
+
+// GetSession returns the session whose first play was at sessionId,
+// and its plays, oldest first. A gap <= 0 is DefaultSessionGap.
+func (playdb *PlayDB) GetSession(sessionId int64, gap time.Duration) (Session, []SessionPlay, error) {
+	sessions, plays, err := playdb.sessions(gap)
+	if err != nil {
+		return Session{}, nil, err
+	}
+
+	for i, s := range sessions {
+		if s.SessionId == sessionId {
+			return s, plays[i], nil
+		}
+	}
+	return Session{}, nil, &SessionNotFoundError{SessionId: sessionId}
+}
+
+// sessions splits every play into sessions, oldest first,
+// and returns them with the plays of each
+func (playdb *PlayDB) sessions(gap time.Duration) ([]Session, [][]SessionPlay, error) {
+	if gap <= 0 {
+		gap = DefaultSessionGap
+	}
+
+	count, err := playdb.GetCount()
+	if err != nil {
+		return nil, nil, err
+	}
+	all, err := playdb.GetPlays(true, count, 0)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	type chart struct {
+		songId     int
+		difficulty Difficulty
+		variant    int
+	}
+	type best struct {
+		score, dxScore int
+	}
+	bests := make(map[chart]best)
+
+	sessions := make([]Session, 0)
+	plays := make([][]SessionPlay, 0)
+	var prev PlayInfo
+	lastTrack := 0 // of the last play with a track no. in the session
+
+	for i, play := range all {
+		newSession := i == 0 || play.UserPlayDate-prev.UserPlayDate > int64(gap/time.Second)
+		if newSession {
+			sessions = append(sessions, Session{
+				SessionId:    play.UserPlayDate,
+				Start:        play.UserPlayDate,
+				BeforeRating: play.BeforeRating,
+			})
+			plays = append(plays, make([]SessionPlay, 0, 4))
+			lastTrack = 0
+		}
+		s := &sessions[len(sessions)-1]
+
+		if newSession || play.Track != 0 && play.Track <= lastTrack {
+			s.Credits++
+		}
+		if play.Track != 0 {
+			lastTrack = play.Track
+		}
+
+		c := chart{play.SongId, play.Difficulty, play.Variant}
+		b := bests[c]
+		newRecord := play.IsNewRecord || play.IsDxNewRecord ||
+			play.Score > b.score || play.DxScore > b.dxScore
+		bests[c] = best{max(b.score, play.Score), max(b.dxScore, play.DxScore)}
+
+		s.End = play.UserPlayDate
+		s.Plays++
+		s.AfterRating = play.AfterRating
+		if newRecord {
+			s.NewRecords++
+		}
+
+		plays[len(plays)-1] = append(plays[len(plays)-1], SessionPlay{
+			PlayInfo:  play,
+			Credit:    s.Credits,
+			NewRecord: newRecord,
+		})
+		prev = play
+	}
+
+	return sessions, plays, nil
+}
+
+type SessionNotFoundError struct {
+	SessionId int64
+}
+
+func (e *SessionNotFoundError) Error() string {
+	return fmt.Sprintf("Session %d not found in database", e.SessionId)
+}
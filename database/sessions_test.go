@@ -0,0 +1,129 @@
+// Copyright (C) 2025 Ethan Cheng <ethan@nijika.org>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+// Package database handles the playlog and song database
+package database_test
+
+import (
+	"testing"
+	"time"
+	"database/sql"
+	_ "github.com/mattn/go-sqlite3"
+
+	"github.com/yadayadajaychan/playlog/database"
+)
+
+func TestGetSessions(t *testing.T) {
+	db, err := sql.Open("sqlite3", copyDB(t, "../test/test-plays.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	playdb, err := database.NewPlayDB(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sessions, err := playdb.GetSessions(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	plays := 0
+	for i, s := range sessions {
+		plays += s.Plays
+		if i > 0 && sessions[i-1].Start-s.End <= int64(database.DefaultSessionGap/time.Second) {
+			t.Errorf("sessions %d and %d are less than the gap apart", s.SessionId, sessions[i-1].SessionId)
+		}
+	}
+	if plays != 200 {
+		t.Errorf("expected 200 plays in sessions, got %d", plays)
+	}
+
+	// the first session ends with 1.5 hours until the next play
+	first := sessions[len(sessions)-1]
+	expected := database.Session{
+		SessionId:    1743108003,
+		Start:        1743108003,
+		End:          1743116404,
+		Plays:        14,
+		Credits:      4, // the first starts at track 3
+		BeforeRating: 13085,
+		AfterRating:  13123,
+		NewRecords:   14,
+	}
+	if first != expected {
+		t.Errorf("expected %+v, got %+v", expected, first)
+	}
+	if first.RatingChange() != 38 {
+		t.Errorf("expected rating change 38, got %d", first.RatingChange())
+	}
+
+	// with a longer gap, the next session is part of the first
+	longer, err := playdb.GetSessions(2 * time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if last := longer[len(longer)-1]; last.Plays != 18 || last.Credits != 5 {
+		t.Errorf("expected 18 plays in 5 credits, got %d in %d", last.Plays, last.Credits)
+	}
+
+	session, sessionPlays, err := playdb.GetSession(1743108003, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if session != first || len(sessionPlays) != 14 {
+		t.Fatalf("unexpected session %+v with %d plays", session, len(sessionPlays))
+	}
+	if sessionPlays[1].Credit != 1 || sessionPlays[2].Credit != 2 || sessionPlays[13].Credit != 4 {
+		t.Error("plays are in the wrong credits")
+	}
+
+	_, _, err = playdb.GetSession(1743108219, 0)
+	if _, ok := err.(*database.SessionNotFoundError); !ok {
+		t.Errorf("expected SessionNotFoundError, got %v", err)
+	}
+}
+
+func TestGetSessionsWithoutTrack(t *testing.T) {
+	db, err := sql.Open("sqlite3", copyDB(t, "../test/test-plays.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	// the last play of the second credit has no track no., like a kamaitachi play
+	_, err = db.Exec(`UPDATE plays SET track=0 WHERE user_play_date=1743109978`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	playdb, err := database.NewPlayDB(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	session, sessionPlays, err := playdb.GetSession(1743108003, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if session.Credits != 4 {
+		t.Errorf("expected 4 credits, got %d", session.Credits)
+	}
+	if sessionPlays[5].Credit != 2 || sessionPlays[6].Credit != 3 {
+		t.Error("plays are in the wrong credits")
+	}
+}
@@ -23,24 +23,49 @@ import (
 )
 
 type SongDB struct {
-	db *sql.DB
+	conn *sql.DB // passed to NewSongDB
+	db   *pool   // pool to write to, see openPools
+	rdb  *pool   // pool to read from
 }
 
-// NewSongDB creates a SongDB object and initializes the database
+// NewSongDB creates a SongDB object and initializes the database.
+// After migrating, db is only used to find the database file, which is then
+// read and written through pools of its own. Close closes them.
 func NewSongDB(db *sql.DB) (*SongDB, error) {
-	songdb := &SongDB{db: db}
+	songdb := &SongDB{conn: db}
 	err := songdb.initDB()
 	return songdb, err
 }
 
+// Close closes the pools of songdb. db passed to NewSongDB is left open.
+func (songdb *SongDB) Close() error {
+	return closePools(songdb.db, songdb.rdb, songdb.conn)
+}
+
+var songMigrations = []migration{
+	{1, "create songs and charts tables", createSongsTables},
+	{2, "create song_history table", createSongHistoryTable},
+	{3, "create chart_levels table", createChartLevelsTable},
+	{4, "add note counts per note type to charts", addChartNoteCounts},
+	{5, "identify charts by (song, difficulty, variant)", addChartVariants},
+	{6, "create song_aliases table and song search index", createSongSearchTables},
+	{7, "add aliases for song titles of kamaitachi", addSourceTitleAliases},
+	{8, "remove empty song aliases", removeEmptyAliases},
+	{9, "record the current internal levels of charts with past levels", recordCurrentLevels},
+}
+
 func (songdb *SongDB) initDB() error {
-	tx, err := songdb.db.Begin()
+	err := migrate(songdb.conn, "song", songMigrations)
 	if err != nil {
 		return err
 	}
-	defer tx.Rollback()
 
-	_, err = tx.Exec(`
+	songdb.db, songdb.rdb, err = openPools(songdb.conn)
+	return err
+}
+
+func createSongsTables(tx *sql.Tx) error {
+	_, err := tx.Exec(`
 	CREATE TABLE IF NOT EXISTS songs (
 		song_id  INTEGER PRIMARY KEY NOT NULL,
 		name     TEXT,
@@ -65,11 +90,117 @@ func (songdb *SongDB) initDB() error {
 		max_notes      INTEGER,
 		PRIMARY KEY (song_id, difficulty)
 	);`)
+	return err
+}
+
+func addChartNoteCounts(tx *sql.Tx) error {
+	for _, column := range []string{"tap_notes", "hold_notes", "slide_notes", "touch_notes", "break_notes"} {
+		_, err := tx.Exec(`ALTER TABLE charts ADD COLUMN ` + column + ` INTEGER NOT NULL DEFAULT 0;`)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// addChartVariants rebuilds charts with variant as part of the primary key,
+// and sets the utage kind of existing utage charts from their song's name
+func addChartVariants(tx *sql.Tx) error {
+	_, err := tx.Exec(`
+	CREATE TABLE charts_new (
+		song_id        INTEGER NOT NULL,
+		difficulty     INTEGER NOT NULL,
+		variant        INTEGER NOT NULL DEFAULT 0,
+		utage_kind     TEXT NOT NULL DEFAULT '',
+		buddy          INTEGER NOT NULL DEFAULT 0,
+		label          TEXT NOT NULL DEFAULT '',
+		level          INTEGER,
+		internal_level INTEGER,
+		notes_designer TEXT,
+		max_notes      INTEGER,
+		tap_notes      INTEGER NOT NULL DEFAULT 0,
+		hold_notes     INTEGER NOT NULL DEFAULT 0,
+		slide_notes    INTEGER NOT NULL DEFAULT 0,
+		touch_notes    INTEGER NOT NULL DEFAULT 0,
+		break_notes    INTEGER NOT NULL DEFAULT 0,
+		PRIMARY KEY (song_id, difficulty, variant)
+	);`)
 	if err != nil {
 		return err
 	}
 
-	return tx.Commit()
+	_, err = tx.Exec(`
+	INSERT INTO charts_new (
+		song_id, difficulty, level,
+		internal_level, notes_designer, max_notes,
+		tap_notes, hold_notes, slide_notes,
+		touch_notes, break_notes)
+	SELECT
+		song_id, difficulty, level,
+		internal_level, notes_designer, max_notes,
+		tap_notes, hold_notes, slide_notes,
+		touch_notes, break_notes
+	FROM charts;`)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(`DROP TABLE charts;`)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(`ALTER TABLE charts_new RENAME TO charts;`)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(`ALTER TABLE song_history ADD COLUMN variant INTEGER;`)
+	if err != nil {
+		return err
+	}
+
+	rows, err := tx.Query(`
+		SELECT DISTINCT songs.song_id, songs.name FROM songs
+		JOIN charts ON charts.song_id=songs.song_id
+		WHERE charts.difficulty=?`, Utage)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	names := make(map[int]string)
+	for rows.Next() {
+		var songId int
+		var name sql.NullString
+		err = rows.Scan(&songId, &name)
+		if err != nil {
+			return err
+		}
+		names[songId] = name.String
+	}
+	if err = rows.Err(); err != nil {
+		return err
+	}
+
+	for songId, name := range names {
+		kind := ParseUtageKind(name)
+		if kind == "" {
+			continue
+		}
+
+		_, err = tx.Exec(`
+		UPDATE charts SET utage_kind=?, buddy=?, label=?
+		WHERE song_id=? AND difficulty=?`,
+			kind, kind == BuddyUtageKind, "["+kind+"]",
+			songId, Utage)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
 }
 
 // AddSong adds a song to the song db, ignoring if the song already exists
@@ -81,12 +212,12 @@ func (songdb *SongDB) AddSong(song SongInfo) error {
 	defer tx.Rollback()
 
 	_, err = tx.Exec(`
-	INSERT OR IGNORE INTO songs (
+	INSERT INTO songs (
 		song_id, name, artist, type,
 		bpm, category, version, sort) VALUES (
 		?, ?, ?, ?,
 		?, ?, ?, ?
-	);`,
+	) ON CONFLICT DO NOTHING;`,
 		song.SongId, song.Name, song.Artist, song.Type,
 		song.Bpm, song.Category, song.Version, song.Sort)
 	if err != nil {
@@ -95,24 +226,43 @@ func (songdb *SongDB) AddSong(song SongInfo) error {
 
 	for _, chart := range song.Charts {
 		_, err = tx.Exec(`
-		INSERT OR IGNORE INTO charts (
-			song_id, difficulty, level,
-			internal_level, notes_designer, max_notes) VALUES (
+		INSERT INTO charts (
+			song_id, difficulty, variant,
+			utage_kind, buddy, label, level,
+			internal_level, notes_designer, max_notes,
+			tap_notes, hold_notes, slide_notes,
+			touch_notes, break_notes) VALUES (
 			?, ?, ?,
-			?, ?, ?
-		);`,
-			song.SongId, chart.Difficulty, chart.Level,
+			?, ?, ?, ?,
+			?, ?, ?,
+			?, ?, ?,
+			?, ?
+		) ON CONFLICT DO NOTHING;`,
+			song.SongId, chart.Difficulty, chart.Variant,
+			chart.UtageKind, chart.Buddy, chart.Label, chart.Level,
 			chart.InternalLevel, chart.NotesDesigner,
-			chart.MaxNotes)
+			chart.MaxNotes,
+			chart.TapNotes, chart.HoldNotes, chart.SlideNotes,
+			chart.TouchNotes, chart.BreakNotes)
 		if err != nil {
 			return err
 		}
 	}
 
+	err = indexSong(tx, song.SongId)
+	if err != nil {
+		return err
+	}
+
 	return tx.Commit()
 }
 
-// takes row with one item
+// songColumns lists the columns of songs in the order rowsToSongInfos scans them
+const songColumns = `
+	song_id, name, artist, type,
+	bpm, category, version, sort`
+
+// takes rows of songs
 // caller's responsibility to call Close() on rows
 func (songdb *SongDB) rowsToSongInfos(rows *sql.Rows) ([]SongInfo, error) {
 	songs := make([]SongInfo, 0, 1)
@@ -125,30 +275,25 @@ func (songdb *SongDB) rowsToSongInfos(rows *sql.Rows) ([]SongInfo, error) {
 			return songs, err
 		}
 
-		chartRows, err := songdb.db.Query(`
-			SELECT difficulty, level, internal_level,
-			notes_designer, max_notes FROM charts WHERE song_id=?`, song.SongId)
+		songs = append(songs, song)
+	}
+	if err := rows.Err(); err != nil {
+		return songs, err
+	}
+
+	// charts are queried once rows is exhausted, so that
+	// only one query is open at a time
+	for i := range songs {
+		charts, err := songdb.getCharts(songs[i].SongId)
 		if err != nil {
 			return songs, err
 		}
-		defer chartRows.Close()
-
-		for chartRows.Next() {
-			chart := ChartInfo{}
-			err = chartRows.Scan(&chart.Difficulty, &chart.Level, &chart.InternalLevel,
-					&chart.NotesDesigner, &chart.MaxNotes)
-			if err != nil {
-				return songs, err
-			}
-
-			song.Charts = append(song.Charts, chart)
-		}
 
-		if len(song.Charts) == 0 {
+		if len(charts) == 0 {
 			return songs, errors.New("no chart found")
 		}
 
-		songs = append(songs, song)
+		songs[i].Charts = charts
 	}
 
 	if len(songs) <= 0 {
@@ -158,10 +303,42 @@ func (songdb *SongDB) rowsToSongInfos(rows *sql.Rows) ([]SongInfo, error) {
 	return songs, nil
 }
 
+func (songdb *SongDB) getCharts(songId int) ([]ChartInfo, error) {
+	rows, err := songdb.rdb.Query(`
+		SELECT difficulty, variant, utage_kind, buddy, label,
+		level, internal_level,
+		notes_designer, max_notes,
+		tap_notes, hold_notes, slide_notes,
+		touch_notes, break_notes FROM charts WHERE song_id=?
+		ORDER BY difficulty ASC, variant ASC`, songId)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var charts []ChartInfo
+	for rows.Next() {
+		chart := ChartInfo{}
+		err = rows.Scan(&chart.Difficulty, &chart.Variant, &chart.UtageKind,
+				&chart.Buddy, &chart.Label,
+				&chart.Level, &chart.InternalLevel,
+				&chart.NotesDesigner, &chart.MaxNotes,
+				&chart.TapNotes, &chart.HoldNotes, &chart.SlideNotes,
+				&chart.TouchNotes, &chart.BreakNotes)
+		if err != nil {
+			return nil, err
+		}
+
+		charts = append(charts, chart)
+	}
+
+	return charts, rows.Err()
+}
+
 // GetSong gets a song from the database using the songId
 func (songdb *SongDB) GetSong(songId int) (SongInfo, error) {
-	rows, err := songdb.db.Query(`
-		SELECT * FROM songs WHERE song_id=?`, songId)
+	rows, err := songdb.rdb.Query(`
+		SELECT `+songColumns+` FROM songs WHERE song_id=?`, songId)
 	if err != nil {
 		return SongInfo{}, err
 	}
@@ -178,11 +355,31 @@ func (songdb *SongDB) GetSong(songId int) (SongInfo, error) {
 	return songs[0], nil
 }
 
-// GetSongByName returns songs from the database using 'name'
+// GetSongs returns every song in the database ordered by songId
+func (songdb *SongDB) GetSongs() ([]SongInfo, error) {
+	rows, err := songdb.rdb.Query(`
+		SELECT `+songColumns+` FROM songs ORDER BY song_id ASC`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	songs, err := songdb.rowsToSongInfos(rows)
+	if _, ok := err.(*SongNotFoundError); ok {
+		return songs, nil
+	}
+
+	return songs, err
+}
+
+// GetSongByName returns songs from the database using 'name',
+// which is either their name or one of their aliases.
 // Can return both the std and dx versions
 func (songdb *SongDB) GetSongsByName(name string) ([]SongInfo, error) {
-	rows, err := songdb.db.Query(`
-		SELECT * FROM songs WHERE name=?`, name)
+	rows, err := songdb.rdb.Query(`
+		SELECT `+songColumns+` FROM songs WHERE name=? OR song_id IN (
+			SELECT song_id FROM song_aliases WHERE alias=?
+		) ORDER BY song_id ASC`, name, name)
 	if err != nil {
 		return nil, err
 	}
@@ -199,6 +396,17 @@ func (songdb *SongDB) GetSongsByName(name string) ([]SongInfo, error) {
 	return songs, nil
 }
 
+type ChartNotFoundError struct {
+	SongId     int
+	Difficulty Difficulty
+	Variant    int
+}
+
+func (e *ChartNotFoundError) Error() string {
+	return fmt.Sprintf("Chart with song id %d, difficulty %d and variant %d not found in database",
+		e.SongId, e.Difficulty, e.Variant)
+}
+
 type SongNotFoundError struct {
 	SongId	int
 	Name    string
@@ -107,7 +107,7 @@ func TestAddAndGetSong(t *testing.T) {
 }
 
 func TestGetSongsByName(t *testing.T) {
-	db, err := sql.Open("sqlite3", "../songs.db")
+	db, err := sql.Open("sqlite3", copyDB(t, "../songs.db"))
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -157,3 +157,67 @@ func TestGetSongsByName(t *testing.T) {
 		t.Error("song3: incorrect song retrieved")
 	}
 }
+
+func TestChartVariants(t *testing.T) {
+	db, err := sql.Open("sqlite3", copyDB(t, "../songs.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	songdb, err := database.NewSongDB(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// utage charts in songs.db get their kind from the song's name when migrating
+	song1, err := songdb.GetSong(100018)
+	if err != nil {
+		t.Fatal(err)
+	}
+	chart, ok := song1.Chart(database.Utage, 0)
+	if !ok {
+		t.Fatal("utage chart of song1 not found")
+	}
+	if chart.UtageKind != "協" || !chart.Buddy || chart.Label != "[協]" {
+		t.Error("utage chart of song1 not migrated:", chart)
+	}
+
+	song2 := database.SongInfo{
+		SongId:  100999,
+		Name:    "[協]buddy song",
+		Type:    "dx",
+		Charts:  []database.ChartInfo{
+			{Difficulty: database.Utage, Variant: 0, UtageKind: "協", Buddy: true, Label: "[協] 1P",
+			 Level: 13, InternalLevel: 130, MaxNotes: 500},
+			{Difficulty: database.Utage, Variant: 1, UtageKind: "協", Buddy: true, Label: "[協] 2P",
+			 Level: 13, InternalLevel: 130, MaxNotes: 480},
+		},
+	}
+
+	err = songdb.AddSong(song2)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	song2g, err := songdb.GetSong(song2.SongId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(song2, song2g) {
+		t.Log(song2)
+		t.Log(song2g)
+		t.Error("song2 not equal")
+	}
+
+	if _, ok := song2g.Chart(database.Utage, 2); ok {
+		t.Error("found non-existant variant")
+	}
+
+	if kind := database.ParseUtageKind("[蛸]チルノのパーフェクトさんすう教室"); kind != "蛸" {
+		t.Error("ParseUtageKind: expected 蛸, got", kind)
+	}
+	if kind := database.ParseUtageKind("終焉逃避行"); kind != "" {
+		t.Error("ParseUtageKind: expected no kind, got", kind)
+	}
+}
@@ -0,0 +1,393 @@
+// Copyright (C) 2025 Ethan Cheng <ethan@nijika.org>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+// Package database handles the playlog and song database
+package database
+
+import (
+	"fmt"
+	"database/sql"
+	"strconv"
+	"time"
+)
+
+// SongChange is a change to a song or one of its charts
+type SongChange struct {
+	SongId     int
+	Difficulty *Difficulty // nil if the song itself changed
+	Variant    int         // of the chart, if Difficulty is set
+	Field      string      // name of the column, or "song" / "chart" if one was added
+	Old        string
+	New        string
+	ChangedAt  int64 // Unix timestamp, only set on changes from GetSongHistory
+}
+
+// SongDiff is the difference between the songs in the database
+// and a list of songs, e.g. from songs.json
+type SongDiff struct {
+	Added   []SongInfo   // songs not in the database
+	Changed []SongChange // changes to songs already in the database
+	Removed []SongInfo   // songs in the database, but not in the list
+}
+
+func createSongHistoryTable(tx *sql.Tx) error {
+	_, err := tx.Exec(`
+	CREATE TABLE IF NOT EXISTS song_history (
+		history_id INTEGER PRIMARY KEY AUTOINCREMENT,
+		song_id    INTEGER NOT NULL,
+		difficulty INTEGER,
+		field      TEXT NOT NULL,
+		old_value  TEXT,
+		new_value  TEXT,
+		changed_at INTEGER NOT NULL
+	);`)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(`
+	CREATE INDEX IF NOT EXISTS song_history_song_id ON song_history (song_id);`)
+	return err
+}
+
+// diffSong returns the changes needed to turn old into new.
+// Charts that are missing from new are left alone.
+func diffSong(old, new SongInfo) []SongChange {
+	changes := make([]SongChange, 0)
+
+	field := func(name, o, n string) {
+		if o != n {
+			changes = append(changes, SongChange{SongId: new.SongId, Field: name, Old: o, New: n})
+		}
+	}
+	field("name", old.Name, new.Name)
+	field("artist", old.Artist, new.Artist)
+	field("type", old.Type, new.Type)
+	field("bpm", strconv.Itoa(old.Bpm), strconv.Itoa(new.Bpm))
+	field("category", old.Category, new.Category)
+	field("version", old.Version, new.Version)
+	field("sort", old.Sort, new.Sort)
+
+	for _, n := range new.Charts {
+		difficulty := n.Difficulty
+
+		o, ok := old.Chart(difficulty, n.Variant)
+		if !ok {
+			changes = append(changes, SongChange{SongId: new.SongId, Difficulty: &difficulty, Variant: n.Variant,
+				Field: "chart", New: "added"})
+			continue
+		}
+
+		chartField := func(name, o, v string) {
+			if o != v {
+				changes = append(changes, SongChange{SongId: new.SongId, Difficulty: &difficulty, Variant: n.Variant,
+					Field: name, Old: o, New: v})
+			}
+		}
+		chartField("utage_kind", o.UtageKind, n.UtageKind)
+		chartField("buddy", strconv.FormatBool(o.Buddy), strconv.FormatBool(n.Buddy))
+		chartField("label", o.Label, n.Label)
+		chartField("level", strconv.Itoa(o.Level), strconv.Itoa(n.Level))
+		chartField("internal_level", strconv.Itoa(o.InternalLevel), strconv.Itoa(n.InternalLevel))
+		chartField("notes_designer", o.NotesDesigner, n.NotesDesigner)
+		chartField("max_notes", strconv.Itoa(o.MaxNotes), strconv.Itoa(n.MaxNotes))
+		chartField("tap_notes", strconv.Itoa(o.TapNotes), strconv.Itoa(n.TapNotes))
+		chartField("hold_notes", strconv.Itoa(o.HoldNotes), strconv.Itoa(n.HoldNotes))
+		chartField("slide_notes", strconv.Itoa(o.SlideNotes), strconv.Itoa(n.SlideNotes))
+		chartField("touch_notes", strconv.Itoa(o.TouchNotes), strconv.Itoa(n.TouchNotes))
+		chartField("break_notes", strconv.Itoa(o.BreakNotes), strconv.Itoa(n.BreakNotes))
+	}
+
+	return changes
+}
+
+// DiffSongs compares songs with the database without changing anything
+func (songdb *SongDB) DiffSongs(songs []SongInfo) (SongDiff, error) {
+	diff := SongDiff{}
+
+	current, err := songdb.GetSongs()
+	if err != nil {
+		return diff, err
+	}
+
+	byId := make(map[int]SongInfo, len(current))
+	for _, song := range current {
+		byId[song.SongId] = song
+	}
+
+	seen := make(map[int]bool, len(songs))
+	for _, song := range songs {
+		seen[song.SongId] = true
+
+		old, ok := byId[song.SongId]
+		if !ok {
+			diff.Added = append(diff.Added, song)
+			continue
+		}
+
+		diff.Changed = append(diff.Changed, diffSong(old, song)...)
+	}
+
+	for _, song := range current {
+		if !seen[song.SongId] {
+			diff.Removed = append(diff.Removed, song)
+		}
+	}
+
+	return diff, nil
+}
+
+// UpsertSong adds song to the database, or updates it if it already exists.
+// Every change is recorded in the song history and returned.
+// Charts that are in the database but not in song are kept.
+// Changes to internal levels are also recorded in chart_levels, effective now.
+func (songdb *SongDB) UpsertSong(song SongInfo) ([]SongChange, error) {
+	var changes []SongChange
+
+	old, err := songdb.GetSong(song.SongId)
+	if _, ok := err.(*SongNotFoundError); ok {
+		changes = upsertChanges(SongInfo{}, false, song)
+	} else if err != nil {
+		return nil, err
+	} else {
+		changes = upsertChanges(old, true, song)
+		if len(changes) == 0 {
+			return changes, nil
+		}
+	}
+
+	tx, err := songdb.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	err = upsertSong(tx, song, changes, time.Now().Unix())
+	if err != nil {
+		return nil, err
+	}
+
+	return changes, tx.Commit()
+}
+
+// UpsertSongs is UpsertSong for every song in songs, in one transaction:
+// either all of them are upserted or, on error, none are.
+// Every change is returned.
+func (songdb *SongDB) UpsertSongs(songs []SongInfo) ([]SongChange, error) {
+	current, err := songdb.GetSongs()
+	if err != nil {
+		return nil, err
+	}
+
+	byId := make(map[int]SongInfo, len(current))
+	for _, song := range current {
+		byId[song.SongId] = song
+	}
+
+	tx, err := songdb.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	now := time.Now().Unix()
+	all := make([]SongChange, 0)
+	for _, song := range songs {
+		old, ok := byId[song.SongId]
+		changes := upsertChanges(old, ok, song)
+		if len(changes) == 0 {
+			continue
+		}
+
+		err = upsertSong(tx, song, changes, now)
+		if err != nil {
+			return nil, fmt.Errorf("song %d: %w", song.SongId, err)
+		}
+		all = append(all, changes...)
+	}
+
+	return all, tx.Commit()
+}
+
+// upsertChanges returns the changes upserting song makes
+// to old, which exists if it's in the database
+func upsertChanges(old SongInfo, exists bool, song SongInfo) []SongChange {
+	if !exists {
+		return []SongChange{{SongId: song.SongId, Field: "song", New: "added"}}
+	}
+	return diffSong(old, song)
+}
+
+// upsertSong writes song in tx and records its changes at now
+func upsertSong(tx *poolTx, song SongInfo, changes []SongChange, now int64) error {
+	_, err := tx.Exec(`
+	INSERT INTO songs (
+		song_id, name, artist, type,
+		bpm, category, version, sort) VALUES (
+		?, ?, ?, ?,
+		?, ?, ?, ?
+	) ON CONFLICT (song_id) DO UPDATE SET
+		name=excluded.name, artist=excluded.artist, type=excluded.type,
+		bpm=excluded.bpm, category=excluded.category,
+		version=excluded.version, sort=excluded.sort;`,
+		song.SongId, song.Name, song.Artist, song.Type,
+		song.Bpm, song.Category, song.Version, song.Sort)
+	if err != nil {
+		return err
+	}
+
+	for _, chart := range song.Charts {
+		_, err = tx.Exec(`
+		INSERT INTO charts (
+			song_id, difficulty, variant,
+			utage_kind, buddy, label, level,
+			internal_level, notes_designer, max_notes,
+			tap_notes, hold_notes, slide_notes,
+			touch_notes, break_notes) VALUES (
+			?, ?, ?,
+			?, ?, ?, ?,
+			?, ?, ?,
+			?, ?, ?,
+			?, ?
+		) ON CONFLICT (song_id, difficulty, variant) DO UPDATE SET
+			utage_kind=excluded.utage_kind, buddy=excluded.buddy,
+			label=excluded.label, level=excluded.level, internal_level=excluded.internal_level,
+			notes_designer=excluded.notes_designer, max_notes=excluded.max_notes,
+			tap_notes=excluded.tap_notes, hold_notes=excluded.hold_notes,
+			slide_notes=excluded.slide_notes, touch_notes=excluded.touch_notes,
+			break_notes=excluded.break_notes;`,
+			song.SongId, chart.Difficulty, chart.Variant,
+			chart.UtageKind, chart.Buddy, chart.Label, chart.Level,
+			chart.InternalLevel, chart.NotesDesigner,
+			chart.MaxNotes,
+			chart.TapNotes, chart.HoldNotes, chart.SlideNotes,
+			chart.TouchNotes, chart.BreakNotes)
+		if err != nil {
+			return err
+		}
+	}
+
+	err = indexSong(tx, song.SongId)
+	if err != nil {
+		return err
+	}
+
+	for i := range changes {
+		changes[i].ChangedAt = now
+
+		// chart_levels only keeps the levels of each difficulty's
+		// main chart, since other variants aren't rated
+		if changes[i].Field == "internal_level" && changes[i].Variant == 0 {
+			err = recordInternalLevelChange(tx, changes[i], now)
+			if err != nil {
+				return err
+			}
+		}
+
+		var difficulty, variant sql.NullInt64
+		if changes[i].Difficulty != nil {
+			difficulty = sql.NullInt64{Int64: int64(*changes[i].Difficulty), Valid: true}
+			variant = sql.NullInt64{Int64: int64(changes[i].Variant), Valid: true}
+		}
+
+		_, err = tx.Exec(`
+		INSERT INTO song_history (
+			song_id, difficulty, variant, field,
+			old_value, new_value, changed_at) VALUES (
+			?, ?, ?, ?,
+			?, ?, ?
+		);`,
+			changes[i].SongId, difficulty, variant, changes[i].Field,
+			changes[i].Old, changes[i].New, now)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// recordInternalLevelChange adds the new internal level to chart_levels.
+// If the chart had no recorded levels, the old level is recorded as
+// having always applied, so plays before the change keep their old level.
+func recordInternalLevelChange(tx *poolTx, change SongChange, now int64) error {
+	oldLevel, err := strconv.Atoi(change.Old)
+	if err != nil {
+		return err
+	}
+	newLevel, err := strconv.Atoi(change.New)
+	if err != nil {
+		return err
+	}
+
+	var count int
+	err = tx.QueryRow(`
+		SELECT COUNT(*) FROM chart_levels WHERE song_id=? AND difficulty=?`,
+		change.SongId, *change.Difficulty).Scan(&count)
+	if err != nil {
+		return err
+	}
+
+	if count == 0 {
+		err = addChartLevel(tx, ChartLevel{
+			SongId:        change.SongId,
+			Difficulty:    *change.Difficulty,
+			EffectiveFrom: 0,
+			InternalLevel: oldLevel,
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	return addChartLevel(tx, ChartLevel{
+		SongId:        change.SongId,
+		Difficulty:    *change.Difficulty,
+		EffectiveFrom: now,
+		InternalLevel: newLevel,
+	})
+}
+
+// GetSongHistory returns the recorded changes to a song, oldest first
+func (songdb *SongDB) GetSongHistory(songId int) ([]SongChange, error) {
+	rows, err := songdb.rdb.Query(`
+		SELECT song_id, difficulty, COALESCE(variant, 0), field,
+		COALESCE(old_value, ''), COALESCE(new_value, ''), changed_at
+		FROM song_history WHERE song_id=? ORDER BY history_id ASC`, songId)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	changes := make([]SongChange, 0)
+	for rows.Next() {
+		change := SongChange{}
+		var difficulty sql.NullInt64
+		err = rows.Scan(&change.SongId, &difficulty, &change.Variant, &change.Field,
+				&change.Old, &change.New, &change.ChangedAt)
+		if err != nil {
+			return nil, err
+		}
+
+		if difficulty.Valid {
+			d := Difficulty(difficulty.Int64)
+			change.Difficulty = &d
+		}
+
+		changes = append(changes, change)
+	}
+
+	return changes, rows.Err()
+}
@@ -0,0 +1,227 @@
+// Copyright (C) 2025 Ethan Cheng <ethan@nijika.org>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package database_test
+
+import (
+	"testing"
+	"reflect"
+	"path/filepath"
+	"database/sql"
+	_ "github.com/mattn/go-sqlite3"
+
+	"github.com/yadayadajaychan/playlog/database"
+)
+
+func TestUpsertSong(t *testing.T) {
+	db, err := sql.Open("sqlite3", filepath.Join(t.TempDir(), "songs.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	songdb, err := database.NewSongDB(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	song1 := database.SongInfo{
+		SongId:   11441,
+		Name:     "終焉逃避行",
+		Artist:   "ルゼ",
+		Type:     "dx",
+		Bpm:      200,
+		Category: "niconico&ボーカロイド",
+		Version:  "BUDDiES PLUS",
+		Sort:     "312345",
+		Charts: []database.ChartInfo{
+			{Difficulty: database.Expert, Level: 11, InternalLevel: 114, MaxNotes: 600},
+			{Difficulty: database.Master, Level: 13, InternalLevel: 137, MaxNotes: 783,
+			 TapNotes: 539, HoldNotes: 79, SlideNotes: 99, TouchNotes: 20, BreakNotes: 46},
+		},
+	}
+	song2 := database.SongInfo{
+		SongId:  1,
+		Name:    "Sweet Home Alabama",
+		Type:    "std",
+		Charts:  []database.ChartInfo{{Difficulty: database.Basic, Level: 6, InternalLevel: 60}},
+	}
+
+	err = songdb.AddSong(song1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = songdb.AddSong(song2)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// SEGA re-rates the master chart and adds a re:master
+	song1n := song1
+	song1n.Charts = []database.ChartInfo{
+		song1.Charts[0],
+		{Difficulty: database.Master, Level: 13, InternalLevel: 138, MaxNotes: 783,
+		 TapNotes: 539, HoldNotes: 79, SlideNotes: 99, TouchNotes: 20, BreakNotes: 46},
+		{Difficulty: database.ReMaster, Level: 14, InternalLevel: 145, MaxNotes: 900},
+	}
+	song3 := database.SongInfo{
+		SongId:  11442,
+		Name:    "new song",
+		Type:    "dx",
+		Charts:  []database.ChartInfo{{Difficulty: database.Basic, Level: 3, InternalLevel: 30}},
+	}
+
+	diff, err := songdb.DiffSongs([]database.SongInfo{song1n, song3})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(diff.Added) != 1 || diff.Added[0].SongId != song3.SongId {
+		t.Error("diff.Added:", diff.Added)
+	}
+	if len(diff.Removed) != 1 || diff.Removed[0].SongId != song2.SongId {
+		t.Error("diff.Removed:", diff.Removed)
+	}
+	if len(diff.Changed) != 2 {
+		t.Fatal("diff.Changed:", diff.Changed)
+	}
+	if c := diff.Changed[0]; c.Field != "internal_level" || *c.Difficulty != database.Master ||
+	   c.Old != "137" || c.New != "138" {
+		t.Error("diff.Changed[0]:", c)
+	}
+	if c := diff.Changed[1]; c.Field != "chart" || *c.Difficulty != database.ReMaster {
+		t.Error("diff.Changed[1]:", c)
+	}
+
+	// diffing must not change anything
+	song1g, err := songdb.GetSong(song1.SongId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if song1g.Charts[1].InternalLevel != 137 {
+		t.Error("DiffSongs changed the db")
+	}
+	if !reflect.DeepEqual(song1g.Charts[1], song1.Charts[1]) {
+		t.Error("chart not equal after round trip:", song1g.Charts[1])
+	}
+
+	changes, err := songdb.UpsertSong(song1n)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(changes) != 2 {
+		t.Error("UpsertSong: expected 2 changes, got", changes)
+	}
+
+	song1g, err = songdb.GetSong(song1.SongId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(song1g.Charts) != 3 || song1g.Charts[1].InternalLevel != 138 {
+		t.Error("song not updated:", song1g)
+	}
+
+	changes, err = songdb.UpsertSong(song1n)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(changes) != 0 {
+		t.Error("UpsertSong: expected no changes, got", changes)
+	}
+
+	_, err = songdb.UpsertSong(song3)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	history, err := songdb.GetSongHistory(song1.SongId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(history) != 2 || history[0].ChangedAt == 0 {
+		t.Error("song1 history:", history)
+	}
+
+	history, err = songdb.GetSongHistory(song3.SongId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(history) != 1 || history[0].Field != "song" || history[0].Difficulty != nil {
+		t.Error("song3 history:", history)
+	}
+}
+
+func TestUpsertSongsInOneTransaction(t *testing.T) {
+	db, err := sql.Open("sqlite3", filepath.Join(t.TempDir(), "songs.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	songdb, err := database.NewSongDB(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	songs := []database.SongInfo{
+		{SongId: 11441, Name: "終焉逃避行", Type: "dx",
+		 Charts: []database.ChartInfo{{Difficulty: database.Master, Level: 13, InternalLevel: 137}}},
+		{SongId: 11442, Name: "test", Type: "dx",
+		 Charts: []database.ChartInfo{{Difficulty: database.Master, Level: 12, InternalLevel: 125}}},
+	}
+
+	// the second song fails, so the first isn't added either
+	_, err = db.Exec(`
+		CREATE TRIGGER fail_song BEFORE INSERT ON songs WHEN NEW.song_id=11442
+		BEGIN SELECT RAISE(ABORT, 'failed'); END`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = songdb.UpsertSongs(songs)
+	if err == nil {
+		t.Fatal("expected an error for song 11442")
+	}
+	_, err = songdb.GetSong(11441)
+	if _, ok := err.(*database.SongNotFoundError); !ok {
+		t.Fatalf("expected song 11441 not to be added, got %v", err)
+	}
+
+	_, err = db.Exec(`DROP TRIGGER fail_song`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	changes, err := songdb.UpsertSongs(songs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(changes) != 2 {
+		t.Error("expected 2 songs added, got", changes)
+	}
+
+	songs[0].Charts[0].InternalLevel = 138
+	changes, err = songdb.UpsertSongs(songs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(changes) != 1 || changes[0].Field != "internal_level" {
+		t.Error("expected the internal level to change, got", changes)
+	}
+	level, err := songdb.GetInternalLevelAt(11441, database.Master, changes[0].ChangedAt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if level != 138 {
+		t.Errorf("expected internal level 138, got %d", level)
+	}
+}
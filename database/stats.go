@@ -0,0 +1,113 @@
+// Copyright (C) 2025 Ethan Cheng <ethan@nijika.org>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+// Package database handles the playlog and song database
+package database
+
+// NoteTypeStats is the no. of judgements of one note type over many plays
+type NoteTypeStats struct {
+	NoteType	string // "Tap", "Hold", "Slide", "Touch" or "Break"
+	CriticalPerfect	int
+	Perfect		int
+	Great		int
+	Good		int
+	Miss		int
+}
+
+// Total returns the no. of notes judged
+func (s NoteTypeStats) Total() int {
+	return s.CriticalPerfect + s.Perfect + s.Great + s.Good + s.Miss
+}
+
+// Accuracy returns the share of the maximum base score achieved on
+// this note type, counting perfects as 100%, greats as 80% and goods as 50%,
+// or 0 if no notes were judged
+func (s NoteTypeStats) Accuracy() float64 {
+	total := s.Total()
+	if total == 0 {
+		return 0
+	}
+
+	return (float64(s.CriticalPerfect+s.Perfect) + 0.8*float64(s.Great) +
+		0.5*float64(s.Good)) / float64(total)
+}
+
+// GetNoteTypeStats adds up the judgements of each note type over all plays
+// of the player. Plays without detailed judgements don't count.
+func (playdb *PlayDB) GetNoteTypeStats() ([]NoteTypeStats, error) {
+	stats := []NoteTypeStats{
+		{NoteType: "Tap"}, {NoteType: "Hold"}, {NoteType: "Slide"},
+		{NoteType: "Touch"}, {NoteType: "Break"},
+	}
+
+	err := playdb.rdb.QueryRow(`
+	SELECT
+		COALESCE(SUM(tap_critical_perfect), 0), COALESCE(SUM(tap_perfect), 0),
+		COALESCE(SUM(tap_great), 0), COALESCE(SUM(tap_good), 0), COALESCE(SUM(tap_miss), 0),
+
+		COALESCE(SUM(hold_critical_perfect), 0), COALESCE(SUM(hold_perfect), 0),
+		COALESCE(SUM(hold_great), 0), COALESCE(SUM(hold_good), 0), COALESCE(SUM(hold_miss), 0),
+
+		COALESCE(SUM(slide_critical_perfect), 0), COALESCE(SUM(slide_perfect), 0),
+		COALESCE(SUM(slide_great), 0), COALESCE(SUM(slide_good), 0), COALESCE(SUM(slide_miss), 0),
+
+		COALESCE(SUM(touch_critical_perfect), 0), COALESCE(SUM(touch_perfect), 0),
+		COALESCE(SUM(touch_great), 0), COALESCE(SUM(touch_good), 0), COALESCE(SUM(touch_miss), 0),
+
+		COALESCE(SUM(break_critical_perfect), 0), COALESCE(SUM(break_perfect), 0),
+		COALESCE(SUM(break_great), 0), COALESCE(SUM(break_good), 0), COALESCE(SUM(break_miss), 0)
+	FROM plays WHERE player_id=?`, playdb.playerId).Scan(
+		&stats[0].CriticalPerfect, &stats[0].Perfect, &stats[0].Great, &stats[0].Good, &stats[0].Miss,
+		&stats[1].CriticalPerfect, &stats[1].Perfect, &stats[1].Great, &stats[1].Good, &stats[1].Miss,
+		&stats[2].CriticalPerfect, &stats[2].Perfect, &stats[2].Great, &stats[2].Good, &stats[2].Miss,
+		&stats[3].CriticalPerfect, &stats[3].Perfect, &stats[3].Great, &stats[3].Good, &stats[3].Miss,
+		&stats[4].CriticalPerfect, &stats[4].Perfect, &stats[4].Great, &stats[4].Good, &stats[4].Miss)
+	if err != nil {
+		return nil, err
+	}
+
+	return stats, nil
+}
+
+// PlayCount is the no. of plays in a quarter of an hour
+type PlayCount struct {
+	Start int64 // Unix timestamp of the start of the quarter hour
+	Plays int
+}
+
+// GetPlayCounts returns the no. of plays of the player in every quarter
+// of an hour with plays, oldest first. Quarter hours add up to the hours
+// and days of any time zone, so they are grouped by the caller.
+func (playdb *PlayDB) GetPlayCounts() ([]PlayCount, error) {
+	rows, err := playdb.rdb.Query(`
+	SELECT user_play_date / 900 * 900 AS quarter, COUNT(*) FROM plays
+	WHERE player_id=? GROUP BY quarter ORDER BY quarter ASC`, playdb.playerId)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	counts := make([]PlayCount, 0)
+	for rows.Next() {
+		var c PlayCount
+		err = rows.Scan(&c.Start, &c.Plays)
+		if err != nil {
+			return nil, err
+		}
+		counts = append(counts, c)
+	}
+
+	return counts, rows.Err()
+}
@@ -0,0 +1,133 @@
+// Copyright (C) 2025 Ethan Cheng <ethan@nijika.org>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+// Package database handles the playlog and song database
+package database
+
+import (
+	"context"
+	"time"
+	"database/sql"
+)
+
+// PlayStore keeps the plays of every player. Like PlayDB, which implements
+// it for sqlite, its methods only see the plays of one player.
+type PlayStore interface {
+	// PlayerId returns the id of the player whose plays are read and written
+	PlayerId() int64
+	// WithPlayer returns a PlayStore that reads and writes the plays of playerId
+	WithPlayer(playerId int64) PlayStore
+	// WithValidation returns a PlayStore that handles plays that fail
+	// validation according to mode
+	WithValidation(mode ValidationMode) PlayStore
+	// WithContext returns a PlayStore whose queries are cancelled with ctx
+	WithContext(ctx context.Context) PlayStore
+
+	AddPlay(play PlayInfo) error
+	AddPlayWithResult(play PlayInfo) (AddPlayResult, error)
+	GetPlay(date int64) (PlayInfo, error)
+	GetPlayBySourceId(source, sourceId string) (PlayInfo, error)
+	GetPlays(ascending bool, limit, offset int) ([]PlayInfo, error)
+	GetCount() (int, error)
+	GetBestScoreBeforeDate(songId int, difficulty Difficulty, date int64) (int, error)
+	GetBestScoreOfVariantBeforeDate(songId int, difficulty Difficulty, variant int, date int64) (int, error)
+	GetPlaysOfVariantBeforeDate(songId int, difficulty Difficulty, variant int, date int64) ([]PlayInfo, error)
+	GetPlayBefore(date int64) (PlayInfo, error)
+	GetPlayAfter(date int64) (PlayInfo, error)
+	GetNoteTypeStats() ([]NoteTypeStats, error)
+	GetPlayCounts() ([]PlayCount, error)
+	GetSessions(gap time.Duration) ([]Session, error)
+	GetSession(sessionId int64, gap time.Duration) (Session, []SessionPlay, error)
+
+	QuarantinePlay(play QuarantinedPlay) error
+	GetQuarantinedPlays(source string) ([]QuarantinedPlay, error)
+	IsQuarantined(source, sourceId string) (bool, error)
+	ReleaseQuarantinedPlay(quarantineId int64) error
+
+	AddPlayer(player PlayerInfo) (int64, error)
+	UpdatePlayer(player PlayerInfo) error
+	GetPlayers() ([]PlayerInfo, error)
+	GetPlayer(playerId int64) (PlayerInfo, error)
+	GetPlayerByName(name string) (PlayerInfo, error)
+
+	Close() error
+}
+
+// SongStore keeps songs, their charts and the history of both.
+// SongDB implements it for sqlite.
+type SongStore interface {
+	// WithContext returns a SongStore whose queries are cancelled with ctx
+	WithContext(ctx context.Context) SongStore
+
+	AddSong(song SongInfo) error
+	UpsertSong(song SongInfo) ([]SongChange, error)
+	UpsertSongs(songs []SongInfo) ([]SongChange, error)
+	DiffSongs(songs []SongInfo) (SongDiff, error)
+	GetSong(songId int) (SongInfo, error)
+	GetSongs() ([]SongInfo, error)
+	GetSongsByName(name string) ([]SongInfo, error)
+	GetSongHistory(songId int) ([]SongChange, error)
+	SearchSongs(query string, limit int) ([]SongMatch, error)
+
+	AddSongAlias(songId int, alias string) error
+	RemoveSongAlias(songId int, alias string) error
+	GetSongAliases(songId int) ([]string, error)
+	GetAllSongAliases() ([]SongAlias, error)
+	ImportSongAliases(aliases []SongAlias) (int, error)
+
+	AddChartLevel(level ChartLevel) error
+	GetChartLevels(songId int, difficulty Difficulty) ([]ChartLevel, error)
+	GetInternalLevelAt(songId int, difficulty Difficulty, date int64) (int, error)
+
+	Close() error
+}
+
+var _ PlayStore = (*PlayDB)(nil)
+var _ SongStore = (*SongDB)(nil)
+
+// WithPlayer is ForPlayer for PlayStore
+func (playdb *PlayDB) WithPlayer(playerId int64) PlayStore {
+	return playdb.ForPlayer(playerId)
+}
+
+// WithValidation is WithValidationMode for PlayStore
+func (playdb *PlayDB) WithValidation(mode ValidationMode) PlayStore {
+	return playdb.WithValidationMode(mode)
+}
+
+// WithContext returns a PlayDB that shares the database with playdb,
+// but whose queries and transactions are cancelled with ctx,
+// e.g. the context of an http request
+func (playdb *PlayDB) WithContext(ctx context.Context) PlayStore {
+	p := *playdb
+	p.db = playdb.db.withContext(ctx)
+	p.rdb = playdb.rdb.withContext(ctx)
+	return &p
+}
+
+// WithContext returns a SongDB that shares the database with songdb,
+// but whose queries and transactions are cancelled with ctx
+func (songdb *SongDB) WithContext(ctx context.Context) SongStore {
+	s := *songdb
+	s.db = songdb.db.withContext(ctx)
+	s.rdb = songdb.rdb.withContext(ctx)
+	return &s
+}
+
+// Open opens the sqlite database in the file dsn.
+// Pass it to NewPlayDB or NewSongDB.
+func Open(dsn string) (*sql.DB, error) {
+	return sql.Open("sqlite3", dsn)
+}
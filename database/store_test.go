@@ -0,0 +1,321 @@
+// Copyright (C) 2025 Ethan Cheng <ethan@nijika.org>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package database_test
+
+import (
+	"testing"
+	"reflect"
+	"path/filepath"
+	"database/sql"
+	_ "github.com/mattn/go-sqlite3"
+
+	"github.com/yadayadajaychan/playlog/database"
+)
+
+// testStores runs the same checks against the PlayStore and SongStore
+// of a database, so every backend behaves the same
+func testStores(t *testing.T, playdb database.PlayStore, songdb database.SongStore) {
+	src, err := sql.Open("sqlite3", copyDB(t, "../test/test-plays.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer src.Close()
+
+	srcdb, err := database.NewPlayDB(src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer srcdb.Close()
+
+	plays, err := srcdb.GetPlays(true, 10, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// players
+	playerId, err := playdb.AddPlayer(database.PlayerInfo{Name: "alice", DataSource: "kamai", KamaiUser: "alice"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if playerId == database.DefaultPlayerId {
+		t.Errorf("new player got the id of the default player")
+	}
+
+	player, err := playdb.GetPlayerByName("alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if player.PlayerId != playerId || player.KamaiUser != "alice" || player.AccessCode != "" {
+		t.Errorf("unexpected player %+v", player)
+	}
+
+	players, err := playdb.GetPlayers()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(players) != 2 {
+		t.Errorf("expected 2 players, got %d", len(players))
+	}
+
+	// plays
+	for _, play := range plays {
+		result, err := playdb.AddPlayWithResult(play)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if result != database.PlayAdded {
+			t.Errorf("play %d: expected %s, got %s", play.UserPlayDate, database.PlayAdded, result)
+		}
+	}
+
+	result, err := playdb.AddPlayWithResult(plays[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result != database.PlayDuplicate {
+		t.Errorf("expected %s, got %s", database.PlayDuplicate, result)
+	}
+
+	count, err := playdb.GetCount()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != len(plays) {
+		t.Errorf("expected %d plays, got %d", len(plays), count)
+	}
+
+	got, err := playdb.GetPlays(true, len(plays), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := range got {
+		plays[i].PlayId = got[i].PlayId
+		if !reflect.DeepEqual(got[i], plays[i]) {
+			t.Errorf("play %d: expected %+v, got %+v", i, plays[i], got[i])
+		}
+	}
+
+	play, err := playdb.GetPlay(plays[1].UserPlayDate)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if play.PlayId != plays[1].PlayId {
+		t.Errorf("expected play %d, got %d", plays[1].PlayId, play.PlayId)
+	}
+
+	_, err = playdb.GetBestScoreBeforeDate(plays[0].SongId, plays[0].Difficulty, plays[len(plays)-1].UserPlayDate+1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = playdb.GetNoteTypeStats()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	playCounts, err := playdb.GetPlayCounts()
+	if err != nil {
+		t.Fatal(err)
+	}
+	total := 0
+	for _, c := range playCounts {
+		total += c.Plays
+		if c.Start%900 != 0 {
+			t.Errorf("%d isn't the start of a quarter hour", c.Start)
+		}
+	}
+	if total != len(plays) {
+		t.Errorf("expected %d plays counted, got %d", len(plays), total)
+	}
+
+	// plays of other players aren't seen
+	count, err = playdb.WithPlayer(playerId).GetCount()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != 0 {
+		t.Errorf("expected no plays of alice, got %d", count)
+	}
+
+	// but players can have the same play upstream,
+	// e.g. after exporting the plays of one player and importing them as another's
+	shared := plays[0]
+	shared.UserPlayDate = plays[len(plays)-1].UserPlayDate + 1
+	shared.Source = "solips"
+	shared.SourceId = "shared-playlog"
+	alicedb := playdb.WithPlayer(playerId)
+	for _, p := range []database.PlayStore{playdb, alicedb, alicedb} {
+		result, err = p.AddPlayWithResult(shared)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if result != database.PlayDuplicate {
+		t.Errorf("alice: expected %s, got %s", database.PlayDuplicate, result)
+	}
+	_, err = alicedb.GetPlayBySourceId(shared.Source, shared.SourceId)
+	if err != nil {
+		t.Error(err)
+	}
+
+	// quarantine
+	quarantined := database.QuarantinedPlay{
+		UserPlayDate : 1743108003,
+		SongId       : 11999,
+		Source       : "solips",
+		SourceId     : "quarantined",
+		Reason       : "song not found",
+		Payload      : []byte(`{"Info":{"MusicId":11999}}`),
+	}
+	err = playdb.QuarantinePlay(quarantined)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	qplays, err := playdb.GetQuarantinedPlays("solips")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(qplays) != 1 || string(qplays[0].Payload) != string(quarantined.Payload) {
+		t.Fatalf("unexpected quarantined plays %+v", qplays)
+	}
+
+	err = playdb.ReleaseQuarantinedPlay(qplays[0].QuarantineId)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// songs
+	song := database.SongInfo{
+		SongId:   11441,
+		Name:     "テストソング",
+		Artist:   "someone",
+		Type:     "dx",
+		Bpm:      150,
+		Category: "maimai",
+		Version:  "PRiSM",
+		Sort:     "100000",
+		Charts: []database.ChartInfo{
+			{Difficulty: database.Master, Level: 13, InternalLevel: 137, MaxNotes: 783},
+			{Difficulty: database.Utage, Variant: 1, UtageKind: "協", Buddy: true, Label: "[協] 1P", Level: 14, InternalLevel: 140, MaxNotes: 500},
+		},
+	}
+
+	changes, err := songdb.UpsertSong(song)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(changes) != 1 {
+		t.Errorf("expected the song to be added, got %+v", changes)
+	}
+
+	err = songdb.AddSong(song) // already there, ignored
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	gotSong, err := songdb.GetSong(song.SongId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(gotSong, song) {
+		t.Errorf("expected %+v, got %+v", song, gotSong)
+	}
+
+	songs, err := songdb.GetSongsByName(song.Name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(songs) != 1 {
+		t.Errorf("expected 1 song, got %d", len(songs))
+	}
+
+	err = songdb.AddSongAlias(song.SongId, "てすと")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	aliases, err := songdb.GetSongAliases(song.SongId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(aliases, []string{"てすと"}) {
+		t.Errorf("unexpected aliases %v", aliases)
+	}
+
+	matches, err := songdb.SearchSongs("ﾃｽﾄ", 5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(matches) != 1 || matches[0].SongInfo.SongId != song.SongId {
+		t.Errorf("expected to find song %d, got %+v", song.SongId, matches)
+	}
+
+	song.Charts[0].InternalLevel = 138
+	changes, err = songdb.UpsertSong(song)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(changes) != 1 || changes[0].Field != "internal_level" {
+		t.Errorf("expected the internal level to change, got %+v", changes)
+	}
+
+	level, err := songdb.GetInternalLevelAt(song.SongId, database.Master, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if level != 137 {
+		t.Errorf("expected internal level 137 before the change, got %d", level)
+	}
+
+	history, err := songdb.GetSongHistory(song.SongId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(history) != 2 {
+		t.Errorf("expected 2 changes, got %d", len(history))
+	}
+}
+
+func TestSqliteStores(t *testing.T) {
+	dir := t.TempDir()
+
+	playConn, err := database.Open(filepath.Join(dir, "plays.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer playConn.Close()
+
+	songConn, err := database.Open(filepath.Join(dir, "songs.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer songConn.Close()
+
+	playdb, err := database.NewPlayDB(playConn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer playdb.Close()
+
+	songdb, err := database.NewSongDB(songConn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer songdb.Close()
+
+	testStores(t, playdb, songdb)
+}
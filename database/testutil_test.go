@@ -13,38 +13,30 @@
 // You should have received a copy of the GNU Affero General Public License
 // along with this program.  If not, see <https://www.gnu.org/licenses/>.
 
-// script to import playlog data from json file to sqlite3 db
-package main
+package database_test
 
 import (
+	"testing"
 	"os"
-	"database/sql"
-	_ "github.com/mattn/go-sqlite3"
-
-	"github.com/yadayadajaychan/playlog/database"
-	"github.com/yadayadajaychan/playlog/internal/update/solips"
+	"path/filepath"
 )
 
-func main() {
-	db, err := sql.Open("sqlite3", os.Args[1])
-	if err != nil {
-		panic(err)
-	}
-	defer db.Close()
+// copyDB copies the database file src into a temporary directory and
+// returns the path of the copy, so opening it (and migrating it)
+// doesn't modify the files checked into the repository
+func copyDB(t *testing.T, src string) string {
+	t.Helper()
 
-	playdb, err := database.NewPlayDB(db)
+	data, err := os.ReadFile(src)
 	if err != nil {
-		panic(err)
+		t.Fatal(err)
 	}
 
-	file, err := os.Open(os.Args[2])
+	dst := filepath.Join(t.TempDir(), filepath.Base(src))
+	err = os.WriteFile(dst, data, 0644)
 	if err != nil {
-		panic(err)
+		t.Fatal(err)
 	}
-	defer file.Close()
 
-	err = solips.Import(playdb, file)
-	if err != nil {
-		panic(err)
-	}
+	return dst
 }
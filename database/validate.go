@@ -0,0 +1,181 @@
+// Copyright (C) 2025 Ethan Cheng <ethan@nijika.org>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+// Package database handles the playlog and song database
+package database
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strings"
+)
+
+// ValidationMode is what AddPlay does with plays whose score, combo or
+// dx score don't agree with their judgements. Plays whose judgements
+// don't add up are always rejected.
+type ValidationMode int
+const (
+	RejectInvalid ValidationMode = iota // return an *InvalidPlayError
+	WarnInvalid                         // log a warning and add the play
+	FlagInvalid                         // add the play with PlayInfo.ValidationErrors set
+)
+
+var validationModeNames = []string{"reject", "warn", "flag"}
+
+func (m ValidationMode) String() string {
+	if m >= 0 && int(m) < len(validationModeNames) {
+		return validationModeNames[m]
+	}
+	return fmt.Sprintf("ValidationMode(%d)", int(m))
+}
+
+// ParseValidationMode converts "reject", "warn" or "flag" to a ValidationMode
+func ParseValidationMode(s string) (ValidationMode, error) {
+	for i, name := range validationModeNames {
+		if s == name {
+			return ValidationMode(i), nil
+		}
+	}
+	return RejectInvalid, errors.New("invalid validation mode: " + s)
+}
+
+type InvalidPlayError struct {
+	UserPlayDate int64
+	Problems     []string
+}
+
+func (e *InvalidPlayError) Error() string {
+	return fmt.Sprintf("error validating PlayInfo %d: %s", e.UserPlayDate, strings.Join(e.Problems, "; "))
+}
+
+// base score of each note type, a break is worth 5 taps
+const (
+	tapWeight   = 500
+	holdWeight  = 1000
+	slideWeight = 1500
+	touchWeight = 500
+	breakWeight = 2500
+)
+
+// AchievementRange recomputes the achievement of a play with detailed
+// judgements, in the same unit as PlayInfo.Score. Perfect breaks and
+// great breaks come in several grades that aren't told apart,
+// so the lowest and highest possible achievements are returned.
+// ok is false if the play has no detailed judgements.
+func AchievementRange(play PlayInfo) (min, max int, ok bool) {
+	type judgements struct {
+		weight                                   float64
+		criticalPerfect, perfect, great, good, miss int
+	}
+	types := []judgements{
+		{tapWeight, play.TapCriticalPerfect, play.TapPerfect, play.TapGreat, play.TapGood, play.TapMiss},
+		{holdWeight, play.HoldCriticalPerfect, play.HoldPerfect, play.HoldGreat, play.HoldGood, play.HoldMiss},
+		{slideWeight, play.SlideCriticalPerfect, play.SlidePerfect, play.SlideGreat, play.SlideGood, play.SlideMiss},
+		{touchWeight, play.TouchCriticalPerfect, play.TouchPerfect, play.TouchGreat, play.TouchGood, play.TouchMiss},
+	}
+
+	var maxBase, base float64
+	for _, t := range types {
+		notes := t.criticalPerfect + t.perfect + t.great + t.good + t.miss
+		maxBase += t.weight * float64(notes)
+		base += t.weight * (float64(t.criticalPerfect+t.perfect) + 0.8*float64(t.great) + 0.5*float64(t.good))
+	}
+
+	breaks := play.BreakCriticalPerfect + play.BreakPerfect + play.BreakGreat + play.BreakGood + play.BreakMiss
+	maxBase += breakWeight * float64(breaks)
+	if maxBase == 0 {
+		return 0, 0, false
+	}
+
+	// great breaks are worth 80%, 60% or 50% and goods 40%
+	breakBase := breakWeight * (float64(play.BreakCriticalPerfect+play.BreakPerfect) + 0.4*float64(play.BreakGood))
+	minBase := base + breakBase + breakWeight*0.5*float64(play.BreakGreat)
+	maxAchievedBase := base + breakBase + breakWeight*0.8*float64(play.BreakGreat)
+
+	// the break bonus of 1% is split between all breaks: critical perfects
+	// get all of their share, perfects 75% or 50%, greats 40% and goods 30%
+	var minBonus, maxBonus float64
+	if breaks > 0 {
+		bonus := float64(play.BreakCriticalPerfect) + 0.4*float64(play.BreakGreat) + 0.3*float64(play.BreakGood)
+		minBonus = 10000 * (bonus + 0.5*float64(play.BreakPerfect)) / float64(breaks)
+		maxBonus = 10000 * (bonus + 0.75*float64(play.BreakPerfect)) / float64(breaks)
+	}
+
+	min = int(math.Floor(1000000*minBase/maxBase + minBonus))
+	max = int(math.Ceil(1000000*maxAchievedBase/maxBase + maxBonus))
+	return min, max, true
+}
+
+// expectedComboStatus returns the combo status a play with the given
+// judgements gets
+func expectedComboStatus(perfect, great, good, miss int) ComboStatus {
+	switch {
+	case miss > 0:
+		return NoCombo
+	case good > 0:
+		return FullCombo
+	case great > 0:
+		return FullComboPlus
+	case perfect > 0:
+		return AllPerfect
+	default:
+		return AllPerfectPlus
+	}
+}
+
+// checkPlay returns the ways in which the score, combo and dx score of play
+// don't agree with its judgements. Checks that need judgements are
+// skipped if the play doesn't have them.
+func checkPlay(play PlayInfo) []string {
+	var problems []string
+
+	if play.MaxCombo > play.TotalCombo && play.TotalCombo > 0 {
+		problems = append(problems, fmt.Sprintf("max combo %d is more than total combo %d",
+			play.MaxCombo, play.TotalCombo))
+	}
+
+	if play.DxScore > play.TotalCombo*3 && play.TotalCombo > 0 {
+		problems = append(problems, fmt.Sprintf("dx score %d is more than 3 times total combo %d",
+			play.DxScore, play.TotalCombo))
+	}
+
+	total := play.TotalCriticalPerfect + play.TotalPerfect + play.TotalGreat + play.TotalGood + play.TotalMiss
+	if total > 0 {
+		comboStatus := expectedComboStatus(play.TotalPerfect, play.TotalGreat, play.TotalGood, play.TotalMiss)
+		if play.ComboStatus != comboStatus {
+			problems = append(problems, fmt.Sprintf("combo status %d does not match judgements, expected %d",
+				play.ComboStatus, comboStatus))
+		}
+
+		if play.ComboStatus != NoCombo && play.MaxCombo != 0 && play.MaxCombo != total {
+			problems = append(problems, fmt.Sprintf("max combo %d of full combo is not the no. of notes %d",
+				play.MaxCombo, total))
+		}
+
+		dxScore := play.TotalCriticalPerfect*3 + play.TotalPerfect*2 + play.TotalGreat
+		if play.DxScore != dxScore {
+			problems = append(problems, fmt.Sprintf("dx score %d does not match judgements, expected %d",
+				play.DxScore, dxScore))
+		}
+	}
+
+	if min, max, ok := AchievementRange(play); ok && (play.Score < min-1 || play.Score > max+1) {
+		problems = append(problems, fmt.Sprintf("score %d does not match judgements, expected %d to %d",
+			play.Score, min, max))
+	}
+
+	return problems
+}
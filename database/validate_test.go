@@ -0,0 +1,163 @@
+// Copyright (C) 2025 Ethan Cheng <ethan@nijika.org>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package database_test
+
+import (
+	"testing"
+	"reflect"
+	"path/filepath"
+	"database/sql"
+	_ "github.com/mattn/go-sqlite3"
+
+	"github.com/yadayadajaychan/playlog/database"
+)
+
+// TestAchievementRange tests that the recomputed achievement
+// of every play in test-plays.db matches its score
+func TestAchievementRange(t *testing.T) {
+	db, err := sql.Open("sqlite3", copyDB(t, "../test/test-plays.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	playdb, err := database.NewPlayDB(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	plays, err := playdb.GetPlays(true, 200, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	checked := 0
+	for _, play := range plays {
+		min, max, ok := database.AchievementRange(play)
+		if !ok {
+			continue
+		}
+		checked++
+
+		if play.Score < min-1 || play.Score > max+1 {
+			t.Errorf("play %d: score %d not in %d to %d", play.UserPlayDate, play.Score, min, max)
+		}
+	}
+	if checked == 0 {
+		t.Error("no plays with detailed judgements in test-plays.db")
+	}
+
+	_, _, ok := database.AchievementRange(database.PlayInfo{TotalCriticalPerfect: 100})
+	if ok {
+		t.Error("expected no achievement for play without detailed judgements")
+	}
+}
+
+func TestValidationMode(t *testing.T) {
+	db, err := sql.Open("sqlite3", filepath.Join(t.TempDir(), "plays.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	playdb, err := database.NewPlayDB(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// an all perfect with the combo status of a full combo
+	play := database.PlayInfo{
+		UserPlayDate	: 1743108003,
+		SongId		: 11441,
+		Difficulty	: database.Master,
+		Score		: 1000000,
+		DxScore		: 3,
+		ComboStatus	: database.FullCombo,
+		MaxCombo	: 2,
+		TotalCombo	: 2,
+
+		TapCriticalPerfect	: 1,
+		TapPerfect		: 1,
+		TotalCriticalPerfect	: 1,
+		TotalPerfect		: 1,
+	}
+
+	result, err := playdb.AddPlayWithResult(play)
+	if result != database.PlayNotAdded {
+		t.Errorf("expected %s, got %s", database.PlayNotAdded, result)
+	}
+	if e, ok := err.(*database.InvalidPlayError); ok {
+		t.Log("correctly returned InvalidPlayError:", e)
+		if len(e.Problems) != 2 {
+			t.Error("expected 2 problems, got", e.Problems)
+		}
+	} else {
+		t.Fatal("expected InvalidPlayError, got:", err)
+	}
+
+	err = playdb.WithValidationMode(database.WarnInvalid).AddPlay(play)
+	if err != nil {
+		t.Fatal(err)
+	}
+	playg, err := playdb.GetPlay(play.UserPlayDate)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if playg.ValidationErrors != nil {
+		t.Error("play added with WarnInvalid has validation errors:", playg.ValidationErrors)
+	}
+
+	play.UserPlayDate++
+	err = playdb.WithValidationMode(database.FlagInvalid).AddPlay(play)
+	if err != nil {
+		t.Fatal(err)
+	}
+	playg, err = playdb.GetPlay(play.UserPlayDate)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(playg.ValidationErrors) != 2 {
+		t.Error("expected 2 validation errors, got", playg.ValidationErrors)
+	}
+
+	// fixing the play makes it pass
+	play.UserPlayDate++
+	play.ComboStatus = database.AllPerfect
+	play.DxScore = 5
+	err = playdb.AddPlay(play)
+	if err != nil {
+		t.Fatal(err)
+	}
+	playg, err = playdb.GetPlay(play.UserPlayDate)
+	if err != nil {
+		t.Fatal(err)
+	}
+	play.PlayId = playg.PlayId
+	if !reflect.DeepEqual(play, playg) {
+		t.Log(play)
+		t.Log(playg)
+		t.Error("play not equal to playg")
+	}
+
+	mode, err := database.ParseValidationMode("flag")
+	if err != nil || mode != database.FlagInvalid {
+		t.Error("ParseValidationMode(\"flag\"):", mode, err)
+	}
+	_, err = database.ParseValidationMode("ignore")
+	if err == nil {
+		t.Error("expected error for invalid validation mode")
+	}
+}
@@ -0,0 +1,119 @@
+// Copyright (C) 2025 Ethan Cheng <ethan@nijika.org>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+// Package activity counts plays per day, weekday and hour,
+// with days that can start at any quarter of an hour
+package activity
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/yadayadajaychan/playlog/database"
+)
+
+type Day struct {
+	Date  string // e.g. "2025-03-27"
+	Plays int
+}
+
+// Streak is a run of consecutive days with plays
+type Streak struct {
+	Days  int
+	Start string // date of its first day, "" if Days is 0
+	End   string // date of its last day
+}
+
+type Activity struct {
+	Days          []Day   // with plays, oldest first
+	Weekdays      [7]int  // plays per weekday of their day, Sunday first
+	Hours         [24]int // plays per hour on the clock, midnight first
+	CurrentStreak Streak  // ending today, or yesterday if there are no plays today yet
+	LongestStreak Streak  // the first of the longest, if there are more than one
+}
+
+// Compute returns the activity of the plays in counts. A play belongs to
+// the day in loc that starts at dayStart before it, e.g. with a dayStart
+// of 4 hours, plays at 1 am count towards the day before. Weekdays are
+// those of the days plays belong to, but hours are those on the clock.
+// The current streak is as of now.
+func Compute(counts []database.PlayCount, loc *time.Location, dayStart time.Duration, now time.Time) Activity {
+	a := Activity{Days: make([]Day, 0)}
+
+	for _, c := range counts {
+		t := time.Unix(c.Start, 0).In(loc)
+		day := gameDay(t, dayStart)
+
+		date := day.Format(time.DateOnly)
+		if n := len(a.Days); n > 0 && a.Days[n-1].Date == date {
+			a.Days[n-1].Plays += c.Plays
+		} else {
+			a.Days = append(a.Days, Day{Date: date, Plays: c.Plays})
+		}
+
+		a.Weekdays[day.Weekday()] += c.Plays
+		a.Hours[t.Hour()] += c.Plays
+	}
+
+	var streak Streak
+	var last time.Time
+	for _, d := range a.Days {
+		day, _ := time.Parse(time.DateOnly, d.Date)
+		if streak.Days > 0 && day.Equal(last.AddDate(0, 0, 1)) {
+			streak.Days++
+			streak.End = d.Date
+		} else {
+			streak = Streak{Days: 1, Start: d.Date, End: d.Date}
+		}
+		last = day
+
+		if streak.Days > a.LongestStreak.Days {
+			a.LongestStreak = streak
+		}
+	}
+
+	today := gameDay(now.In(loc), dayStart)
+	yesterday := today.AddDate(0, 0, -1)
+	if streak.Days > 0 && (last.Equal(today) || last.Equal(yesterday)) {
+		a.CurrentStreak = streak
+	}
+
+	return a
+}
+
+// gameDay returns midnight UTC of the date of the day t belongs to
+func gameDay(t time.Time, dayStart time.Duration) time.Time {
+	y, m, d := t.Date()
+	sinceMidnight := time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute
+	if sinceMidnight < dayStart {
+		d--
+	}
+	return time.Date(y, m, d, 0, 0, 0, 0, time.UTC)
+}
+
+// ParseDayStart parses the time of day days start at, e.g. "04:00".
+// It has to be a quarter of an hour, since plays are counted per quarter hour.
+func ParseDayStart(s string) (time.Duration, error) {
+	t, err := time.Parse("15:04", s)
+	if err != nil || t.Minute()%15 != 0 {
+		return 0, fmt.Errorf("invalid start of day: %s (e.g. 04:00, in quarters of an hour)", s)
+	}
+	return time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute, nil
+}
+
+// FormatDayStart is the inverse of ParseDayStart
+func FormatDayStart(d time.Duration) string {
+	return fmt.Sprintf("%02d:%02d", int(d/time.Hour), int(d%time.Hour/time.Minute))
+}
@@ -0,0 +1,110 @@
+// Copyright (C) 2025 Ethan Cheng <ethan@nijika.org>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package activity
+
+import (
+	"testing"
+	"time"
+
+	"github.com/yadayadajaychan/playlog/database"
+)
+
+func TestCompute(t *testing.T) {
+	jst := time.FixedZone("JST", 9*60*60)
+	at := func(s string) int64 {
+		tm, err := time.ParseInLocation("2006-01-02 15:04", s, jst)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return tm.Unix()
+	}
+
+	counts := []database.PlayCount{
+		{Start: at("2025-03-27 20:00"), Plays: 4}, // Thursday
+		{Start: at("2025-03-28 01:30"), Plays: 2}, // still Thursday from 04:00
+		{Start: at("2025-03-28 04:00"), Plays: 1}, // Friday
+		{Start: at("2025-03-29 19:00"), Plays: 3},
+		{Start: at("2025-04-02 12:00"), Plays: 5},
+		{Start: at("2025-04-03 23:45"), Plays: 1},
+	}
+
+	a := Compute(counts, jst, 4*time.Hour, time.Unix(at("2025-04-04 03:00"), 0))
+
+	expectedDays := []Day{
+		{"2025-03-27", 6},
+		{"2025-03-28", 1},
+		{"2025-03-29", 3},
+		{"2025-04-02", 5},
+		{"2025-04-03", 1},
+	}
+	if len(a.Days) != len(expectedDays) {
+		t.Fatalf("expected %v, got %v", expectedDays, a.Days)
+	}
+	for i := range expectedDays {
+		if a.Days[i] != expectedDays[i] {
+			t.Errorf("expected %v, got %v", expectedDays[i], a.Days[i])
+		}
+	}
+
+	if a.Weekdays[time.Thursday] != 7 || a.Weekdays[time.Friday] != 1 || a.Weekdays[time.Saturday] != 3 {
+		t.Errorf("unexpected plays per weekday %v", a.Weekdays)
+	}
+	if a.Hours[20] != 4 || a.Hours[1] != 2 || a.Hours[4] != 1 || a.Hours[23] != 1 {
+		t.Errorf("unexpected plays per hour %v", a.Hours)
+	}
+
+	longest := Streak{Days: 3, Start: "2025-03-27", End: "2025-03-29"}
+	if a.LongestStreak != longest {
+		t.Errorf("expected longest streak %+v, got %+v", longest, a.LongestStreak)
+	}
+
+	// at 03:00 it's still the 3rd, which has plays
+	current := Streak{Days: 2, Start: "2025-04-02", End: "2025-04-03"}
+	if a.CurrentStreak != current {
+		t.Errorf("expected current streak %+v, got %+v", current, a.CurrentStreak)
+	}
+
+	// without plays yesterday or today, there is no current streak
+	a = Compute(counts, jst, 4*time.Hour, time.Unix(at("2025-04-05 12:00"), 0))
+	if a.CurrentStreak.Days != 0 {
+		t.Errorf("expected no current streak, got %+v", a.CurrentStreak)
+	}
+
+	// with days from midnight, 01:30 is Friday
+	a = Compute(counts, jst, 0, time.Unix(at("2025-04-05 12:00"), 0))
+	if a.Days[0].Plays != 4 || a.Days[1].Plays != 3 {
+		t.Errorf("unexpected days from midnight %v", a.Days)
+	}
+}
+
+func TestParseDayStart(t *testing.T) {
+	for _, s := range []string{"00:00", "04:00", "05:45"} {
+		d, err := ParseDayStart(s)
+		if err != nil {
+			t.Errorf("%s: %v", s, err)
+		}
+		if FormatDayStart(d) != s {
+			t.Errorf("expected %s, got %s", s, FormatDayStart(d))
+		}
+	}
+
+	for _, s := range []string{"", "4", "04:10", "24:00", "-01:00"} {
+		_, err := ParseDayStart(s)
+		if err == nil {
+			t.Errorf("%q: expected an error", s)
+		}
+	}
+}
@@ -0,0 +1,86 @@
+// Copyright (C) 2025 Ethan Cheng <ethan@nijika.org>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+// Package app holds the settings and databases playlog runs with
+package app
+
+import (
+	"context"
+	"time"
+	"errors"
+	"github.com/yadayadajaychan/playlog/database"
+	"github.com/yadayadajaychan/playlog/internal/backup"
+)
+
+type DataSource int
+const (
+	Solips DataSource = iota
+	Kamai
+)
+
+// ParseDataSource converts "solips" or "kamai" to a DataSource
+func ParseDataSource(s string) (DataSource, error) {
+	switch (s) {
+	case "solips":
+		return Solips, nil
+	case "kamai":
+		return Kamai, nil
+	default:
+		return Solips, errors.New("invalid data source: " + s)
+	}
+}
+
+// Env is passed to the updaters and the backend. Its settings come from
+// config.Config.Env, and the databases are filled in by main.
+// The databases are shared, so use their WithContext for work that can be cancelled.
+type Env struct {
+	DataSource DataSource
+	AccessCode string // Mythos Access Code
+	KamaiUser string // kamaitachi username
+
+	Playdb database.PlayStore // scoped to the player being updated or served
+	Songdb database.SongStore
+
+	ValidationMode database.ValidationMode // of Playdb
+	PlaydbFilename string
+
+	BackupDir       string // where the play db is backed up to, "" for no backups
+	BackupInterval  time.Duration
+	BackupRetention backup.Retention
+
+	Verbose        int
+	ListenPort     int
+	AliasToken     string // bearer token to change aliases with the api, "" if they can't be
+
+	UpdateInterval time.Duration
+	ApiInterval    time.Duration
+
+	Timezone *time.Location // days of the activity are in
+	DayStart time.Duration  // after midnight, when days of the activity start
+}
+
+// Sleep waits for d, or returns ctx.Err() if ctx is done first,
+// e.g. between requests to upstream apis
+func Sleep(ctx context.Context, d time.Duration) error {
+	t := time.NewTimer(d)
+	defer t.Stop()
+
+	select {
+	case <-t.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,100 @@
+// Copyright (C) 2025 Ethan Cheng <ethan@nijika.org>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package backend
+
+import (
+	"log"
+	"fmt"
+	"time"
+	"encoding/json"
+	"net/http"
+	"github.com/yadayadajaychan/playlog/database"
+	"github.com/yadayadajaychan/playlog/internal/activity"
+)
+
+type activityStats struct {
+	activity.Activity
+	Timezone string
+	DayStart string
+}
+
+func activityHandler(w http.ResponseWriter, r *http.Request) {
+	defer func() {
+		if err := recover(); err != nil {
+			w.Header().Set("Content-Type", "text/plain")
+			w.WriteHeader(500)
+			fmt.Fprintln(w, err)
+			logRequest(r, 500)
+			log.Print(err)
+			return
+		}
+	}()
+
+	values := r.URL.Query()
+	env := getEnv()
+
+	loc := env.Timezone
+	if loc == nil {
+		loc = time.Local
+	}
+	if values.Has("tz") {
+		var err error
+		loc, err = time.LoadLocation(values.Get("tz"))
+		if err != nil {
+			writeJSONError(w, r, 400, "invalid time zone: "+values.Get("tz"))
+			return
+		}
+	}
+
+	dayStart := env.DayStart
+	if values.Has("day_start") {
+		var err error
+		dayStart, err = activity.ParseDayStart(values.Get("day_start"))
+		if err != nil {
+			writeJSONError(w, r, 400, err.Error())
+			return
+		}
+	}
+
+	playdb, err := playdbForRequest(r)
+	if e, ok := err.(*database.PlayerNotFoundError); ok {
+		writeJSONError(w, r, 404, e.Error())
+		return
+	} else if err != nil {
+		panic(err)
+	}
+
+	counts, err := playdb.GetPlayCounts()
+	if err != nil {
+		panic(err)
+	}
+
+	stats := activityStats{
+		Activity: activity.Compute(counts, loc, dayStart, time.Now()),
+		Timezone: loc.String(),
+		DayStart: activity.FormatDayStart(dayStart),
+	}
+
+	j, err := json.Marshal(stats)
+	if err != nil {
+		panic(err)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(200)
+	fmt.Fprintln(w, string(j))
+	logRequest(r, 200)
+}
@@ -17,44 +17,196 @@
 package backend
 
 import (
+	"context"
+	"errors"
 	"log"
 	"fmt"
+	"time"
 	"strings"
 	"strconv"
 	"math"
 	"encoding/json"
+	"crypto/subtle"
 	"net/http"
-	"github.com/yadayadajaychan/playlog/internal/context"
+	"github.com/yadayadajaychan/playlog/internal/app"
 	"github.com/yadayadajaychan/playlog/database"
+	"github.com/yadayadajaychan/playlog/internal/score"
 )
 
-var ctx context.PlaylogCtx
+// getEnv returns the env the handlers use, which changes
+// when the configuration is reloaded
+var getEnv func() app.Env
 
-func Entrypoint(c context.PlaylogCtx) {
-	ctx = c
+// ShutdownTimeout is how long Serve waits for requests in progress to finish
+// once it's told to stop, before closing their connections
+const ShutdownTimeout = 10 * time.Second
 
-	if ctx.Verbose >= 1 {
-		log.Printf("starting backend server on port %d", ctx.ListenPort)
+func Entrypoint(c app.Env) {
+	err := Serve(context.Background(), func() app.Env { return c })
+	log.Print(err)
+}
+
+// Serve serves the api on env.ListenPort until ctx is cancelled.
+// It then stops accepting connections and waits up to ShutdownTimeout
+// for requests in progress, which is an error if they don't finish in time.
+// Every request reads the env from get, so a reloaded configuration applies
+// to the requests after it, except for the listen port.
+func Serve(ctx context.Context, get func() app.Env) error {
+	getEnv = get
+	env := get()
+
+	if env.Verbose >= 1 {
+		log.Printf("starting backend server on port %d", env.ListenPort)
 	}
 
-	http.HandleFunc("/", rootHandler)
-	http.HandleFunc("/api/playlog", playlogHandler)
+	srv := &http.Server{
+		Addr:    fmt.Sprintf(":%d", env.ListenPort),
+		Handler: newMux(),
+	}
 
-	err := http.ListenAndServe(fmt.Sprintf(":%d", ctx.ListenPort), nil)
-	log.Print(err)
+	errc := make(chan error, 1)
+	go func() {
+		errc <- srv.ListenAndServe()
+	}()
+
+	select {
+	case err := <-errc:
+		return err
+	case <-ctx.Done():
+	}
+
+	if getEnv().Verbose >= 1 {
+		log.Print("stopping backend server")
+	}
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), ShutdownTimeout)
+	defer cancel()
+
+	err := srv.Shutdown(shutdownCtx)
+	if err != nil {
+		// cancels the requests that are left
+		srv.Close()
+	}
+
+	if e := <-errc; !errors.Is(e, http.ErrServerClosed) {
+		return e
+	}
+	return err
+}
+
+// newMux returns the handler of every endpoint
+func newMux() *http.ServeMux {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", rootHandler)
+	mux.HandleFunc("/api/playlog", playlogHandler)
+	mux.HandleFunc("/api/players", playersHandler)
+	mux.HandleFunc("/api/stats/notes", noteStatsHandler)
+	mux.HandleFunc("/api/songs", songsHandler)
+	mux.HandleFunc("/api/aliases", aliasesHandler)
+	mux.HandleFunc("/api/play/{date}", playHandler)
+	mux.HandleFunc("/api/sessions", sessionsHandler)
+	mux.HandleFunc("/api/sessions/{id}", sessionHandler)
+	mux.HandleFunc("/api/activity", activityHandler)
+	mux.HandleFunc("/api/rating/history", ratingHistoryHandler)
+	return mux
 }
 
 func logRequest(r *http.Request, statusCode int) {
-	if ctx.Verbose >= 1 {
+	if getEnv().Verbose >= 1 {
 		log.Printf(`%s "%s %s %s" %d "%s" "%s"`, r.RemoteAddr, r.Method, r.RequestURI, r.Proto, statusCode, r.Host, r.UserAgent())
 	}
 }
 
 func rootHandler(w http.ResponseWriter, r *http.Request) {
+	writeError(w, r, 404, "404 Not Found")
+}
+
+func writeError(w http.ResponseWriter, r *http.Request, statusCode int, msg string) {
 	w.Header().Set("Content-Type", "text/plain")
-	w.WriteHeader(404)
-	fmt.Fprintln(w, "404 Not Found")
-	logRequest(r, 404)
+	w.WriteHeader(statusCode)
+	fmt.Fprintln(w, msg)
+	logRequest(r, statusCode)
+}
+
+type apiError struct {
+	Error string
+}
+
+// writeJSONError is writeError for endpoints whose errors are json
+func writeJSONError(w http.ResponseWriter, r *http.Request, statusCode int, msg string) {
+	j, err := json.Marshal(apiError{Error: msg})
+	if err != nil {
+		panic(err)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(statusCode)
+	fmt.Fprintln(w, string(j))
+	logRequest(r, statusCode)
+}
+
+// playdbForRequest returns the PlayStore of the player named by the 'player'
+// query parameter, or of the default player if there is none.
+// Its queries are cancelled when the request is.
+func playdbForRequest(r *http.Request) (database.PlayStore, error) {
+	playdb := getEnv().Playdb.WithContext(r.Context())
+
+	name := r.URL.Query().Get("player")
+	if name == "" {
+		return playdb, nil
+	}
+
+	player, err := playdb.GetPlayerByName(name)
+	if err != nil {
+		return nil, err
+	}
+
+	return playdb.WithPlayer(player.PlayerId), nil
+}
+
+// songdbForRequest returns the SongStore,
+// whose queries are cancelled when the request is
+func songdbForRequest(r *http.Request) database.SongStore {
+	return getEnv().Songdb.WithContext(r.Context())
+}
+
+type player struct {
+	PlayerId int64
+	Name     string
+}
+
+func playersHandler(w http.ResponseWriter, r *http.Request) {
+	defer func() {
+		if err := recover(); err != nil {
+			w.Header().Set("Content-Type", "text/plain")
+			w.WriteHeader(500)
+			fmt.Fprintln(w, err)
+			logRequest(r, 500)
+			log.Print(err)
+			return
+		}
+	}()
+
+	players, err := getEnv().Playdb.WithContext(r.Context()).GetPlayers()
+	if err != nil {
+		panic(err)
+	}
+
+	// credentials are left out on purpose
+	pl := make([]player, 0, len(players))
+	for _, p := range players {
+		pl = append(pl, player{PlayerId: p.PlayerId, Name: p.Name})
+	}
+
+	j, err := json.Marshal(pl)
+	if err != nil {
+		panic(err)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(200)
+	fmt.Fprintln(w, string(j))
+	logRequest(r, 200)
 }
 
 type playlog struct {
@@ -66,6 +218,37 @@ type playlogEntry struct {
 	SongInfo database.SongInfo
 	PlayInfo database.PlayInfo
 	PreviousBestScore int
+	InternalLevel int // of the chart when it was played, multiplied by 10
+	Rating int        // the play is worth, based on InternalLevel
+	Derived score.Derived
+}
+
+// derivedPlay is a play with the values derived from it and its chart
+type derivedPlay struct {
+	PlayInfo database.PlayInfo
+	Derived  score.Derived
+}
+
+// derive returns play with the values derived from it, its chart and the
+// internal level the chart had when it was played. Plays of songs
+// that aren't in the song db get what can be derived without them.
+func derive(songdb database.SongStore, play database.PlayInfo) (derivedPlay, error) {
+	var chart database.ChartInfo
+	song, err := songdb.GetSong(play.SongId)
+	if err == nil {
+		chart, _ = song.Chart(play.Difficulty, play.Variant)
+	} else if _, ok := err.(*database.SongNotFoundError); !ok {
+		return derivedPlay{}, err
+	}
+
+	internalLevel, err := songdb.GetInternalLevelAt(play.SongId, play.Difficulty, play.UserPlayDate)
+	if _, ok := err.(*database.ChartNotFoundError); ok {
+		internalLevel = 0
+	} else if err != nil {
+		return derivedPlay{}, err
+	}
+
+	return derivedPlay{PlayInfo: play, Derived: score.Derive(play, chart, internalLevel)}, nil
 }
 
 func playlogHandler(w http.ResponseWriter, r *http.Request) {
@@ -80,6 +263,14 @@ func playlogHandler(w http.ResponseWriter, r *http.Request) {
 		}
 	}()
 
+	playdb, err := playdbForRequest(r)
+	if e, ok := err.(*database.PlayerNotFoundError); ok {
+		writeError(w, r, 404, e.Error())
+		return
+	} else if err != nil {
+		panic(err)
+	}
+
 	values := r.URL.Query()
 
 	var asc bool
@@ -103,16 +294,17 @@ func playlogHandler(w http.ResponseWriter, r *http.Request) {
 	}
 	offset := (page - 1) * count
 
-	numberOfEntries, err := ctx.Playdb.GetCount()
+	numberOfEntries, err := playdb.GetCount()
 	if err != nil {
 		panic(err)
 	}
 	maxPage := int(math.Ceil(float64(numberOfEntries) / float64(count)))
 
-	plays, err := ctx.Playdb.GetPlays(asc, count, offset)
+	plays, err := playdb.GetPlays(asc, count, offset)
 	if err != nil {
 		panic(err)
 	}
+	songdb := songdbForRequest(r)
 
 	pl := playlog{
 		MaxPage: maxPage,
@@ -120,20 +312,33 @@ func playlogHandler(w http.ResponseWriter, r *http.Request) {
 	}
 
 	for _, play := range plays {
-		song, err := ctx.Songdb.GetSong(play.SongId)
+		song, err := songdb.GetSong(play.SongId)
 		if err != nil {
 			panic(err)
 		}
 
-		previousBestScore, err := ctx.Playdb.GetBestScoreBeforeDate(play.SongId, play.Difficulty, play.UserPlayDate)
+		previousBestScore, err := playdb.GetBestScoreOfVariantBeforeDate(play.SongId, play.Difficulty, play.Variant, play.UserPlayDate)
 		if err != nil {
 			panic(err)
 		}
 
+		internalLevel, err := songdb.GetInternalLevelAt(play.SongId, play.Difficulty, play.UserPlayDate)
+		if _, ok := err.(*database.ChartNotFoundError); ok {
+			internalLevel = 0
+		} else if err != nil {
+			panic(err)
+		}
+
+		chart, _ := song.Chart(play.Difficulty, play.Variant)
+		derived := score.Derive(play, chart, internalLevel)
+
 		entry := playlogEntry{
 			SongInfo: song,
 			PlayInfo: play,
 			PreviousBestScore: previousBestScore,
+			InternalLevel: internalLevel,
+			Rating: derived.Rating,
+			Derived: derived,
 		}
 
 		pl.Playlog = append(pl.Playlog, entry)
@@ -150,3 +355,222 @@ func playlogHandler(w http.ResponseWriter, r *http.Request) {
 	logRequest(r, 200)
 	return
 }
+
+type noteStats struct {
+	database.NoteTypeStats
+	Total    int
+	Accuracy float64 // between 0 and 1
+}
+
+func noteStatsHandler(w http.ResponseWriter, r *http.Request) {
+	defer func() {
+		if err := recover(); err != nil {
+			w.Header().Set("Content-Type", "text/plain")
+			w.WriteHeader(500)
+			fmt.Fprintln(w, err)
+			logRequest(r, 500)
+			log.Print(err)
+			return
+		}
+	}()
+
+	playdb, err := playdbForRequest(r)
+	if e, ok := err.(*database.PlayerNotFoundError); ok {
+		writeError(w, r, 404, e.Error())
+		return
+	} else if err != nil {
+		panic(err)
+	}
+
+	stats, err := playdb.GetNoteTypeStats()
+	if err != nil {
+		panic(err)
+	}
+
+	ns := make([]noteStats, 0, len(stats))
+	for _, s := range stats {
+		ns = append(ns, noteStats{NoteTypeStats: s, Total: s.Total(), Accuracy: s.Accuracy()})
+	}
+
+	j, err := json.Marshal(ns)
+	if err != nil {
+		panic(err)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(200)
+	fmt.Fprintln(w, string(j))
+	logRequest(r, 200)
+}
+
+func songsHandler(w http.ResponseWriter, r *http.Request) {
+	defer func() {
+		if err := recover(); err != nil {
+			w.Header().Set("Content-Type", "text/plain")
+			w.WriteHeader(500)
+			fmt.Fprintln(w, err)
+			logRequest(r, 500)
+			log.Print(err)
+			return
+		}
+	}()
+
+	values := r.URL.Query()
+
+	query := values.Get("q")
+	if strings.TrimSpace(query) == "" {
+		writeError(w, r, 400, "missing query parameter 'q'")
+		return
+	}
+
+	limit, err := strconv.Atoi(values.Get("limit"))
+	if err != nil || limit < 1 {
+		limit = 20
+	}
+
+	matches, err := songdbForRequest(r).SearchSongs(query, limit)
+	if err != nil {
+		panic(err)
+	}
+
+	j, err := json.Marshal(matches)
+	if err != nil {
+		panic(err)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(200)
+	fmt.Fprintln(w, string(j))
+	logRequest(r, 200)
+}
+
+// aliasesHandler lists the aliases of songs on GET, adds one on POST,
+// and removes one on DELETE. Aliases decide which song imported plays
+// belong to, so changing them requires the AliasToken of the env.
+func aliasesHandler(w http.ResponseWriter, r *http.Request) {
+	defer func() {
+		if err := recover(); err != nil {
+			w.Header().Set("Content-Type", "text/plain")
+			w.WriteHeader(500)
+			fmt.Fprintln(w, err)
+			logRequest(r, 500)
+			log.Print(err)
+			return
+		}
+	}()
+
+	songdb := songdbForRequest(r)
+	values := r.URL.Query()
+
+	switch r.Method {
+	case http.MethodGet:
+		var aliases []database.SongAlias
+		if values.Has("song") {
+			songId, err := strconv.Atoi(values.Get("song"))
+			if err != nil {
+				writeError(w, r, 400, "invalid song id")
+				return
+			}
+			names, err := songdb.GetSongAliases(songId)
+			if err != nil {
+				panic(err)
+			}
+			aliases = make([]database.SongAlias, 0, len(names))
+			for _, name := range names {
+				aliases = append(aliases, database.SongAlias{SongId: songId, Alias: name})
+			}
+		} else {
+			var err error
+			aliases, err = songdb.GetAllSongAliases()
+			if err != nil {
+				panic(err)
+			}
+		}
+
+		j, err := json.Marshal(aliases)
+		if err != nil {
+			panic(err)
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(200)
+		fmt.Fprintln(w, string(j))
+		logRequest(r, 200)
+
+	case http.MethodPost:
+		if !authorizeAliasChange(w, r) {
+			return
+		}
+
+		var alias database.SongAlias
+		err := json.NewDecoder(r.Body).Decode(&alias)
+		if err != nil {
+			writeError(w, r, 400, "invalid alias: "+err.Error())
+			return
+		}
+
+		err = songdb.AddSongAlias(alias.SongId, alias.Alias)
+		if e, ok := err.(*database.SongNotFoundError); ok {
+			writeError(w, r, 404, e.Error())
+			return
+		} else if e, ok := err.(*database.EmptyAliasError); ok {
+			writeError(w, r, 400, e.Error())
+			return
+		} else if err != nil {
+			panic(err)
+		}
+
+		j, err := json.Marshal(alias)
+		if err != nil {
+			panic(err)
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(201)
+		fmt.Fprintln(w, string(j))
+		logRequest(r, 201)
+
+	case http.MethodDelete:
+		if !authorizeAliasChange(w, r) {
+			return
+		}
+
+		songId, err := strconv.Atoi(values.Get("song"))
+		if err != nil || !values.Has("alias") {
+			writeError(w, r, 400, "missing query parameter 'song' or 'alias'")
+			return
+		}
+
+		err = songdb.RemoveSongAlias(songId, values.Get("alias"))
+		if err != nil {
+			panic(err)
+		}
+
+		w.WriteHeader(204)
+		logRequest(r, 204)
+
+	default:
+		w.Header().Set("Allow", "GET, POST, DELETE")
+		writeError(w, r, 405, "405 Method Not Allowed")
+	}
+}
+
+// authorizeAliasChange checks that r has the AliasToken of the env as its
+// bearer token, and writes a 403 if aliases can't be changed with the api
+// or a 401 if the token is missing or wrong
+func authorizeAliasChange(w http.ResponseWriter, r *http.Request) bool {
+	aliasToken := getEnv().AliasToken
+	if aliasToken == "" {
+		writeError(w, r, 403, "aliases can only be changed with the CLI, unless listen.alias_token is set")
+		return false
+	}
+
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !ok || subtle.ConstantTimeCompare([]byte(token), []byte(aliasToken)) != 1 {
+		w.Header().Set("WWW-Authenticate", "Bearer")
+		writeError(w, r, 401, "401 Unauthorized")
+		return false
+	}
+
+	return true
+}
@@ -0,0 +1,148 @@
+// Copyright (C) 2025 Ethan Cheng <ethan@nijika.org>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+
+package backend
+
+import (
+	"testing"
+	"strings"
+	"path/filepath"
+	"net/http/httptest"
+
+	"database/sql"
+	_ "github.com/mattn/go-sqlite3"
+
+	"github.com/yadayadajaychan/playlog/database"
+	"github.com/yadayadajaychan/playlog/internal/app"
+)
+
+// setupEnv points the backend at empty play and song dbs
+// with song 11441 in them, and returns them
+func setupEnv(t *testing.T) (*database.PlayDB, *database.SongDB) {
+	t.Helper()
+	dir := t.TempDir()
+
+	db, err := sql.Open("sqlite3", filepath.Join(dir, "plays.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	db2, err := sql.Open("sqlite3", filepath.Join(dir, "songs.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db2.Close() })
+
+	playdb, err := database.NewPlayDB(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { playdb.Close() })
+
+	songdb, err := database.NewSongDB(db2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { songdb.Close() })
+
+	err = songdb.AddSong(database.SongInfo{
+		SongId: 11441,
+		Name:   "終焉逃避行",
+		Type:   "dx",
+		Charts: []database.ChartInfo{{Difficulty: database.Master, Level: 13, InternalLevel: 137, MaxNotes: 783}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	env := app.Env{Playdb: playdb, Songdb: songdb}
+	getEnv = func() app.Env { return env }
+	return playdb, songdb
+}
+
+// request sends a request to the backend and returns the response
+func request(t *testing.T, method, target, token, body string) *httptest.ResponseRecorder {
+	t.Helper()
+
+	r := httptest.NewRequest(method, target, strings.NewReader(body))
+	if token != "" {
+		r.Header.Set("Authorization", "Bearer "+token)
+	}
+	w := httptest.NewRecorder()
+	newMux().ServeHTTP(w, r)
+	return w
+}
+
+func TestChangeAliases(t *testing.T) {
+	_, songdb := setupEnv(t)
+	env := getEnv()
+	getEnv = func() app.Env { return env }
+
+	// without a token, aliases are only changed with the CLI
+	w := request(t, "POST", "/api/aliases", "", `{"SongId": 11441, "Alias": "shuuen"}`)
+	if w.Code != 403 {
+		t.Errorf("expected 403 without alias_token, got %d", w.Code)
+	}
+
+	// read by every request, like after reloading the configuration
+	env.AliasToken = "secret"
+	w = request(t, "POST", "/api/aliases", "wrong", `{"SongId": 11441, "Alias": "shuuen"}`)
+	if w.Code != 401 {
+		t.Errorf("expected 401 for a wrong token, got %d", w.Code)
+	}
+	w = request(t, "POST", "/api/aliases", "secret", `{"SongId": 11441, "Alias": ""}`)
+	if w.Code != 400 {
+		t.Errorf("expected 400 for an empty alias, got %d", w.Code)
+	}
+	w = request(t, "POST", "/api/aliases", "secret", `{"SongId": 999999, "Alias": "nothing"}`)
+	if w.Code != 404 {
+		t.Errorf("expected 404 for an unknown song, got %d", w.Code)
+	}
+
+	w = request(t, "POST", "/api/aliases", "secret", `{"SongId": 11441, "Alias": "shuuen"}`)
+	if w.Code != 201 {
+		t.Fatalf("expected 201, got %d: %s", w.Code, w.Body)
+	}
+	aliases, err := songdb.GetSongAliases(11441)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(aliases) != 1 || aliases[0] != "shuuen" {
+		t.Errorf("expected the alias to be added, got %v", aliases)
+	}
+
+	w = request(t, "GET", "/api/aliases?song=11441", "", "")
+	if w.Code != 200 || !strings.Contains(w.Body.String(), `"shuuen"`) {
+		t.Errorf("expected the alias to be listed, got %d: %s", w.Code, w.Body)
+	}
+
+	w = request(t, "DELETE", "/api/aliases?song=11441&alias=shuuen", "", "")
+	if w.Code != 401 {
+		t.Errorf("expected 401 without a token, got %d", w.Code)
+	}
+	w = request(t, "DELETE", "/api/aliases?song=11441&alias=shuuen", "secret", "")
+	if w.Code != 204 {
+		t.Fatalf("expected 204, got %d: %s", w.Code, w.Body)
+	}
+	aliases, err = songdb.GetSongAliases(11441)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(aliases) != 0 {
+		t.Errorf("expected the alias to be removed, got %v", aliases)
+	}
+}
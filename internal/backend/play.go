@@ -0,0 +1,190 @@
+// Copyright (C) 2025 Ethan Cheng <ethan@nijika.org>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package backend
+
+import (
+	"log"
+	"fmt"
+	"strconv"
+	"encoding/json"
+	"net/http"
+	"github.com/yadayadajaychan/playlog/database"
+	"github.com/yadayadajaychan/playlog/internal/score"
+)
+
+type playDetail struct {
+	SongInfo  database.SongInfo
+	ChartInfo database.ChartInfo
+	PlayInfo  database.PlayInfo
+	InternalLevel int // of the chart when it was played, multiplied by 10
+	Rating        int // the play is worth, based on InternalLevel
+	Derived       score.Derived
+
+	PreviousAttempts []derivedPlay // on the same chart, oldest first
+	Delta            *playDelta    // nil if there are no previous attempts
+
+	PreviousPlay *derivedPlay // on any chart, nil if there is none
+	NextPlay     *derivedPlay
+}
+
+// playDelta compares a play with the best of the previous attempts.
+// Each best is on its own, e.g. the best DX score
+// needn't be from the attempt with the best score.
+type playDelta struct {
+	PreviousBestScore       int
+	Score                   int // difference to PreviousBestScore
+	PreviousBestDxScore     int
+	DxScore                 int // difference to PreviousBestDxScore
+	PreviousBestComboStatus database.ComboStatus
+	PreviousBestSyncStatus  database.SyncStatus
+	PreviouslyCleared       bool
+	NewComboStatus          bool // better than PreviousBestComboStatus
+	NewSyncStatus           bool // better than PreviousBestSyncStatus
+	FirstClear              bool
+}
+
+func newPlayDelta(play database.PlayInfo, attempts []database.PlayInfo) *playDelta {
+	if len(attempts) == 0 {
+		return nil
+	}
+
+	d := &playDelta{}
+	for _, a := range attempts {
+		d.PreviousBestScore = max(d.PreviousBestScore, a.Score)
+		d.PreviousBestDxScore = max(d.PreviousBestDxScore, a.DxScore)
+		d.PreviousBestComboStatus = max(d.PreviousBestComboStatus, a.ComboStatus)
+		d.PreviousBestSyncStatus = max(d.PreviousBestSyncStatus, a.SyncStatus)
+		d.PreviouslyCleared = d.PreviouslyCleared || a.IsClear
+	}
+
+	d.Score = play.Score - d.PreviousBestScore
+	d.DxScore = play.DxScore - d.PreviousBestDxScore
+	d.NewComboStatus = play.ComboStatus > d.PreviousBestComboStatus
+	d.NewSyncStatus = play.SyncStatus > d.PreviousBestSyncStatus
+	d.FirstClear = play.IsClear && !d.PreviouslyCleared
+	return d
+}
+
+// playHandler returns a play with its chart, the attempts on the chart
+// before it, and the plays before and after it
+func playHandler(w http.ResponseWriter, r *http.Request) {
+	defer func() {
+		if err := recover(); err != nil {
+			w.Header().Set("Content-Type", "text/plain")
+			w.WriteHeader(500)
+			fmt.Fprintln(w, err)
+			logRequest(r, 500)
+			log.Print(err)
+			return
+		}
+	}()
+
+	date, err := strconv.ParseInt(r.PathValue("date"), 10, 64)
+	if err != nil {
+		writeJSONError(w, r, 400, "invalid date: "+r.PathValue("date"))
+		return
+	}
+
+	playdb, err := playdbForRequest(r)
+	if e, ok := err.(*database.PlayerNotFoundError); ok {
+		writeJSONError(w, r, 404, e.Error())
+		return
+	} else if err != nil {
+		panic(err)
+	}
+
+	play, err := playdb.GetPlay(date)
+	if e, ok := err.(*database.PlayNotFoundError); ok {
+		writeJSONError(w, r, 404, e.Error())
+		return
+	} else if err != nil {
+		panic(err)
+	}
+
+	songdb := songdbForRequest(r)
+	var chart database.ChartInfo
+	song, err := songdb.GetSong(play.SongId)
+	if err == nil {
+		chart, _ = song.Chart(play.Difficulty, play.Variant)
+	} else if _, ok := err.(*database.SongNotFoundError); !ok {
+		panic(err)
+	}
+
+	internalLevel, err := songdb.GetInternalLevelAt(play.SongId, play.Difficulty, play.UserPlayDate)
+	if _, ok := err.(*database.ChartNotFoundError); ok {
+		internalLevel = 0
+	} else if err != nil {
+		panic(err)
+	}
+
+	attempts, err := playdb.GetPlaysOfVariantBeforeDate(play.SongId, play.Difficulty, play.Variant, play.UserPlayDate)
+	if err != nil {
+		panic(err)
+	}
+
+	derived := score.Derive(play, chart, internalLevel)
+
+	detail := playDetail{
+		SongInfo:  song,
+		ChartInfo: chart,
+		PlayInfo:  play,
+		InternalLevel: internalLevel,
+		Rating:        derived.Rating,
+		Derived:       derived,
+		PreviousAttempts: make([]derivedPlay, 0, len(attempts)),
+		Delta:            newPlayDelta(play, attempts),
+	}
+
+	for _, a := range attempts {
+		d, err := derive(songdb, a)
+		if err != nil {
+			panic(err)
+		}
+		detail.PreviousAttempts = append(detail.PreviousAttempts, d)
+	}
+
+	prev, err := playdb.GetPlayBefore(play.UserPlayDate)
+	if err == nil {
+		d, err := derive(songdb, prev)
+		if err != nil {
+			panic(err)
+		}
+		detail.PreviousPlay = &d
+	} else if _, ok := err.(*database.PlayNotFoundError); !ok {
+		panic(err)
+	}
+
+	next, err := playdb.GetPlayAfter(play.UserPlayDate)
+	if err == nil {
+		d, err := derive(songdb, next)
+		if err != nil {
+			panic(err)
+		}
+		detail.NextPlay = &d
+	} else if _, ok := err.(*database.PlayNotFoundError); !ok {
+		panic(err)
+	}
+
+	j, err := json.Marshal(detail)
+	if err != nil {
+		panic(err)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(200)
+	fmt.Fprintln(w, string(j))
+	logRequest(r, 200)
+}
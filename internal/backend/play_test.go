@@ -0,0 +1,88 @@
+// Copyright (C) 2025 Ethan Cheng <ethan@nijika.org>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+
+package backend
+
+import (
+	"testing"
+	"encoding/json"
+
+	"github.com/yadayadajaychan/playlog/database"
+)
+
+func TestPlayHandler(t *testing.T) {
+	playdb, _ := setupEnv(t)
+	flagging := playdb.WithValidationMode(database.FlagInvalid)
+
+	plays := []database.PlayInfo{
+		{UserPlayDate: 1743108003, SongId: 11441, Difficulty: database.Master, Score: 1005000},
+		{UserPlayDate: 1743108219, SongId: 999999, Difficulty: database.Master, Score: 1000000},
+	}
+	for _, play := range plays {
+		err := flagging.AddPlay(play)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	w := request(t, "GET", "/api/play/1743108003", "", "")
+	if w.Code != 200 {
+		t.Fatalf("expected 200, got %d: %s", w.Code, w.Body)
+	}
+	var detail playDetail
+	err := json.Unmarshal(w.Body.Bytes(), &detail)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if detail.SongInfo.SongId != 11441 || detail.ChartInfo.MaxNotes != 783 || detail.InternalLevel != 137 {
+		t.Errorf("unexpected song or chart of play: %+v", detail)
+	}
+	if detail.NextPlay == nil || detail.NextPlay.PlayInfo.UserPlayDate != 1743108219 {
+		t.Errorf("expected the next play to be 1743108219, got %+v", detail.NextPlay)
+	}
+
+	// a song not in the song db doesn't fail the request
+	w = request(t, "GET", "/api/play/1743108219", "", "")
+	if w.Code != 200 {
+		t.Fatalf("expected 200 for a play of an unknown song, got %d: %s", w.Code, w.Body)
+	}
+	detail = playDetail{}
+	err = json.Unmarshal(w.Body.Bytes(), &detail)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if detail.SongInfo.SongId != 0 || detail.PlayInfo.SongId != 999999 {
+		t.Errorf("expected an empty song for play of an unknown song, got %+v", detail)
+	}
+
+	w = request(t, "GET", "/api/play/1", "", "")
+	if w.Code != 404 || w.Header().Get("Content-Type") != "application/json" {
+		t.Fatalf("expected a 404 JSON error, got %d %q", w.Code, w.Header().Get("Content-Type"))
+	}
+	var e apiError
+	err = json.Unmarshal(w.Body.Bytes(), &e)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if e.Error == "" {
+		t.Error("expected an error message in the 404 response")
+	}
+
+	w = request(t, "GET", "/api/play/yesterday", "", "")
+	if w.Code != 400 {
+		t.Errorf("expected 400 for an invalid date, got %d", w.Code)
+	}
+}
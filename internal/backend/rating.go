@@ -0,0 +1,106 @@
+// Copyright (C) 2025 Ethan Cheng <ethan@nijika.org>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package backend
+
+import (
+	"log"
+	"fmt"
+	"encoding/json"
+	"net/http"
+	"github.com/yadayadajaychan/playlog/database"
+	"github.com/yadayadajaychan/playlog/internal/rating"
+	"github.com/yadayadajaychan/playlog/internal/score"
+)
+
+type ratingHistory struct {
+	Points     []rating.Point
+	Milestones []rating.Milestone
+	Current    int
+	Peak       int
+	Tier       string
+}
+
+func ratingHistoryHandler(w http.ResponseWriter, r *http.Request) {
+	defer func() {
+		if err := recover(); err != nil {
+			w.Header().Set("Content-Type", "text/plain")
+			w.WriteHeader(500)
+			fmt.Fprintln(w, err)
+			logRequest(r, 500)
+			log.Print(err)
+			return
+		}
+	}()
+
+	playdb, err := playdbForRequest(r)
+	if e, ok := err.(*database.PlayerNotFoundError); ok {
+		writeJSONError(w, r, 404, e.Error())
+		return
+	} else if err != nil {
+		panic(err)
+	}
+	songdb := songdbForRequest(r)
+
+	count, err := playdb.GetCount()
+	if err != nil {
+		panic(err)
+	}
+	plays, err := playdb.GetPlays(true, count, 0)
+	if err != nil {
+		panic(err)
+	}
+
+	songs, err := songdb.GetSongs()
+	if err != nil {
+		panic(err)
+	}
+
+	points, err := rating.History(plays, songs, func(play database.PlayInfo) (int, error) {
+		internalLevel, err := songdb.GetInternalLevelAt(play.SongId, play.Difficulty, play.UserPlayDate)
+		if _, ok := err.(*database.ChartNotFoundError); ok {
+			return 0, nil
+		} else if err != nil {
+			return 0, err
+		}
+		return score.Rating(play.Score, internalLevel), nil
+	})
+	if err != nil {
+		panic(err)
+	}
+
+	history := ratingHistory{
+		Points:     points,
+		Milestones: rating.Milestones(points),
+		Tier:       rating.Tier(0),
+	}
+	for _, p := range points {
+		history.Peak = max(history.Peak, p.Rating)
+	}
+	if len(points) > 0 {
+		history.Current = points[len(points)-1].Rating
+		history.Tier = rating.Tier(history.Current)
+	}
+
+	j, err := json.Marshal(history)
+	if err != nil {
+		panic(err)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(200)
+	fmt.Fprintln(w, string(j))
+	logRequest(r, 200)
+}
@@ -0,0 +1,198 @@
+// Copyright (C) 2025 Ethan Cheng <ethan@nijika.org>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package backend
+
+import (
+	"log"
+	"fmt"
+	"time"
+	"math"
+	"strconv"
+	"encoding/json"
+	"net/http"
+	"github.com/yadayadajaychan/playlog/database"
+	"github.com/yadayadajaychan/playlog/internal/score"
+)
+
+type sessions struct {
+	MaxPage  int
+	Sessions []sessionEntry
+}
+
+type sessionEntry struct {
+	database.Session
+	Duration     int // seconds from the first play to the last
+	RatingChange int // 0 if the rating before or after is unknown
+}
+
+func newSessionEntry(s database.Session) sessionEntry {
+	return sessionEntry{
+		Session:      s,
+		Duration:     int(s.Duration() / time.Second),
+		RatingChange: s.RatingChange(),
+	}
+}
+
+type sessionDetail struct {
+	Session sessionEntry
+	Plays   []sessionPlay
+}
+
+type sessionPlay struct {
+	SongInfo  database.SongInfo
+	PlayInfo  database.PlayInfo
+	Derived   score.Derived
+	Credit    int  // of the session, starting at 1
+	NewRecord bool
+}
+
+// sessionGap returns the gap between sessions in the 'gap' query parameter,
+// in minutes, or 0 for the default
+func sessionGap(r *http.Request) time.Duration {
+	gap, err := strconv.Atoi(r.URL.Query().Get("gap"))
+	if err != nil || gap < 1 {
+		return 0
+	}
+	return time.Duration(gap) * time.Minute
+}
+
+func sessionsHandler(w http.ResponseWriter, r *http.Request) {
+	defer func() {
+		if err := recover(); err != nil {
+			w.Header().Set("Content-Type", "text/plain")
+			w.WriteHeader(500)
+			fmt.Fprintln(w, err)
+			logRequest(r, 500)
+			log.Print(err)
+			return
+		}
+	}()
+
+	playdb, err := playdbForRequest(r)
+	if e, ok := err.(*database.PlayerNotFoundError); ok {
+		writeJSONError(w, r, 404, e.Error())
+		return
+	} else if err != nil {
+		panic(err)
+	}
+
+	values := r.URL.Query()
+	page, err := strconv.Atoi(values.Get("page"))
+	if err != nil || page < 1 {
+		page = 1
+	}
+	count, err := strconv.Atoi(values.Get("count"))
+	if err != nil || count < 1 {
+		count = 20
+	}
+	offset := (page - 1) * count
+
+	all, err := playdb.GetSessions(sessionGap(r))
+	if err != nil {
+		panic(err)
+	}
+
+	s := sessions{
+		MaxPage:  int(math.Ceil(float64(len(all)) / float64(count))),
+		Sessions: make([]sessionEntry, 0, count),
+	}
+	for i := offset; i < len(all) && i < offset+count; i++ {
+		s.Sessions = append(s.Sessions, newSessionEntry(all[i]))
+	}
+
+	j, err := json.Marshal(s)
+	if err != nil {
+		panic(err)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(200)
+	fmt.Fprintln(w, string(j))
+	logRequest(r, 200)
+}
+
+func sessionHandler(w http.ResponseWriter, r *http.Request) {
+	defer func() {
+		if err := recover(); err != nil {
+			w.Header().Set("Content-Type", "text/plain")
+			w.WriteHeader(500)
+			fmt.Fprintln(w, err)
+			logRequest(r, 500)
+			log.Print(err)
+			return
+		}
+	}()
+
+	sessionId, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil {
+		writeJSONError(w, r, 400, "invalid session id: "+r.PathValue("id"))
+		return
+	}
+
+	playdb, err := playdbForRequest(r)
+	if e, ok := err.(*database.PlayerNotFoundError); ok {
+		writeJSONError(w, r, 404, e.Error())
+		return
+	} else if err != nil {
+		panic(err)
+	}
+
+	session, plays, err := playdb.GetSession(sessionId, sessionGap(r))
+	if e, ok := err.(*database.SessionNotFoundError); ok {
+		writeJSONError(w, r, 404, e.Error())
+		return
+	} else if err != nil {
+		panic(err)
+	}
+
+	songdb := songdbForRequest(r)
+	detail := sessionDetail{
+		Session: newSessionEntry(session),
+		Plays:   make([]sessionPlay, 0, len(plays)),
+	}
+
+	for _, p := range plays {
+		song, err := songdb.GetSong(p.PlayInfo.SongId)
+		if _, ok := err.(*database.SongNotFoundError); ok {
+			song = database.SongInfo{SongId: p.PlayInfo.SongId}
+		} else if err != nil {
+			panic(err)
+		}
+
+		d, err := derive(songdb, p.PlayInfo)
+		if err != nil {
+			panic(err)
+		}
+
+		detail.Plays = append(detail.Plays, sessionPlay{
+			SongInfo:  song,
+			PlayInfo:  p.PlayInfo,
+			Derived:   d.Derived,
+			Credit:    p.Credit,
+			NewRecord: p.NewRecord,
+		})
+	}
+
+	j, err := json.Marshal(detail)
+	if err != nil {
+		panic(err)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(200)
+	fmt.Fprintln(w, string(j))
+	logRequest(r, 200)
+}
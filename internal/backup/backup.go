@@ -0,0 +1,274 @@
+// Copyright (C) 2025 Ethan Cheng <ethan@nijika.org>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+// Package backup makes online backups of sqlite databases
+// and manages how many are kept
+package backup
+
+import (
+	"os"
+	"fmt"
+	"sort"
+	"time"
+	"errors"
+	"strconv"
+	"strings"
+	"path/filepath"
+	gocontext "context"
+	"database/sql"
+	"github.com/mattn/go-sqlite3"
+)
+
+// Retention is how many backups Prune keeps: the newest backup of each
+// of the last Daily days and of each of the last Weekly weeks.
+// The newest backup is always kept.
+type Retention struct {
+	Daily  int
+	Weekly int
+}
+
+// Backup copies db into dir as <name>.<unix time>.bak while db is in use,
+// verifies the copy and returns its filename.
+// name is usually the base name of the database file, e.g. "plays.db".
+func Backup(db *sql.DB, dir, name string, now time.Time) (string, error) {
+	err := os.MkdirAll(dir, 0755)
+	if err != nil {
+		return "", err
+	}
+
+	file := filepath.Join(dir, fmt.Sprintf("%s.%d.bak", name, now.Unix()))
+	if _, err := os.Stat(file); err == nil {
+		return "", errors.New("backup already exists: " + file)
+	}
+
+	dest, err := sql.Open("sqlite3", file)
+	if err != nil {
+		return "", err
+	}
+	defer dest.Close()
+
+	err = copyDB(dest, db)
+	if err == nil {
+		// the copy is in WAL mode if db is, leave it as a single file
+		_, err = dest.Exec(`PRAGMA journal_mode = DELETE`)
+	}
+	if err != nil {
+		os.Remove(file)
+		return "", err
+	}
+
+	err = Verify(file)
+	if err != nil {
+		return "", fmt.Errorf("verifying backup %s: %w", file, err)
+	}
+
+	return file, nil
+}
+
+// Restore replaces the contents of db with the backup in file,
+// after verifying the backup
+func Restore(db *sql.DB, file string) error {
+	err := Verify(file)
+	if err != nil {
+		return fmt.Errorf("verifying backup %s: %w", file, err)
+	}
+
+	src, err := sql.Open("sqlite3", "file:"+file+"?mode=ro")
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	return copyDB(db, src)
+}
+
+// copyDB copies the main database of src into dest
+// using the sqlite online backup api
+func copyDB(dest, src *sql.DB) error {
+	ctx := gocontext.Background()
+
+	destConn, err := dest.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer destConn.Close()
+
+	srcConn, err := src.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer srcConn.Close()
+
+	return destConn.Raw(func(destDriverConn any) error {
+		return srcConn.Raw(func(srcDriverConn any) error {
+			d, ok := destDriverConn.(*sqlite3.SQLiteConn)
+			if !ok {
+				return errors.New("backup: destination is not a sqlite3 database")
+			}
+			s, ok := srcDriverConn.(*sqlite3.SQLiteConn)
+			if !ok {
+				return errors.New("backup: source is not a sqlite3 database")
+			}
+
+			b, err := d.Backup("main", s, "main")
+			if err != nil {
+				return err
+			}
+
+			// copy every page in one step, which holds a read lock
+			// on src until done, so the backup is consistent
+			_, err = b.Step(-1)
+			if err != nil {
+				b.Finish()
+				return err
+			}
+
+			return b.Finish()
+		})
+	})
+}
+
+// Verify runs an integrity check on the database in file
+func Verify(file string) error {
+	if _, err := os.Stat(file); err != nil {
+		return err
+	}
+
+	db, err := sql.Open("sqlite3", "file:"+file+"?mode=ro")
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	rows, err := db.Query(`PRAGMA integrity_check`)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	var problems []string
+	for rows.Next() {
+		var result string
+		err = rows.Scan(&result)
+		if err != nil {
+			return err
+		}
+		if result != "ok" {
+			problems = append(problems, result)
+		}
+	}
+	if err = rows.Err(); err != nil {
+		return err
+	}
+
+	if len(problems) > 0 {
+		return errors.New("integrity check failed: " + strings.Join(problems, "; "))
+	}
+	return nil
+}
+
+// File is a backup made by Backup
+type File struct {
+	Name string // path of the backup
+	Time time.Time
+}
+
+// List returns the backups of name in dir, newest first.
+// Other files, like the backups made before migrating, are left out.
+func List(dir, name string) ([]File, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	files := make([]File, 0)
+	for _, entry := range entries {
+		n := entry.Name()
+		if entry.IsDir() || !strings.HasPrefix(n, name+".") || !strings.HasSuffix(n, ".bak") {
+			continue
+		}
+
+		unix, err := strconv.ParseInt(strings.TrimSuffix(strings.TrimPrefix(n, name+"."), ".bak"), 10, 64)
+		if err != nil {
+			continue
+		}
+
+		files = append(files, File{Name: filepath.Join(dir, n), Time: time.Unix(unix, 0)})
+	}
+
+	sort.Slice(files, func(i, j int) bool {
+		return files[i].Time.After(files[j].Time)
+	})
+
+	return files, nil
+}
+
+// Prune deletes the backups of name in dir that retention doesn't keep
+// and returns the deleted files
+func Prune(dir, name string, retention Retention, now time.Time) ([]string, error) {
+	files, err := List(dir, name)
+	if err != nil {
+		return nil, err
+	}
+
+	keep := make(map[string]bool)
+	if len(files) > 0 {
+		keep[files[0].Name] = true
+	}
+
+	// files are newest first, so the first file seen of a day or week is kept
+	days := make(map[string]bool)
+	weeks := make(map[string]bool)
+	today := startOfDay(now)
+	for _, f := range files {
+		day := startOfDay(f.Time)
+		if today.Sub(day) < time.Duration(retention.Daily)*24*time.Hour {
+			key := day.Format(time.DateOnly)
+			if !days[key] {
+				days[key] = true
+				keep[f.Name] = true
+			}
+		}
+
+		if today.Sub(day) < time.Duration(retention.Weekly)*7*24*time.Hour {
+			year, week := f.Time.ISOWeek()
+			key := fmt.Sprintf("%d-%d", year, week)
+			if !weeks[key] {
+				weeks[key] = true
+				keep[f.Name] = true
+			}
+		}
+	}
+
+	removed := make([]string, 0)
+	for _, f := range files {
+		if keep[f.Name] {
+			continue
+		}
+
+		err = os.Remove(f.Name)
+		if err != nil {
+			return removed, err
+		}
+		removed = append(removed, f.Name)
+	}
+
+	return removed, nil
+}
+
+func startOfDay(t time.Time) time.Time {
+	year, month, day := t.Date()
+	return time.Date(year, month, day, 0, 0, 0, 0, t.Location())
+}
@@ -0,0 +1,144 @@
+// Copyright (C) 2025 Ethan Cheng <ethan@nijika.org>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package backup
+
+import (
+	"os"
+	"fmt"
+	"time"
+	"testing"
+	"path/filepath"
+	"database/sql"
+	_ "github.com/mattn/go-sqlite3"
+)
+
+func count(t *testing.T, db *sql.DB) int {
+	t.Helper()
+
+	var n int
+	err := db.QueryRow(`SELECT COUNT(*) FROM plays`).Scan(&n)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return n
+}
+
+func TestBackupAndRestore(t *testing.T) {
+	dir := t.TempDir()
+
+	db, err := sql.Open("sqlite3", filepath.Join(dir, "plays.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	_, err = db.Exec(`CREATE TABLE plays (user_play_date INTEGER)`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = db.Exec(`INSERT INTO plays VALUES (1), (2), (3)`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	now := time.Now()
+	file, err := Backup(db, filepath.Join(dir, "backups"), "plays.db", now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if file != filepath.Join(dir, "backups", fmt.Sprintf("plays.db.%d.bak", now.Unix())) {
+		t.Error("unexpected backup filename:", file)
+	}
+
+	_, err = Backup(db, filepath.Join(dir, "backups"), "plays.db", now)
+	if err == nil {
+		t.Error("expected error for existing backup")
+	}
+
+	_, err = db.Exec(`DELETE FROM plays`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = Restore(db, file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := count(t, db); n != 3 {
+		t.Error("expected 3 plays after restoring, got", n)
+	}
+
+	corrupt := filepath.Join(dir, "corrupt.bak")
+	err = os.WriteFile(corrupt, []byte("not a database"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = Restore(db, corrupt)
+	if err == nil {
+		t.Error("expected error restoring corrupt backup")
+	}
+	if n := count(t, db); n != 3 {
+		t.Error("corrupt backup changed db")
+	}
+}
+
+func TestPrune(t *testing.T) {
+	dir := t.TempDir()
+	now := time.Date(2025, 3, 28, 12, 0, 0, 0, time.UTC)
+
+	// two backups a day for 60 days
+	for i := 0; i < 120; i++ {
+		backupTime := now.Add(-time.Duration(i) * 12 * time.Hour)
+		err := os.WriteFile(filepath.Join(dir, fmt.Sprintf("plays.db.%d.bak", backupTime.Unix())), nil, 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	// not made by Backup, must be left alone
+	for _, name := range []string{"plays.db.v2.1743108003.bak", "songs.db.1743108003.bak"} {
+		err := os.WriteFile(filepath.Join(dir, name), nil, 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	_, err := Prune(dir, "plays.db", Retention{Daily: 7, Weekly: 4}, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	files, err := List(dir, "plays.db")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// 7 days, and 3 more weeks before them
+	if len(files) != 10 {
+		for _, f := range files {
+			t.Log(f.Time)
+		}
+		t.Fatal("expected 10 backups, got", len(files))
+	}
+	if !files[0].Time.Equal(now) {
+		t.Error("newest backup not kept")
+	}
+
+	for _, name := range []string{"plays.db.v2.1743108003.bak", "songs.db.1743108003.bak"} {
+		if _, err := os.Stat(filepath.Join(dir, name)); err != nil {
+			t.Error(name, "was removed")
+		}
+	}
+}
@@ -0,0 +1,393 @@
+// Copyright (C) 2025 Ethan Cheng <ethan@nijika.org>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+
+// Package config reads the settings playlog runs with from a JSON file
+// and the environment. Flags are applied over it by main.
+package config
+
+import (
+	"os"
+	"fmt"
+	"time"
+	"errors"
+	"strconv"
+	"encoding/json"
+
+	"github.com/yadayadajaychan/playlog/database"
+	"github.com/yadayadajaychan/playlog/internal/app"
+	"github.com/yadayadajaychan/playlog/internal/backup"
+	"github.com/yadayadajaychan/playlog/internal/activity"
+)
+
+// DefaultFilename is read if it exists and no other config file is given
+const DefaultFilename = "playlog.json"
+
+// Duration is a time.Duration written as e.g. "15m",
+// or as a number of seconds like the flags take
+type Duration time.Duration
+
+func (d *Duration) UnmarshalJSON(b []byte) error {
+	var v any
+	err := json.Unmarshal(b, &v)
+	if err != nil {
+		return err
+	}
+
+	switch v := v.(type) {
+	case float64:
+		*d = Duration(v * float64(time.Second))
+		return nil
+	case string:
+		*d, err = parseDuration(v)
+		return err
+	default:
+		return fmt.Errorf("invalid duration: %s", b)
+	}
+}
+
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+func parseDuration(s string) (Duration, error) {
+	secs, err := strconv.Atoi(s)
+	if err == nil {
+		return Duration(time.Duration(secs) * time.Second), nil
+	}
+
+	d, err := time.ParseDuration(s)
+	if err != nil {
+		return 0, fmt.Errorf("invalid duration: %s", s)
+	}
+	return Duration(d), nil
+}
+
+type Config struct {
+	Filename string `json:"-"` // of the config file that was read, if any
+
+	Listen     ListenConfig `json:"listen"`
+	Playdb     string       `json:"playdb"` // filename
+	Songdb     string       `json:"songdb"`
+	Source     SourceConfig `json:"source"`
+	Update     UpdateConfig `json:"update"`
+	Backup     BackupConfig `json:"backup"`
+	Validation string       `json:"validation"` // reject, warn or flag
+	Log        LogConfig    `json:"log"`
+	Day        DayConfig    `json:"day"`
+}
+
+type ListenConfig struct {
+	Port       int    `json:"port"`
+	AliasToken string `json:"alias_token"` // to change aliases with the api, "" to only use the CLI
+}
+
+// SourceConfig is where the plays of the default player come from.
+// Other players have their own settings in the play db.
+type SourceConfig struct {
+	DataSource string `json:"data_source"` // solips or kamai
+	AccessCode string `json:"access_code"` // Mythos Access Code
+	KamaiUser  string `json:"kamai_user"`  // kamaitachi username
+}
+
+type UpdateConfig struct {
+	Interval    Duration `json:"interval"`     // between updates
+	ApiInterval Duration `json:"api_interval"` // between api requests
+}
+
+type BackupConfig struct {
+	Dir        string   `json:"dir"` // "" for no backups while running
+	Interval   Duration `json:"interval"`
+	KeepDaily  int      `json:"keep_daily"`
+	KeepWeekly int      `json:"keep_weekly"`
+}
+
+type LogConfig struct {
+	Verbose int    `json:"verbose"` // 0 errors only, 1 info, 2 debug
+	File    string `json:"file"`    // appended to, "" for stderr
+}
+
+// DayConfig is when days start, for the activity per day
+type DayConfig struct {
+	Timezone string `json:"timezone"` // e.g. "Asia/Tokyo", "" for the local time zone
+	Start    string `json:"start"`    // e.g. "04:00" for days from 4 am to 4 am
+}
+
+// Default returns the settings used when nothing else is given
+func Default() Config {
+	return Config{
+		Listen:     ListenConfig{Port: 5000},
+		Playdb:     "plays.db",
+		Songdb:     "songs.db",
+		Source:     SourceConfig{DataSource: "solips"},
+		Update:     UpdateConfig{
+			Interval:    Duration(900 * time.Second),
+			ApiInterval: Duration(3 * time.Second),
+		},
+		Backup:     BackupConfig{
+			Interval:   Duration(86400 * time.Second),
+			KeepDaily:  7,
+			KeepWeekly: 4,
+		},
+		Validation: "flag",
+		Day:        DayConfig{Start: "00:00"},
+	}
+}
+
+// Load returns the default settings, overridden by the config file
+// and then by the environment. The config file is filename,
+// else $PLAYLOG_CONFIG, else DefaultFilename if it exists.
+func Load(filename string) (Config, error) {
+	c := Default()
+
+	if filename == "" {
+		filename = os.Getenv("PLAYLOG_CONFIG")
+	}
+	if filename == "" {
+		if _, err := os.Stat(DefaultFilename); err == nil {
+			filename = DefaultFilename
+		}
+	}
+
+	if filename != "" {
+		err := c.LoadFile(filename)
+		if err != nil {
+			return c, err
+		}
+	}
+
+	return c, c.LoadEnv()
+}
+
+// LoadFile reads filename over c, so settings it leaves out keep their value.
+// Unknown settings are an error, to catch typos.
+func (c *Config) LoadFile(filename string) error {
+	file, err := os.Open(filename)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	dec := json.NewDecoder(file)
+	dec.DisallowUnknownFields()
+	err = dec.Decode(c)
+	if err != nil {
+		return fmt.Errorf("%s: %w", filename, err)
+	}
+
+	c.Filename = filename
+	return nil
+}
+
+// EnvVars are the environment variables LoadEnv reads,
+// with the settings they override
+var EnvVars = []struct {
+	Name    string
+	Setting string
+	set     func(c *Config, v string) error
+}{
+	{"PLAYLOG_LISTEN_PORT", "listen.port", func(c *Config, v string) (err error) {
+		c.Listen.Port, err = strconv.Atoi(v)
+		return
+	}},
+	{"PLAYLOG_ALIAS_TOKEN", "listen.alias_token", func(c *Config, v string) error {
+		c.Listen.AliasToken = v
+		return nil
+	}},
+	{"PLAYLOG_PLAYDB", "playdb", func(c *Config, v string) error {
+		c.Playdb = v
+		return nil
+	}},
+	{"PLAYLOG_SONGDB", "songdb", func(c *Config, v string) error {
+		c.Songdb = v
+		return nil
+	}},
+	{"PLAYLOG_DATA_SOURCE", "source.data_source", func(c *Config, v string) error {
+		c.Source.DataSource = v
+		return nil
+	}},
+	{"PLAYLOG_ACCESS_CODE", "source.access_code", func(c *Config, v string) error {
+		c.Source.AccessCode = v
+		return nil
+	}},
+	{"PLAYLOG_KAMAI_USER", "source.kamai_user", func(c *Config, v string) error {
+		c.Source.KamaiUser = v
+		return nil
+	}},
+	{"PLAYLOG_UPDATE_INTERVAL", "update.interval", func(c *Config, v string) (err error) {
+		c.Update.Interval, err = parseDuration(v)
+		return
+	}},
+	{"PLAYLOG_API_INTERVAL", "update.api_interval", func(c *Config, v string) (err error) {
+		c.Update.ApiInterval, err = parseDuration(v)
+		return
+	}},
+	{"PLAYLOG_BACKUP_DIR", "backup.dir", func(c *Config, v string) error {
+		c.Backup.Dir = v
+		return nil
+	}},
+	{"PLAYLOG_BACKUP_INTERVAL", "backup.interval", func(c *Config, v string) (err error) {
+		c.Backup.Interval, err = parseDuration(v)
+		return
+	}},
+	{"PLAYLOG_KEEP_DAILY", "backup.keep_daily", func(c *Config, v string) (err error) {
+		c.Backup.KeepDaily, err = strconv.Atoi(v)
+		return
+	}},
+	{"PLAYLOG_KEEP_WEEKLY", "backup.keep_weekly", func(c *Config, v string) (err error) {
+		c.Backup.KeepWeekly, err = strconv.Atoi(v)
+		return
+	}},
+	{"PLAYLOG_VALIDATION", "validation", func(c *Config, v string) error {
+		c.Validation = v
+		return nil
+	}},
+	{"PLAYLOG_VERBOSE", "log.verbose", func(c *Config, v string) (err error) {
+		c.Log.Verbose, err = strconv.Atoi(v)
+		return
+	}},
+	{"PLAYLOG_LOG_FILE", "log.file", func(c *Config, v string) error {
+		c.Log.File = v
+		return nil
+	}},
+	{"PLAYLOG_TIMEZONE", "day.timezone", func(c *Config, v string) error {
+		c.Day.Timezone = v
+		return nil
+	}},
+	{"PLAYLOG_DAY_START", "day.start", func(c *Config, v string) error {
+		c.Day.Start = v
+		return nil
+	}},
+}
+
+// LoadEnv overrides c with the EnvVars that are set.
+// Variables that are set but empty count as set.
+func (c *Config) LoadEnv() error {
+	errs := make([]error, 0)
+	for _, v := range EnvVars {
+		value, ok := os.LookupEnv(v.Name)
+		if !ok {
+			continue
+		}
+
+		err := v.set(c, value)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", v.Name, err))
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// Validate returns every problem with c, joined into one error
+func (c Config) Validate() error {
+	errs := make([]error, 0)
+	invalid := func(format string, a ...any) {
+		errs = append(errs, fmt.Errorf(format, a...))
+	}
+
+	if c.Listen.Port < 1 || c.Listen.Port > 65535 {
+		invalid("listen.port must be between 1 and 65535")
+	}
+	if c.Playdb == "" {
+		invalid("playdb must be set")
+	}
+	if c.Songdb == "" {
+		invalid("songdb must be set")
+	}
+
+	if _, err := app.ParseDataSource(c.Source.DataSource); err != nil {
+		invalid("source.data_source: %w", err)
+	}
+	if _, err := database.ParseValidationMode(c.Validation); err != nil {
+		invalid("validation: %w", err)
+	}
+
+	if c.Update.Interval <= 0 {
+		invalid("update.interval must be greater than 0")
+	}
+	if c.Update.ApiInterval <= 0 {
+		invalid("update.api_interval must be greater than 0")
+	}
+
+	if c.Backup.Interval <= 0 {
+		invalid("backup.interval must be greater than 0")
+	}
+	if c.Backup.KeepDaily < 0 || c.Backup.KeepWeekly < 0 {
+		invalid("backup.keep_daily and backup.keep_weekly can't be negative")
+	}
+
+	if c.Log.Verbose < 0 {
+		invalid("log.verbose can't be negative")
+	}
+
+	if _, err := time.LoadLocation(c.Day.Timezone); err != nil {
+		invalid("day.timezone: %w", err)
+	}
+	if _, err := activity.ParseDayStart(c.Day.Start); err != nil {
+		invalid("day.start: %w", err)
+	}
+
+	return errors.Join(errs...)
+}
+
+// Env returns the app.Env with the settings of c,
+// without any databases or the mode to run in
+func (c Config) Env() (app.Env, error) {
+	env := app.Env{}
+
+	err := c.Validate()
+	if err != nil {
+		return env, err
+	}
+
+	env.DataSource, _ = app.ParseDataSource(c.Source.DataSource)
+	env.AccessCode = c.Source.AccessCode
+	env.KamaiUser = c.Source.KamaiUser
+
+	env.ValidationMode, _ = database.ParseValidationMode(c.Validation)
+	env.PlaydbFilename = c.Playdb
+
+	env.BackupDir = c.Backup.Dir
+	env.BackupInterval = time.Duration(c.Backup.Interval)
+	env.BackupRetention = backup.Retention{Daily: c.Backup.KeepDaily, Weekly: c.Backup.KeepWeekly}
+
+	env.Verbose = c.Log.Verbose
+	env.ListenPort = c.Listen.Port
+	env.AliasToken = c.Listen.AliasToken
+
+	env.UpdateInterval = time.Duration(c.Update.Interval)
+	env.ApiInterval = time.Duration(c.Update.ApiInterval)
+
+	// time.LoadLocation("") is UTC, not the local time zone
+	env.Timezone = time.Local
+	if c.Day.Timezone != "" {
+		env.Timezone, _ = time.LoadLocation(c.Day.Timezone)
+	}
+	env.DayStart, _ = activity.ParseDayStart(c.Day.Start)
+
+	return env, nil
+}
+
+// Redacted returns c with the credentials hidden, for printing
+func (c Config) Redacted() Config {
+	if c.Source.AccessCode != "" {
+		c.Source.AccessCode = "<redacted>"
+	}
+	if c.Listen.AliasToken != "" {
+		c.Listen.AliasToken = "<redacted>"
+	}
+	return c
+}
@@ -0,0 +1,161 @@
+// Copyright (C) 2025 Ethan Cheng <ethan@nijika.org>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+
+package config
+
+import (
+	"os"
+	"time"
+	"strings"
+	"testing"
+	"path/filepath"
+)
+
+func writeConfig(t *testing.T, contents string) string {
+	t.Helper()
+
+	filename := filepath.Join(t.TempDir(), "playlog.json")
+	err := os.WriteFile(filename, []byte(contents), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return filename
+}
+
+func TestLoad(t *testing.T) {
+	filename := writeConfig(t, `{
+		"listen": {"port": 3002},
+		"source": {"data_source": "kamai", "kamai_user": "alice"},
+		"update": {"interval": "30m", "api_interval": 5},
+		"backup": {"dir": "backups"}
+	}`)
+
+	t.Setenv("PLAYLOG_KAMAI_USER", "bob")
+	t.Setenv("PLAYLOG_API_INTERVAL", "10s")
+
+	c, err := Load(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if c.Filename != filename {
+		t.Errorf("expected filename %s, got %s", filename, c.Filename)
+	}
+	if c.Listen.Port != 3002 {
+		t.Errorf("expected port from file, got %d", c.Listen.Port)
+	}
+	if c.Source.DataSource != "kamai" {
+		t.Errorf("expected data source from file, got %s", c.Source.DataSource)
+	}
+	if c.Source.KamaiUser != "bob" {
+		t.Errorf("expected kamai user from env, got %s", c.Source.KamaiUser)
+	}
+	if time.Duration(c.Update.Interval) != 30*time.Minute {
+		t.Errorf("expected update interval from file, got %v", time.Duration(c.Update.Interval))
+	}
+	if time.Duration(c.Update.ApiInterval) != 10*time.Second {
+		t.Errorf("expected api interval from env, got %v", time.Duration(c.Update.ApiInterval))
+	}
+	if c.Backup.KeepDaily != 7 || c.Playdb != "plays.db" {
+		t.Errorf("expected defaults for settings left out, got %+v", c)
+	}
+
+	err = c.Validate()
+	if err != nil {
+		t.Error(err)
+	}
+}
+
+func TestLoadUnknownSetting(t *testing.T) {
+	filename := writeConfig(t, `{"listen": {"prot": 3002}}`)
+
+	_, err := Load(filename)
+	if err == nil || !strings.Contains(err.Error(), "prot") {
+		t.Errorf("expected an error about the unknown setting, got %v", err)
+	}
+}
+
+func TestLoadInvalidEnv(t *testing.T) {
+	t.Setenv("PLAYLOG_LISTEN_PORT", "abc")
+
+	_, err := Load("")
+	if err == nil || !strings.Contains(err.Error(), "PLAYLOG_LISTEN_PORT") {
+		t.Errorf("expected an error about PLAYLOG_LISTEN_PORT, got %v", err)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	c := Default()
+	c.Listen.Port = 0
+	c.Source.DataSource = "arcade"
+	c.Update.Interval = 0
+	c.Day.Timezone = "Nowhere/Nothing"
+	c.Day.Start = "04:10"
+
+	err := c.Validate()
+	if err == nil {
+		t.Fatal("expected an error")
+	}
+
+	// every problem is reported at once
+	for _, s := range []string{"listen.port", "source.data_source", "update.interval", "day.timezone", "day.start"} {
+		if !strings.Contains(err.Error(), s) {
+			t.Errorf("expected %s in %q", s, err)
+		}
+	}
+
+	err = Default().Validate()
+	if err != nil {
+		t.Errorf("expected the defaults to be valid, got %v", err)
+	}
+}
+
+func TestEnvTimezone(t *testing.T) {
+	c := Default()
+	env, err := c.Env()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if env.Timezone != time.Local {
+		t.Errorf("expected the local time zone by default, got %v", env.Timezone)
+	}
+
+	c.Day.Timezone = "Asia/Tokyo"
+	env, err = c.Env()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if env.Timezone.String() != "Asia/Tokyo" {
+		t.Errorf("expected Asia/Tokyo, got %v", env.Timezone)
+	}
+}
+
+func TestRedacted(t *testing.T) {
+	c := Default()
+	c.Source.AccessCode = "12345678901234567890"
+	c.Listen.AliasToken = "secret"
+
+	r := c.Redacted()
+	if strings.Contains(r.Source.AccessCode, "1234") {
+		t.Errorf("access code wasn't redacted: %s", r.Source.AccessCode)
+	}
+	if r.Listen.AliasToken != "<redacted>" {
+		t.Errorf("alias token wasn't redacted: %s", r.Listen.AliasToken)
+	}
+	if c.Source.AccessCode != "12345678901234567890" {
+		t.Errorf("Redacted changed the original config")
+	}
+}
@@ -0,0 +1,253 @@
+// Copyright (C) 2025 Ethan Cheng <ethan@nijika.org>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+// Package rating computes a player's rating from their plays,
+// and the history of their rating with its milestones
+package rating
+
+import (
+	"sort"
+
+	"github.com/yadayadajaychan/playlog/database"
+)
+
+// Versions are the versions of maimai, oldest first
+var Versions = []string{
+	"maimai", "maimai PLUS", "GreeN", "GreeN PLUS", "ORANGE", "ORANGE PLUS",
+	"PiNK", "PiNK PLUS", "MURASAKi", "MURASAKi PLUS", "MiLK", "MiLK PLUS", "FiNALE",
+	"maimaiでらっくす", "maimaiでらっくす PLUS", "Splash", "Splash PLUS",
+	"UNiVERSE", "UNiVERSE PLUS", "FESTiVAL", "FESTiVAL PLUS",
+	"BUDDiES", "BUDDiES PLUS", "PRiSM", "PRiSM PLUS", "CiRCLE",
+}
+
+// versionRank returns the position of version in Versions.
+// Versions that aren't in Versions are taken to be newer than any that are.
+func versionRank(version string) int {
+	for i, v := range Versions {
+		if v == version {
+			return i
+		}
+	}
+	return len(Versions)
+}
+
+// songsOfVersion returns the ids of the songs whose version has rank,
+// whose charts are new charts while it's the current version
+func songsOfVersion(songs []database.SongInfo, rank int) map[int]bool {
+	newSongs := make(map[int]bool)
+	for _, song := range songs {
+		if versionRank(song.Version) == rank {
+			newSongs[song.SongId] = true
+		}
+	}
+	return newSongs
+}
+
+const (
+	NewCharts = 15 // no. of charts of new songs that count towards rating
+	OldCharts = 35 // no. of charts of other songs that count
+)
+
+// Calculator computes the rating of a player like the game does: the sum of
+// the ratings of their best NewCharts charts of new songs and their best
+// OldCharts charts of other songs. Only the main chart of each difficulty
+// counts, and utage charts don't.
+type Calculator struct {
+	newSongs map[int]bool
+	best     map[chart]int
+}
+
+type chart struct {
+	songId     int
+	difficulty database.Difficulty
+}
+
+// NewCalculator returns a Calculator without plays,
+// with the songs whose charts are new charts
+func NewCalculator(newSongs map[int]bool) *Calculator {
+	return &Calculator{newSongs: newSongs, best: make(map[chart]int)}
+}
+
+// Add adds a play, worth rating at the internal level its chart had
+func (c *Calculator) Add(play database.PlayInfo, rating int) {
+	if play.Difficulty == database.Utage || play.Variant != 0 {
+		return
+	}
+
+	k := chart{play.SongId, play.Difficulty}
+	c.best[k] = max(c.best[k], rating)
+}
+
+// Rating returns the rating of the plays added so far
+func (c *Calculator) Rating() int {
+	var newRatings, oldRatings []int
+	for k, r := range c.best {
+		if c.newSongs[k.songId] {
+			newRatings = append(newRatings, r)
+		} else {
+			oldRatings = append(oldRatings, r)
+		}
+	}
+
+	return sumOfBest(newRatings, NewCharts) + sumOfBest(oldRatings, OldCharts)
+}
+
+func sumOfBest(ratings []int, n int) int {
+	sort.Sort(sort.Reverse(sort.IntSlice(ratings)))
+
+	sum := 0
+	for i := 0; i < len(ratings) && i < n; i++ {
+		sum += ratings[i]
+	}
+	return sum
+}
+
+// tiers maps the lowest rating of each colour of the rating plate to its name
+var tiers = []struct {
+	rating int
+	name   string
+}{
+	{15000, "Rainbow"},
+	{14500, "Platinum"},
+	{14000, "Gold"},
+	{13000, "Silver"},
+	{12000, "Bronze"},
+	{10000, "Purple"},
+	{7000, "Red"},
+	{4000, "Yellow"},
+	{2000, "Green"},
+	{1000, "Blue"},
+	{0, "White"},
+}
+
+// Tier returns the colour of the rating plate of a rating, e.g. "Gold"
+func Tier(rating int) string {
+	for _, t := range tiers {
+		if rating >= t.rating {
+			return t.name
+		}
+	}
+	return "White"
+}
+
+// TierThreshold returns the lowest rating of a tier, or -1 if there is no such tier
+func TierThreshold(tier string) int {
+	for _, t := range tiers {
+		if t.name == tier {
+			return t.rating
+		}
+	}
+	return -1
+}
+
+// Point is the rating after a play that changed it
+type Point struct {
+	Date   int64 // UserPlayDate of the play
+	Rating int
+	Change int  // since the point before, 0 for the first
+	Local  bool // computed by Calculator, since the play has no rating from upstream
+}
+
+// History returns the rating after each play that changed it, from plays
+// oldest first. playRating returns the rating a play is worth.
+//
+// Plays without a rating from upstream, like those from kamaitachi, get the
+// rating from upstream before them plus how much the rating computed from
+// the plays in the db has changed since, so they stay on the same scale
+// even if plays are missing from the db. Before any rating from upstream,
+// they get the computed rating itself.
+//
+// New songs are those of the version current at each play, which is taken
+// to be the newest version among songs of the plays up to it,
+// since songs can't be played before their version.
+func History(plays []database.PlayInfo, songs []database.SongInfo, playRating func(database.PlayInfo) (int, error)) ([]Point, error) {
+	versions := make(map[int]int, len(songs))
+	for _, song := range songs {
+		versions[song.SongId] = versionRank(song.Version)
+	}
+
+	points := make([]Point, 0)
+	current := -1
+	calc := NewCalculator(map[int]bool{})
+
+	// the last rating from upstream, and the computed rating at its play
+	upstream, computed := 0, 0
+
+	for _, play := range plays {
+		if v, ok := versions[play.SongId]; ok && v > current {
+			current = v
+			calc.newSongs = songsOfVersion(songs, current)
+		}
+
+		r, err := playRating(play)
+		if err != nil {
+			return nil, err
+		}
+		calc.Add(play, r)
+
+		p := Point{Date: play.UserPlayDate, Rating: play.AfterRating}
+		if p.Rating != 0 {
+			upstream, computed = p.Rating, calc.Rating()
+		} else {
+			p.Rating = upstream + calc.Rating() - computed
+			p.Local = true
+		}
+
+		if len(points) > 0 {
+			last := points[len(points)-1]
+			if p.Rating == last.Rating {
+				continue
+			}
+			p.Change = p.Rating - last.Rating
+		}
+		points = append(points, p)
+	}
+
+	return points, nil
+}
+
+// Milestone marks a point of the history
+type Milestone struct {
+	Date   int64
+	Rating int
+	Kind   string // "tier" for reaching a tier for the first time, or "peak"
+	Tier   string // reached, for "tier"
+}
+
+// Milestones returns the first point of each tier higher than that of the
+// first point, and the first point with the highest rating, oldest first
+func Milestones(points []Point) []Milestone {
+	milestones := make([]Milestone, 0)
+	if len(points) == 0 {
+		return milestones
+	}
+
+	highest := TierThreshold(Tier(points[0].Rating))
+	peak := points[0]
+	for _, p := range points[1:] {
+		if threshold := TierThreshold(Tier(p.Rating)); threshold > highest {
+			highest = threshold
+			milestones = append(milestones, Milestone{Date: p.Date, Rating: p.Rating, Kind: "tier", Tier: Tier(p.Rating)})
+		}
+		if p.Rating > peak.Rating {
+			peak = p
+		}
+	}
+
+	m := Milestone{Date: peak.Date, Rating: peak.Rating, Kind: "peak"}
+	i := sort.Search(len(milestones), func(i int) bool { return milestones[i].Date > peak.Date })
+	milestones = append(milestones[:i], append([]Milestone{m}, milestones[i:]...)...)
+	return milestones
+}
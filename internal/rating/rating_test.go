@@ -0,0 +1,172 @@
+// Copyright (C) 2025 Ethan Cheng <ethan@nijika.org>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package rating
+
+import (
+	"testing"
+
+	"github.com/yadayadajaychan/playlog/database"
+)
+
+func TestSongsOfVersion(t *testing.T) {
+	songs := []database.SongInfo{
+		{SongId: 1, Version: "maimai"},
+		{SongId: 2, Version: "PRiSM"},
+		{SongId: 3, Version: "BUDDiES PLUS"},
+		{SongId: 4, Version: "PRiSM"},
+		{SongId: 5, Version: "NEXT"},
+	}
+	newSongs := songsOfVersion(songs, versionRank("PRiSM"))
+	if len(newSongs) != 2 || !newSongs[2] || !newSongs[4] {
+		t.Errorf("expected songs 2 and 4, got %v", newSongs)
+	}
+
+	// versions newer than Versions are newest
+	if versionRank("NEXT") <= versionRank("CiRCLE") {
+		t.Errorf("expected NEXT to be newer than CiRCLE")
+	}
+}
+
+func TestCalculator(t *testing.T) {
+	calc := NewCalculator(map[int]bool{1: true})
+
+	// only the best play of each chart counts
+	calc.Add(database.PlayInfo{SongId: 1, Difficulty: database.Master}, 300)
+	calc.Add(database.PlayInfo{SongId: 1, Difficulty: database.Master}, 250)
+	calc.Add(database.PlayInfo{SongId: 1, Difficulty: database.Expert}, 200)
+	// utage and other variants don't count
+	calc.Add(database.PlayInfo{SongId: 2, Difficulty: database.Utage}, 400)
+	calc.Add(database.PlayInfo{SongId: 2, Difficulty: database.Master, Variant: 1}, 400)
+	if calc.Rating() != 500 {
+		t.Errorf("expected 500, got %d", calc.Rating())
+	}
+
+	// only the best OldCharts old charts count
+	for i := 0; i < OldCharts+5; i++ {
+		calc.Add(database.PlayInfo{SongId: 100 + i, Difficulty: database.Master}, 100+i)
+	}
+	expected := 500
+	for i := 5; i < OldCharts+5; i++ {
+		expected += 100 + i
+	}
+	if calc.Rating() != expected {
+		t.Errorf("expected %d, got %d", expected, calc.Rating())
+	}
+}
+
+func TestTier(t *testing.T) {
+	tests := []struct {
+		rating int
+		tier   string
+	}{
+		{0, "White"}, {999, "White"}, {1000, "Blue"}, {6999, "Yellow"},
+		{12000, "Bronze"}, {14499, "Gold"}, {14500, "Platinum"}, {16000, "Rainbow"},
+	}
+	for _, test := range tests {
+		if Tier(test.rating) != test.tier {
+			t.Errorf("%d: expected %s, got %s", test.rating, test.tier, Tier(test.rating))
+		}
+	}
+}
+
+func TestHistory(t *testing.T) {
+	plays := []database.PlayInfo{
+		{UserPlayDate: 1, SongId: 1, Difficulty: database.Master, AfterRating: 13900},
+		{UserPlayDate: 2, SongId: 1, Difficulty: database.Master, AfterRating: 13900},
+		{UserPlayDate: 3, SongId: 2, Difficulty: database.Master, AfterRating: 14050},
+		// from kamaitachi, without a rating
+		{UserPlayDate: 4, SongId: 3, Difficulty: database.Master},
+		{UserPlayDate: 5, SongId: 4, Difficulty: database.Master},
+		{UserPlayDate: 6, SongId: 5, Difficulty: database.Master, AfterRating: 14480},
+		{UserPlayDate: 7, SongId: 6, Difficulty: database.Master},
+	}
+	// only a few plays are in the db, so the computed rating
+	// is much lower than the one from upstream
+	worth := map[int]int{1: 200, 2: 300, 3: 250, 4: 200, 5: 100, 6: 150}
+	points, err := History(plays, nil, func(play database.PlayInfo) (int, error) {
+		return worth[play.SongId], nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []Point{
+		{Date: 1, Rating: 13900},
+		{Date: 3, Rating: 14050, Change: 150},
+		{Date: 4, Rating: 14300, Change: 250, Local: true},
+		{Date: 5, Rating: 14500, Change: 200, Local: true},
+		{Date: 6, Rating: 14480, Change: -20},
+		{Date: 7, Rating: 14630, Change: 150, Local: true},
+	}
+	if len(points) != len(expected) {
+		t.Fatalf("expected %v, got %v", expected, points)
+	}
+	for i := range expected {
+		if points[i] != expected[i] {
+			t.Errorf("expected %+v, got %+v", expected[i], points[i])
+		}
+	}
+
+	milestones := Milestones(points)
+	expectedMilestones := []Milestone{
+		{Date: 3, Rating: 14050, Kind: "tier", Tier: "Gold"},
+		{Date: 5, Rating: 14500, Kind: "tier", Tier: "Platinum"},
+		{Date: 7, Rating: 14630, Kind: "peak"},
+	}
+	if len(milestones) != len(expectedMilestones) {
+		t.Fatalf("expected %v, got %v", expectedMilestones, milestones)
+	}
+	for i := range expectedMilestones {
+		if milestones[i] != expectedMilestones[i] {
+			t.Errorf("expected %+v, got %+v", expectedMilestones[i], milestones[i])
+		}
+	}
+
+	if len(Milestones(nil)) != 0 {
+		t.Error("expected no milestones without points")
+	}
+}
+
+func TestHistoryNewSongs(t *testing.T) {
+	var songs []database.SongInfo
+	var plays []database.PlayInfo
+	for i := 1; i <= NewCharts+1; i++ {
+		songs = append(songs, database.SongInfo{SongId: i, Version: "PRiSM"})
+		plays = append(plays, database.PlayInfo{UserPlayDate: int64(i), SongId: i, Difficulty: database.Master})
+	}
+	songs = append(songs, database.SongInfo{SongId: 100, Version: "CiRCLE"})
+	plays = append(plays, database.PlayInfo{UserPlayDate: 100, SongId: 100, Difficulty: database.Master})
+
+	points, err := History(plays, songs, func(play database.PlayInfo) (int, error) {
+		return 100, nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// until a song of CiRCLE is played, the songs of PRiSM are new,
+	// so only NewCharts of them count
+	if len(points) != NewCharts+1 {
+		t.Fatalf("expected %d points, got %v", NewCharts+1, points)
+	}
+	if p := points[NewCharts-1]; p.Date != NewCharts || p.Rating != NewCharts*100 {
+		t.Errorf("expected %d at %d, got %+v", NewCharts*100, NewCharts, p)
+	}
+	// then they're old, and all of them count
+	if p := points[NewCharts]; p.Date != 100 || p.Rating != (NewCharts+2)*100 {
+		t.Errorf("expected %d at 100, got %+v", (NewCharts+2)*100, p)
+	}
+}
@@ -0,0 +1,252 @@
+// Copyright (C) 2025 Ethan Cheng <ethan@nijika.org>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+// Package score derives values from plays that upstream doesn't store,
+// like the rating a play is worth
+package score
+
+import (
+	"fmt"
+	"errors"
+	"strconv"
+	"strings"
+
+	"github.com/yadayadajaychan/playlog/database"
+)
+
+// MaxAchievement is the highest achievement that counts towards rating,
+// 100.5000% multiplied by 10000 like database.PlayInfo.Score
+const MaxAchievement = 1005000
+
+// ratingFactors maps the lowest achievement of each rank (and the
+// x.x999% boundaries just below some of them) to its rating factor,
+// multiplied by 10
+var ratingFactors = []struct {
+	achievement int
+	factor      int
+}{
+	{1005000, 224}, // SSS+
+	{1004999, 222},
+	{1000000, 216}, // SSS
+	{999999, 214},
+	{995000, 211}, // SS+
+	{990000, 208}, // SS
+	{989999, 206},
+	{980000, 203}, // S+
+	{970000, 200}, // S
+	{969999, 176},
+	{940000, 168}, // AAA
+	{900000, 152}, // AA
+	{800000, 136}, // A
+	{799999, 128},
+	{750000, 120}, // BBB
+	{700000, 112}, // BB
+	{600000, 96},  // B
+	{500000, 80},  // C
+	{400000, 64},  // D
+	{300000, 48},
+	{200000, 32},
+	{100000, 16},
+}
+
+// Rating returns the rating a play is worth.
+// achievement is multiplied by 10000 (e.g. 1005000 for 100.5%)
+// and internalLevel by 10 (e.g. 137 for 13.7).
+func Rating(achievement, internalLevel int) int {
+	if achievement > MaxAchievement {
+		achievement = MaxAchievement
+	}
+
+	factor := 0
+	for _, f := range ratingFactors {
+		if achievement >= f.achievement {
+			factor = f.factor
+			break
+		}
+	}
+
+	// level/10 * factor/10 * achievement/1000000
+	return int(int64(internalLevel) * int64(factor) * int64(achievement) / 100000000)
+}
+
+// ranks maps the lowest achievement of each rank to its name
+var ranks = []struct {
+	achievement int
+	name        string
+}{
+	{1005000, "SSS+"},
+	{1000000, "SSS"},
+	{995000, "SS+"},
+	{990000, "SS"},
+	{980000, "S+"},
+	{970000, "S"},
+	{940000, "AAA"},
+	{900000, "AA"},
+	{800000, "A"},
+	{750000, "BBB"},
+	{700000, "BB"},
+	{600000, "B"},
+	{500000, "C"},
+}
+
+// Rank returns the rank of an achievement, e.g. "SSS+" for 1005000,
+// or "D" below 50%
+func Rank(achievement int) string {
+	for _, r := range ranks {
+		if achievement >= r.achievement {
+			return r.name
+		}
+	}
+	return "D"
+}
+
+// dxStarPercents are the percentages of the max dx score
+// needed for 1 to 5 dx stars
+var dxStarPercents = []int{85, 90, 93, 95, 97}
+
+// MaxDxScore returns the highest dx score of a chart, 3 per note
+func MaxDxScore(maxNotes int) int {
+	return maxNotes * 3
+}
+
+// DxStars returns the no. of dx stars (0 to 5) a dx score is worth
+// on a chart with maxNotes notes, or 0 if maxNotes is unknown
+func DxStars(dxScore, maxNotes int) int {
+	max := MaxDxScore(maxNotes)
+	if max <= 0 {
+		return 0
+	}
+
+	stars := 0
+	for _, percent := range dxStarPercents {
+		if dxScore*100 >= max*percent {
+			stars++
+		}
+	}
+	return stars
+}
+
+// ComboLamp returns the lamp of a play's combo, e.g. "AP+",
+// or "CLEAR" or "FAILED" if it has none
+func ComboLamp(status database.ComboStatus, isClear bool) string {
+	switch status {
+	case database.AllPerfectPlus:
+		return "AP+"
+	case database.AllPerfect:
+		return "AP"
+	case database.FullComboPlus:
+		return "FC+"
+	case database.FullCombo:
+		return "FC"
+	}
+
+	if isClear {
+		return "CLEAR"
+	}
+	return "FAILED"
+}
+
+// SyncLamp returns the lamp of a play's sync, e.g. "FDX+", or "" if it has none
+func SyncLamp(status database.SyncStatus) string {
+	switch status {
+	case database.FullSyncDxPlus:
+		return "FDX+"
+	case database.FullSyncDx:
+		return "FDX"
+	case database.FullSyncPlus:
+		return "FS+"
+	case database.FullSync:
+		return "FS"
+	default:
+		return ""
+	}
+}
+
+// Derived are the values of a play that are derived from it and its chart,
+// as the game shows them
+type Derived struct {
+	Rank       string // e.g. "SSS+"
+	DxStars    int    // 0 to 5
+	MaxDxScore int    // 0 if the no. of notes of the chart is unknown
+	Rating     int    // the play is worth, at the internal level it was played at
+	ComboLamp  string // e.g. "AP+", "CLEAR" or "FAILED"
+	SyncLamp   string // e.g. "FDX+", or "" if the play has none
+}
+
+// Derive returns the values derived from play on chart, whose internal level
+// (multiplied by 10) was internalLevel when it was played. If the no. of notes
+// of chart is unknown, e.g. because it isn't in the song db, the play's
+// TotalCombo is used instead.
+func Derive(play database.PlayInfo, chart database.ChartInfo, internalLevel int) Derived {
+	maxNotes := chart.MaxNotes
+	if maxNotes <= 0 {
+		maxNotes = play.TotalCombo
+	}
+
+	return Derived{
+		Rank:       Rank(play.Score),
+		DxStars:    DxStars(play.DxScore, maxNotes),
+		MaxDxScore: MaxDxScore(maxNotes),
+		Rating:     Rating(play.Score, internalLevel),
+		ComboLamp:  ComboLamp(play.ComboStatus, play.IsClear),
+		SyncLamp:   SyncLamp(play.SyncStatus),
+	}
+}
+
+// plusFrom is the first decimal of an internal level that's shown as a "+" level
+const plusFrom = 6
+
+// LevelLabel returns the level shown in game for an internal level
+// multiplied by 10, e.g. "13" for 135 and "13+" for 137.
+// Levels below 7 have no "+" levels.
+func LevelLabel(internalLevel int) string {
+	level := internalLevel / 10
+	if level >= 7 && internalLevel%10 >= plusFrom {
+		return strconv.Itoa(level) + "+"
+	}
+	return strconv.Itoa(level)
+}
+
+// ParseLevel returns the range of internal levels, multiplied by 10,
+// of a level like "13", "13+" or "13.7"
+func ParseLevel(s string) (min, max int, err error) {
+	invalid := fmt.Errorf("invalid level: %s", s)
+
+	if whole, decimal, ok := strings.Cut(s, "."); ok {
+		w, err := strconv.Atoi(whole)
+		if err != nil || len(decimal) != 1 || decimal[0] < '0' || decimal[0] > '9' {
+			return 0, 0, invalid
+		}
+		level := w*10 + int(decimal[0]-'0')
+		return level, level, nil
+	}
+
+	plus := strings.HasSuffix(s, "+")
+	level, err := strconv.Atoi(strings.TrimSuffix(s, "+"))
+	if err != nil || level < 1 {
+		return 0, 0, invalid
+	}
+
+	switch {
+	case plus && level < 7:
+		return 0, 0, errors.New("levels below 7 have no + level: " + s)
+	case plus:
+		return level*10 + plusFrom, level*10 + 9, nil
+	case level < 7:
+		return level * 10, level*10 + 9, nil
+	default:
+		return level * 10, level*10 + plusFrom - 1, nil
+	}
+}
@@ -0,0 +1,215 @@
+// Copyright (C) 2025 Ethan Cheng <ethan@nijika.org>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package score
+
+import (
+	"testing"
+
+	"github.com/yadayadajaychan/playlog/database"
+)
+
+func TestRating(t *testing.T) {
+	tests := []struct {
+		achievement   int
+		internalLevel int
+		rating        int
+	}{
+		{1005000, 150, 337}, // 15.0 SSS+
+		{1010000, 150, 337}, // capped at 100.5%
+		{1005000, 137, 308},
+		{1004999, 137, 305},
+		{1000000, 137, 295},
+		{995000, 137, 287},
+		{990000, 130, 267},
+		{980000, 130, 258},
+		{971017, 137, 266},
+		{969999, 137, 233},
+		{940000, 120, 189},
+		{800000, 100, 108},
+		{0, 150, 0},
+	}
+
+	for _, test := range tests {
+		rating := Rating(test.achievement, test.internalLevel)
+		if rating != test.rating {
+			t.Errorf("Rating(%d, %d): expected %d, got %d",
+				test.achievement, test.internalLevel, test.rating, rating)
+		}
+	}
+}
+
+func TestRank(t *testing.T) {
+	tests := []struct {
+		achievement int
+		rank        string
+	}{
+		{1010000, "SSS+"},
+		{1005000, "SSS+"},
+		{1004999, "SSS"},
+		{1000000, "SSS"},
+		{995000, "SS+"},
+		{990000, "SS"},
+		{980000, "S+"},
+		{971017, "S"},
+		{969999, "AAA"},
+		{940000, "AAA"},
+		{939999, "AA"},
+		{900000, "AA"},
+		{899999, "A"},
+		{800000, "A"},
+		{799999, "BBB"},
+		{750000, "BBB"},
+		{700000, "BB"},
+		{600000, "B"},
+		{500000, "C"},
+		{499999, "D"},
+		{0, "D"},
+	}
+
+	for _, test := range tests {
+		rank := Rank(test.achievement)
+		if rank != test.rank {
+			t.Errorf("Rank(%d): expected %s, got %s", test.achievement, test.rank, rank)
+		}
+	}
+}
+
+func TestDxStars(t *testing.T) {
+	// max dx score 3000
+	tests := []struct {
+		dxScore int
+		stars   int
+	}{
+		{3000, 5},
+		{2910, 5}, // 97%
+		{2909, 4},
+		{2850, 4}, // 95%
+		{2790, 3}, // 93%
+		{2700, 2}, // 90%
+		{2550, 1}, // 85%
+		{2549, 0},
+		{0, 0},
+	}
+
+	for _, test := range tests {
+		stars := DxStars(test.dxScore, 1000)
+		if stars != test.stars {
+			t.Errorf("DxStars(%d, 1000): expected %d, got %d", test.dxScore, test.stars, stars)
+		}
+	}
+
+	if stars := DxStars(100, 0); stars != 0 {
+		t.Errorf("expected no stars without max notes, got %d", stars)
+	}
+}
+
+func TestLamps(t *testing.T) {
+	if lamp := ComboLamp(database.AllPerfectPlus, true); lamp != "AP+" {
+		t.Errorf("expected AP+, got %s", lamp)
+	}
+	if lamp := ComboLamp(database.FullCombo, true); lamp != "FC" {
+		t.Errorf("expected FC, got %s", lamp)
+	}
+	if lamp := ComboLamp(database.NoCombo, true); lamp != "CLEAR" {
+		t.Errorf("expected CLEAR, got %s", lamp)
+	}
+	if lamp := ComboLamp(database.NoCombo, false); lamp != "FAILED" {
+		t.Errorf("expected FAILED, got %s", lamp)
+	}
+	if lamp := SyncLamp(database.FullSyncDxPlus); lamp != "FDX+" {
+		t.Errorf("expected FDX+, got %s", lamp)
+	}
+	if lamp := SyncLamp(database.NoSync); lamp != "" {
+		t.Errorf("expected no sync lamp, got %s", lamp)
+	}
+}
+
+func TestDerive(t *testing.T) {
+	chart := database.ChartInfo{Difficulty: database.Master, MaxNotes: 1000}
+
+	tests := []struct {
+		play    database.PlayInfo
+		chart   database.ChartInfo
+		derived Derived
+	}{
+		{
+			database.PlayInfo{Score: 1005000, DxScore: 3000, ComboStatus: database.AllPerfectPlus, SyncStatus: database.FullSyncDxPlus, IsClear: true},
+			chart,
+			Derived{Rank: "SSS+", DxStars: 5, MaxDxScore: 3000, Rating: 308, ComboLamp: "AP+", SyncLamp: "FDX+"},
+		},
+		{
+			database.PlayInfo{Score: 999999, DxScore: 2909, ComboStatus: database.FullComboPlus, SyncStatus: database.FullSync, IsClear: true},
+			chart,
+			Derived{Rank: "SS+", DxStars: 4, MaxDxScore: 3000, Rating: 293, ComboLamp: "FC+", SyncLamp: "FS"},
+		},
+		{
+			database.PlayInfo{Score: 970000, DxScore: 2550, IsClear: true},
+			chart,
+			Derived{Rank: "S", DxStars: 1, MaxDxScore: 3000, Rating: 265, ComboLamp: "CLEAR"},
+		},
+		{
+			database.PlayInfo{Score: 799999, DxScore: 2549, IsClear: false},
+			chart,
+			Derived{Rank: "BBB", DxStars: 0, MaxDxScore: 3000, Rating: 140, ComboLamp: "FAILED"},
+		},
+		{
+			// the chart isn't in the song db, so TotalCombo is used
+			database.PlayInfo{Score: 1000000, DxScore: 1455, TotalCombo: 500, IsClear: true},
+			database.ChartInfo{},
+			Derived{Rank: "SSS", DxStars: 5, MaxDxScore: 1500, Rating: 295, ComboLamp: "CLEAR"},
+		},
+	}
+
+	for i, test := range tests {
+		derived := Derive(test.play, test.chart, 137)
+		if derived != test.derived {
+			t.Errorf("test %d: expected %+v, got %+v", i, test.derived, derived)
+		}
+	}
+}
+
+func TestLevels(t *testing.T) {
+	labels := map[int]string{130: "13", 135: "13", 136: "13+", 139: "13+", 150: "15", 68: "6"}
+	for level, label := range labels {
+		if l := LevelLabel(level); l != label {
+			t.Errorf("LevelLabel(%d): expected %s, got %s", level, label, l)
+		}
+	}
+
+	tests := []struct {
+		level    string
+		min, max int
+	}{
+		{"13", 130, 135},
+		{"13+", 136, 139},
+		{"13.7", 137, 137},
+		{"6", 60, 69},
+	}
+	for _, test := range tests {
+		min, max, err := ParseLevel(test.level)
+		if err != nil {
+			t.Errorf("ParseLevel(%s): %v", test.level, err)
+		} else if min != test.min || max != test.max {
+			t.Errorf("ParseLevel(%s): expected %d-%d, got %d-%d", test.level, test.min, test.max, min, max)
+		}
+	}
+
+	for _, level := range []string{"", "+", "6+", "13.", "13.75", "abc"} {
+		if _, _, err := ParseLevel(level); err == nil {
+			t.Errorf("ParseLevel(%q): expected an error", level)
+		}
+	}
+}
@@ -0,0 +1,216 @@
+// Copyright (C) 2025 Ethan Cheng <ethan@nijika.org>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+// Package songs reads song data from songs.json and syncs it with the song db
+package songs
+
+import (
+	"io"
+	"fmt"
+	"math"
+	"time"
+	"errors"
+	"strings"
+	"encoding/json"
+
+	"github.com/yadayadajaychan/playlog/database"
+)
+
+type songData struct {
+	Song_id		int
+	Name		string
+	Artist		string
+	Type		string
+	Bpm		float64
+	Category	string
+	Version		string
+	Sort		string
+	Charts		[]chartData
+}
+
+type chartData struct {
+	Difficulty	string
+	Variant		int
+	Utage_kind	string
+	Buddy		*bool
+	Label		string
+	Level		int
+	Internal_level	float64
+	Notes_designer	string
+	Max_notes	int
+	Tap_notes	int
+	Hold_notes	int
+	Slide_notes	int
+	Touch_notes	int
+	Break_notes	int
+}
+
+// Load reads songs in the songs.json format
+func Load(data io.Reader) ([]database.SongInfo, error) {
+	songs := make([]songData, 0, 2048)
+
+	decoder := json.NewDecoder(data)
+	err := decoder.Decode(&songs)
+	if err != nil {
+		return nil, err
+	}
+
+	songInfos := make([]database.SongInfo, 0, len(songs))
+	for _, song := range songs {
+		songInfo, err := toSongInfo(song)
+		if err != nil {
+			return nil, err
+		}
+		songInfos = append(songInfos, songInfo)
+	}
+
+	return songInfos, nil
+}
+
+func toSongInfo(song songData) (database.SongInfo, error) {
+	songInfo := database.SongInfo{
+		SongId:  song.Song_id,
+		Name:     song.Name,
+		Artist:   song.Artist,
+		Type:     song.Type,
+		Bpm:      int(song.Bpm),
+		Category: song.Category,
+		Version:  song.Version,
+		Sort:     song.Sort,
+		Charts:   make([]database.ChartInfo, 0, 5),
+	}
+
+	for _, chart := range song.Charts {
+		chartInfo := database.ChartInfo{
+			Variant:       chart.Variant,
+			UtageKind:     chart.Utage_kind,
+			Label:         chart.Label,
+			Level:          chart.Level,
+			InternalLevel: int(math.Round(chart.Internal_level * 10)),
+			NotesDesigner: chart.Notes_designer,
+			MaxNotes:      chart.Max_notes,
+			TapNotes:      chart.Tap_notes,
+			HoldNotes:     chart.Hold_notes,
+			SlideNotes:    chart.Slide_notes,
+			TouchNotes:    chart.Touch_notes,
+			BreakNotes:    chart.Break_notes,
+		}
+
+		difficulty, err := parseDifficulty(chart.Difficulty)
+		if err != nil {
+			return songInfo, err
+		}
+		chartInfo.Difficulty = difficulty
+
+		// utage charts only have their kind in the song's name,
+		// e.g. "[協]Love You"
+		if difficulty == database.Utage && chartInfo.UtageKind == "" {
+			chartInfo.UtageKind = database.ParseUtageKind(song.Name)
+		}
+		if chart.Buddy != nil {
+			chartInfo.Buddy = *chart.Buddy
+		} else {
+			chartInfo.Buddy = chartInfo.UtageKind == database.BuddyUtageKind
+		}
+		if chartInfo.Label == "" {
+			chartInfo.Label = chartLabel(chartInfo)
+		}
+
+		songInfo.Charts = append(songInfo.Charts, chartInfo)
+	}
+
+	return songInfo, nil
+}
+
+// chartLabel returns the default label of a chart,
+// e.g. "[協] 2P" for the second variant of a buddy chart
+func chartLabel(chart database.ChartInfo) string {
+	var label string
+	if chart.UtageKind != "" {
+		label = "[" + chart.UtageKind + "]"
+	}
+
+	if chart.Buddy {
+		label = strings.TrimSpace(fmt.Sprintf("%s %dP", label, chart.Variant+1))
+	} else if chart.Variant != 0 {
+		label = strings.TrimSpace(fmt.Sprintf("%s #%d", label, chart.Variant+1))
+	}
+
+	return label
+}
+
+func parseDifficulty(difficulty string) (database.Difficulty, error) {
+	switch difficulty {
+	case "basic":
+		return database.Basic, nil
+	case "advanced":
+		return database.Advanced, nil
+	case "expert":
+		return database.Expert, nil
+	case "master":
+		return database.Master, nil
+	case "remaster":
+		return database.ReMaster, nil
+	case "utage":
+		return database.Utage, nil
+	default:
+		return database.Basic, errors.New(fmt.Sprint("unexpected level difficulty: ", difficulty))
+	}
+}
+
+type levelData struct {
+	Song_id		int
+	Difficulty	string
+	Game_version	string
+	Effective_from	string // RFC 3339, e.g. "2025-03-13T00:00:00+09:00"
+	Internal_level	float64
+}
+
+// LoadLevels reads the internal levels of charts per game version, e.g.
+//
+//	[{"song_id": 11441, "difficulty": "master", "game_version": "PRiSM",
+//	  "effective_from": "2025-03-13T00:00:00+09:00", "internal_level": 13.7}]
+func LoadLevels(data io.Reader) ([]database.ChartLevel, error) {
+	levels := make([]levelData, 0, 1024)
+
+	decoder := json.NewDecoder(data)
+	err := decoder.Decode(&levels)
+	if err != nil {
+		return nil, err
+	}
+
+	chartLevels := make([]database.ChartLevel, 0, len(levels))
+	for _, level := range levels {
+		difficulty, err := parseDifficulty(level.Difficulty)
+		if err != nil {
+			return nil, err
+		}
+
+		effectiveFrom, err := time.Parse(time.RFC3339, level.Effective_from)
+		if err != nil {
+			return nil, err
+		}
+
+		chartLevels = append(chartLevels, database.ChartLevel{
+			SongId:        level.Song_id,
+			Difficulty:    difficulty,
+			GameVersion:   level.Game_version,
+			EffectiveFrom: effectiveFrom.Unix(),
+			InternalLevel: int(math.Round(level.Internal_level * 10)),
+		})
+	}
+
+	return chartLevels, nil
+}
@@ -0,0 +1,65 @@
+// Copyright (C) 2025 Ethan Cheng <ethan@nijika.org>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package songs
+
+import (
+	"io"
+	"fmt"
+
+	"github.com/yadayadajaychan/playlog/database"
+)
+
+// PrintDiff writes diff in a human readable form:
+// "+" for added songs, "~" for changes and "=" for songs that aren't
+// in songs, which Sync keeps
+func PrintDiff(w io.Writer, diff database.SongDiff, songs []database.SongInfo) {
+	names := make(map[int]string, len(songs))
+	for _, song := range songs {
+		names[song.SongId] = song.Name
+	}
+
+	for _, song := range diff.Added {
+		fmt.Fprintf(w, "+ %d %s (%s)\n", song.SongId, song.Name, song.Type)
+	}
+
+	for _, c := range diff.Changed {
+		what := c.Field
+		if c.Difficulty != nil {
+			what = c.Difficulty.String() + " " + c.Field
+		}
+
+		if c.Old == "" && c.New == "added" {
+			fmt.Fprintf(w, "~ %d %s: %s added\n", c.SongId, names[c.SongId], what)
+		} else {
+			fmt.Fprintf(w, "~ %d %s: %s '%s' -> '%s'\n", c.SongId, names[c.SongId], what, c.Old, c.New)
+		}
+	}
+
+	for _, song := range diff.Removed {
+		fmt.Fprintf(w, "= %d %s (%s): not in songs.json (kept)\n", song.SongId, song.Name, song.Type)
+	}
+
+	fmt.Fprintf(w, "%d added, %d changed, %d not in songs.json (kept)\n", len(diff.Added), len(diff.Changed), len(diff.Removed))
+}
+
+// Sync adds and updates songs in songdb, recording every change in the
+// song history. Songs missing from songs are kept, since plays may still
+// refer to them. The songs are synced in one transaction, so if any
+// of them fails, songdb is left as it was.
+func Sync(songdb database.SongStore, songs []database.SongInfo) error {
+	_, err := songdb.UpsertSongs(songs)
+	return err
+}
@@ -18,26 +18,40 @@
 package kamai
 
 import (
+	"context"
 	"fmt"
 	"io"
 	"log"
 	"net/http"
 	"encoding/json"
-	"time"
 	"strings"
 	"math"
 	"errors"
 
 	"github.com/yadayadajaychan/playlog/database"
-	"github.com/yadayadajaychan/playlog/internal/context"
+	"github.com/yadayadajaychan/playlog/internal/app"
 )
 
 const (
 	apiUrl = "https://kamai.tachi.ac/api/v1"
+	source = "kamai" // database.PlayInfo.Source of plays from kamaitachi
 )
 
-func Update(ctx context.PlaylogCtx) error {
-	sess := &sessions{User: ctx.KamaiUser}
+// Update adds the plays of env.KamaiUser that aren't in env.Playdb yet,
+// delaying by env.ApiInterval between requests.
+// Plays rejected by validation are quarantined, and added by a later
+// update once the play db lets them.
+// Requests and queries are cancelled with ctx.
+func Update(ctx context.Context, env app.Env) error {
+	env.Playdb = env.Playdb.WithContext(ctx)
+	env.Songdb = env.Songdb.WithContext(ctx)
+
+	err := PromoteQuarantined(ctx, env)
+	if err != nil {
+		return err
+	}
+
+	sess := &sessions{User: env.KamaiUser, ctx: ctx}
 	allScoreIds := make([]string, 0, 100)
 
 	for sess.Next() {
@@ -48,28 +62,55 @@ func Update(ctx context.PlaylogCtx) error {
 		}
 		allScoreIds = append(allScoreIds, scoreIds...)
 
-		if ctx.Verbose >= 1 {
+		if env.Verbose >= 1 {
 			log.Printf("retrieved %d scoreIds", len(scoreIds))
 		}
 
-		time.Sleep(ctx.ApiInterval)
+		err := app.Sleep(ctx, env.ApiInterval)
+		if err != nil {
+			return err
+		}
 	}
 	if sess.Err() != nil {
 		return sess.Err()
 	}
 
 	for _, scoreId := range allScoreIds {
-		score, err := getScore(scoreId)
+		_, err := env.Playdb.GetPlayBySourceId(source, scoreId)
+		if err == nil {
+			if env.Verbose >= 2 {
+				log.Printf("score %s already exists in db\n", scoreId)
+			}
+			continue
+		} else if _, ok := err.(*database.PlayNotFoundError); !ok {
+			return err
+		}
+
+		quarantined, err := env.Playdb.IsQuarantined(source, scoreId)
+		if err != nil {
+			return err
+		}
+		if quarantined {
+			if env.Verbose >= 2 {
+				log.Printf("score %s already quarantined\n", scoreId)
+			}
+			continue
+		}
+
+		score, err := getScore(ctx, scoreId)
 		if err != nil {
 			return err
 		}
 
-		err = addScoreToPlayDB(score, ctx)
+		err = addScoreToPlayDB(score, scoreId, env)
 		if err != nil {
 			return err
 		}
 
-		time.Sleep(ctx.ApiInterval)
+		err = app.Sleep(ctx, env.ApiInterval)
+		if err != nil {
+			return err
+		}
 	}
 
 	return nil
@@ -105,39 +146,122 @@ func toSongType(kamaiDifficulty string) string {
 	return "std"
 }
 
-func addScoreToPlayDB(score scoreJSON, ctx context.PlaylogCtx) error {
-	playDate := score.Body.Score.TimeAchieved / 1000
+// addScoreToPlayDB adds a score to env.Playdb,
+// or quarantines it if env.Playdb rejects it because it fails validation
+func addScoreToPlayDB(score scoreJSON, scoreId string, env app.Env) error {
+	play, err := toPlayInfo(score, scoreId, env.Songdb)
+	if err != nil {
+		return err
+	}
 
-	_, err := ctx.Playdb.GetPlay(playDate)
-	if err == nil {
-		if ctx.Verbose >= 2 {
-			log.Printf("play %d already exists in db\n", playDate)
-		}
-		return nil
-	} else if _, ok := err.(*database.PlayNotFoundError); !ok {
+	result, err := env.Playdb.AddPlayWithResult(play)
+	if _, ok := err.(*database.InvalidPlayError); ok {
+		return quarantine(env.Playdb, score, play, err)
+	} else if err != nil {
 		return err
 	}
 
-	scoreData := score.Body.Score.ScoreData
+	if result == database.PlayConflict {
+		log.Printf("warning: play %d (score %s) conflicts with a different play in database", play.UserPlayDate, scoreId)
+	} else if env.Verbose >= 1 {
+		log.Printf("play %d: %s", play.UserPlayDate, result)
+	}
 
-	// special cases
-	switch score.Body.Song.Title {
-	case "":
-		// songId 11422
-		score.Body.Song.Title = "\u3000"
-	case "PON PON PON":
-		// songId 59
-		score.Body.Song.Title = "PON PON PON "
+	return nil
+}
+
+func quarantine(playdb database.PlayStore, score scoreJSON, play database.PlayInfo, reason error) error {
+	log.Printf("warning: play %d (score %s): quarantined: %s\n", play.UserPlayDate, play.SourceId, reason)
+
+	payload, err := json.Marshal(score)
+	if err != nil {
+		return err
 	}
 
-	songs, err := ctx.Songdb.GetSongsByName(score.Body.Song.Title)
+	return playdb.QuarantinePlay(database.QuarantinedPlay{
+		UserPlayDate : play.UserPlayDate,
+		SongId       : play.SongId,
+		Source       : source,
+		SourceId     : play.SourceId,
+		Reason       : reason.Error(),
+		Payload      : payload,
+	})
+}
+
+// PromoteQuarantined adds the quarantined plays from kamaitachi
+// that the play db doesn't reject anymore, e.g. after the validation mode
+// is changed to warn or flag.
+// env requires Playdb, Songdb, Verbose
+func PromoteQuarantined(ctx context.Context, env app.Env) error {
+	env.Playdb = env.Playdb.WithContext(ctx)
+	env.Songdb = env.Songdb.WithContext(ctx)
+
+	plays, err := env.Playdb.GetQuarantinedPlays(source)
 	if err != nil {
 		return err
 	}
 
+	for _, q := range plays {
+		var score scoreJSON
+		err = json.Unmarshal(q.Payload, &score)
+		if err != nil {
+			return err
+		}
+
+		play, err := toPlayInfo(score, q.SourceId, env.Songdb)
+		if err != nil {
+			return err
+		}
+
+		result, err := env.Playdb.AddPlayWithResult(play)
+		if _, ok := err.(*database.InvalidPlayError); ok {
+			if env.Verbose >= 2 {
+				log.Printf("play %d: still quarantined: %s\n", q.UserPlayDate, err)
+			}
+			q.Reason = err.Error()
+			err = env.Playdb.QuarantinePlay(q)
+			if err != nil {
+				return err
+			}
+			continue
+		} else if err != nil {
+			return err
+		}
+
+		if result == database.PlayConflict {
+			log.Printf("warning: play %d (score %s) conflicts with a different play in database", q.UserPlayDate, q.SourceId)
+		} else if env.Verbose >= 1 {
+			log.Printf("play %d: released from quarantine: %s", q.UserPlayDate, result)
+		}
+
+		err = env.Playdb.ReleaseQuarantinedPlay(q.QuarantineId)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// toPlayInfo converts a score from kamaitachi to a play of its song in songdb
+func toPlayInfo(score scoreJSON, scoreId string, songdb database.SongStore) (database.PlayInfo, error) {
+	playDate := score.Body.Score.TimeAchieved / 1000
+
+	scoreData := score.Body.Score.ScoreData
+
+	// the name of songId 11422 is a full-width space, which kamaitachi trims.
+	// Other titles that differ from the song db are its aliases.
+	if score.Body.Song.Title == "" {
+		score.Body.Song.Title = "\u3000"
+	}
+	songs, err := songdb.GetSongsByName(score.Body.Song.Title)
+	if err != nil {
+		return database.PlayInfo{}, err
+	}
+
 	difficulty, err := kamaiDiffToDiff(score.Body.Chart.Difficulty)
 	if err != nil {
-		return err
+		return database.PlayInfo{}, err
 	}
 
 	songType := toSongType(score.Body.Chart.Difficulty)
@@ -149,7 +273,7 @@ func addScoreToPlayDB(score scoreJSON, ctx context.PlaylogCtx) error {
 		}
 	}
 	if song == nil {
-		return errors.New(fmt.Sprintf("no song with name '%s' and type '%s' found", score.Body.Song.Title, songType))
+		return database.PlayInfo{}, errors.New(fmt.Sprintf("no song with name '%s' and type '%s' found", score.Body.Song.Title, songType))
 	}
 
 	var chart *database.ChartInfo
@@ -159,7 +283,7 @@ func addScoreToPlayDB(score scoreJSON, ctx context.PlaylogCtx) error {
 		}
 	}
 	if chart == nil {
-		return errors.New(fmt.Sprintf("no chart with difficulty '%d' for song with name '%s' and type '%s' found", difficulty, score.Body.Song.Title, songType))
+		return database.PlayInfo{}, errors.New(fmt.Sprintf("no chart with difficulty '%d' for song with name '%s' and type '%s' found", difficulty, score.Body.Song.Title, songType))
 	}
 
 	kamaiLevel := int(math.Round(score.Body.Chart.LevelNum * 10))
@@ -169,7 +293,7 @@ func addScoreToPlayDB(score scoreJSON, ctx context.PlaylogCtx) error {
 
 	comboStatus, err := lampToComboStatus(scoreData.Lamp)
 	if err != nil {
-		return err
+		return database.PlayInfo{}, err
 	}
 
 	play := database.PlayInfo{
@@ -177,6 +301,9 @@ func addScoreToPlayDB(score scoreJSON, ctx context.PlaylogCtx) error {
 		SongId       : song.SongId,
 		Difficulty   : difficulty,
 
+		Source   : source,
+		SourceId : scoreId,
+
 		Score         : int(math.Round(scoreData.Percent * 10000)),
 		DxScore       : judgementsToDxScore(scoreData),
 		ComboStatus   : comboStatus,
@@ -206,16 +333,7 @@ func addScoreToPlayDB(score scoreJSON, ctx context.PlaylogCtx) error {
 		TotalMiss            : scoreData.Judgements.Miss,
 	}
 
-	err = ctx.Playdb.AddPlay(play)
-	if err != nil {
-		return err
-	}
-
-	if ctx.Verbose >= 1 {
-		log.Printf("added play %d to database", playDate)
-	}
-
-	return nil
+	return play, nil
 }
 
 func judgementsToDxScore(scoreData scoreDataJSON) int {
@@ -255,11 +373,21 @@ func lampToComboStatus(lamp string) (database.ComboStatus, error) {
 	return combo, nil
 }
 
-func getScore(scoreId string) (scoreJSON, error) {
+// get sends a GET request to url, which is cancelled with ctx
+func get(ctx context.Context, url string) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	return http.DefaultClient.Do(req)
+}
+
+func getScore(ctx context.Context, scoreId string) (scoreJSON, error) {
 	score := scoreJSON{}
 	url := apiUrl + "/scores/" + scoreId + "?getRelated"
 
-	resp, err := http.Get(url)
+	resp, err := get(ctx, url)
 	if err != nil {
 		return score, err
 	}
@@ -280,6 +408,7 @@ func getScore(scoreId string) (scoreJSON, error) {
 
 type sessions struct {
 	User      string
+	ctx       context.Context
 	startTime int64
 	sessions  []sessionJSON
 	err       error
@@ -292,9 +421,9 @@ func (s *sessions) Next() bool {
 
 	var resp *http.Response
 	if s.startTime == 0 {
-		resp, s.err = http.Get(url)
+		resp, s.err = get(s.ctx, url)
 	} else {
-		resp, s.err = http.Get(url + fmt.Sprintf("?startTime=%d",s.startTime))
+		resp, s.err = get(s.ctx, url + fmt.Sprintf("?startTime=%d",s.startTime))
 	}
 	if s.err != nil {
 		return false
@@ -0,0 +1,173 @@
+// Copyright (C) 2025 Ethan Cheng <ethan@nijika.org>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package kamai
+
+import (
+	"context"
+	"testing"
+	"path/filepath"
+
+	"database/sql"
+	_ "github.com/mattn/go-sqlite3"
+
+	"github.com/yadayadajaychan/playlog/database"
+	"github.com/yadayadajaychan/playlog/internal/app"
+)
+
+// TestInvalidPlayIsQuarantined tests that a score rejected by validation
+// is quarantined without stopping the scores after it from being added,
+// and is added once the validation mode lets it
+func TestInvalidPlayIsQuarantined(t *testing.T) {
+	dir := t.TempDir()
+
+	db, err := sql.Open("sqlite3", filepath.Join(dir, "plays.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	db2, err := sql.Open("sqlite3", filepath.Join(dir, "songs.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db2.Close()
+
+	playdb, err := database.NewPlayDB(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	songdb, err := database.NewSongDB(db2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = songdb.AddSong(database.SongInfo{
+		SongId: 11441,
+		Name:   "終焉逃避行",
+		Type:   "dx",
+		Charts: []database.ChartInfo{{Difficulty: database.Master, Level: 13, InternalLevel: 137, MaxNotes: 783}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	env := app.Env{Playdb: playdb.WithValidationMode(database.RejectInvalid), Songdb: songdb}
+
+	scoreIds := []string{"score0", "score1", "score2"}
+	for i, scoreId := range scoreIds {
+		var score scoreJSON
+		score.Body.Song.Title = "終焉逃避行"
+		score.Body.Chart.Difficulty = "DX Master"
+		score.Body.Chart.LevelNum = 13.7
+		score.Body.Score.TimeAchieved = int64(1743108003000 + i*300000)
+		score.Body.Score.ScoreData.Percent = 100.5
+		score.Body.Score.ScoreData.Lamp = "FULL COMBO+"
+		score.Body.Score.ScoreData.Judgements.Pcrit = 780
+		score.Body.Score.ScoreData.Judgements.Great = 3
+		if i == 0 {
+			// a full combo with a miss
+			score.Body.Score.ScoreData.Judgements.Great = 2
+			score.Body.Score.ScoreData.Judgements.Miss = 1
+		}
+
+		err = addScoreToPlayDB(score, scoreId, env)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	count, err := playdb.GetCount()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != 2 {
+		t.Errorf("expected 2 plays, got %d", count)
+	}
+	quarantined, err := playdb.IsQuarantined(source, "score0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !quarantined {
+		t.Fatal("expected score0 to be quarantined")
+	}
+
+	// still rejected
+	err = PromoteQuarantined(context.Background(), env)
+	if err != nil {
+		t.Fatal(err)
+	}
+	quarantined, err = playdb.IsQuarantined(source, "score0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !quarantined {
+		t.Fatal("expected score0 to stay quarantined")
+	}
+
+	env.Playdb = playdb.WithValidationMode(database.WarnInvalid)
+	err = PromoteQuarantined(context.Background(), env)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = playdb.GetPlayBySourceId(source, "score0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	quarantined, err = playdb.IsQuarantined(source, "score0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if quarantined {
+		t.Error("score0 still quarantined")
+	}
+}
+
+// TestEmptyTitle tests that the title kamaitachi trims to nothing
+// is the song whose name is a full-width space
+func TestEmptyTitle(t *testing.T) {
+	db, err := sql.Open("sqlite3", filepath.Join(t.TempDir(), "songs.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	songdb, err := database.NewSongDB(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = songdb.AddSong(database.SongInfo{
+		SongId: 11422,
+		Name:   "\u3000",
+		Type:   "dx",
+		Charts: []database.ChartInfo{{Difficulty: database.Master, Level: 13, InternalLevel: 130}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var score scoreJSON
+	score.Body.Chart.Difficulty = "DX Master"
+	score.Body.Chart.LevelNum = 13.0
+	score.Body.Score.TimeAchieved = 1743108003000
+	score.Body.Score.ScoreData.Percent = 97
+	score.Body.Score.ScoreData.Lamp = "CLEAR"
+
+	play, err := toPlayInfo(score, "score0", songdb)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if play.SongId != 11422 {
+		t.Errorf("expected song 11422, got %d", play.SongId)
+	}
+}
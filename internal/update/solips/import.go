@@ -27,7 +27,7 @@ type jsonPlaylogDetail struct {
 }
 
 // used by script to import playlog data from json file to sqlite3 db
-func Import(playdb *database.PlayDB, data io.Reader) error {
+func Import(playdb database.PlayStore, data io.Reader) error {
 	detail := &jsonPlaylogDetail{}
 	decoder := json.NewDecoder(data)
 	err := decoder.Decode(detail)
@@ -36,7 +36,7 @@ func Import(playdb *database.PlayDB, data io.Reader) error {
 	}
 
 	for _, v := range detail.PlaylogDetail {
-		err = addMaimaiPlaylogDetailToPlayDB(playdb, v)
+		_, err = addMaimaiPlaylogDetailToPlayDB(playdb, v, "", 0)
 		if err != nil {
 			return err
 		}
@@ -18,6 +18,7 @@
 package solips
 
 import (
+	"context"
 	"fmt"
 	"log"
 	"io"
@@ -29,7 +30,7 @@ import (
 	"errors"
 
 	"github.com/yadayadajaychan/playlog/database"
-	"github.com/yadayadajaychan/playlog/internal/context"
+	"github.com/yadayadajaychan/playlog/internal/app"
 )
 
 const (
@@ -37,6 +38,7 @@ const (
 	loginUrl = `https://www.solips.app/api/trpc/card.link?batch=1`
 	detailUrlFmt = `https://www.solips.app/api/trpc/maimai.playlogDetail,maimai.favorites?batch=1&input={"0":{"json":{"playlogId":"%s"}},"1":{"json":null,"meta":{"values":["undefined"]}}}`
 	playlogLength = 100
+	source = "solips" // database.PlayInfo.Source of plays from solips
 )
 
 type apiPlaylogV2 struct {
@@ -61,13 +63,14 @@ type apiPlaylogEntry struct {
 type apiPlaylogDetailV2 struct {
 	Result	struct {
 		Data	struct {
-			JSON	maimaiPlaylogDetail
+			JSON	json.RawMessage // maimaiPlaylogDetail
 		}
 	}
 }
 
 type apiPlaylogDetail struct {
 	MaimaiPlaylogDetail	maimaiPlaylogDetail
+	raw			json.RawMessage // MaimaiPlaylogDetail as returned by solips
 }
 
 type maimaiPlaylogDetail struct {
@@ -142,11 +145,23 @@ var globalCookieJar, _ = cookiejar.New(nil)
 
 // Update uses the Mythos access code to get the most recent 100 songs played
 // and makes an api request per new song that's not in the database,
-// delaying by ctx.ApiInterval between requests.
-// It then adds them to the database.
-// ctx requires Playdb, Songdb, AccessCode, ApiInterval, Verbose
-func Update(ctx context.PlaylogCtx) error {
-	playlog, err := getPlaylog(ctx.AccessCode)
+// delaying by env.ApiInterval between requests.
+// It then adds them to the database. Plays of songs or charts that aren't
+// in the song db, and plays rejected by validation, are quarantined,
+// and quarantined plays are added once their song is.
+// Requests and queries are cancelled with ctx. A play is either added
+// or quarantined completely or not at all.
+// env requires Playdb, Songdb, AccessCode, ApiInterval, Verbose
+func Update(ctx context.Context, env app.Env) error {
+	env.Playdb = env.Playdb.WithContext(ctx)
+	env.Songdb = env.Songdb.WithContext(ctx)
+
+	err := PromoteQuarantined(ctx, env)
+	if err != nil {
+		return err
+	}
+
+	playlog, err := getPlaylog(ctx, env.AccessCode)
 	if err != nil {
 		return err
 	}
@@ -162,41 +177,173 @@ func Update(ctx context.PlaylogCtx) error {
 			return err
 		}
 
-		_, err = ctx.Playdb.GetPlay(playdate.Unix())
+		// plays added before source ids were stored are fetched once more,
+		// which fills in their source id
+		_, err = env.Playdb.GetPlayBySourceId(source, entry.PlaylogApiId)
 		if _, ok := err.(*database.PlayNotFoundError); ok {
-			playlogDetail, err := getPlaylogDetail(ctx.AccessCode, entry.PlaylogApiId)
+			quarantined, err := env.Playdb.IsQuarantined(source, entry.PlaylogApiId)
 			if err != nil {
 				return err
 			}
+			if quarantined {
+				if env.Verbose >= 2 {
+					log.Printf("play %d: already quarantined\n", playdate.Unix())
+				}
+				continue
+			}
 
-			err = validatePlaylogDetail(playlogDetail, ctx.Songdb)
+			playlogDetail, err := getPlaylogDetail(ctx, env.AccessCode, entry.PlaylogApiId)
 			if err != nil {
 				return err
 			}
 
-			err = addMaimaiPlaylogDetailToPlayDB(ctx.Playdb, playlogDetail.MaimaiPlaylogDetail)
+			err = addPlaylogDetail(env, playlogDetail, entry.PlaylogApiId, playdate)
 			if err != nil {
 				return err
 			}
 
-			if ctx.Verbose >= 1 {
-				log.Printf("play %d: added to db\n", playdate.Unix())
+			err = app.Sleep(ctx, env.ApiInterval)
+			if err != nil {
+				return err
 			}
-			time.Sleep(ctx.ApiInterval)
-
 		} else if err != nil {
 			return err
 		} else {
-			if ctx.Verbose >= 2 {
+			if env.Verbose >= 2 {
 				log.Printf("play %d: already exists in db\n", playdate.Unix())
 			}
 		}
 	}
 
+	return nil
+}
+
+// addPlaylogDetail adds a play to env.Playdb. Plays of songs or charts
+// that aren't in env.Songdb, and plays that env.Playdb rejects because
+// they fail validation, are quarantined instead, so they don't stop
+// the plays after them from being added.
+func addPlaylogDetail(env app.Env, playlogDetail *apiPlaylogDetail, playlogApiId string, playdate time.Time) error {
+	variant, err := validatePlaylogDetail(playlogDetail, env.Songdb)
+	var result database.AddPlayResult
+	if err == nil {
+		result, err = addMaimaiPlaylogDetailToPlayDB(env.Playdb, playlogDetail.MaimaiPlaylogDetail, playlogApiId, variant)
+	}
+	if isUnknownChart(err) || isInvalidPlay(err) {
+		return quarantine(env.Playdb, playlogDetail, playlogApiId, playdate, err)
+	} else if err != nil {
+		return err
+	}
 
+	if result == database.PlayConflict {
+		log.Printf("warning: play %d (%s): conflicts with a different play in db\n", playdate.Unix(), playlogApiId)
+	} else if env.Verbose >= 1 {
+		log.Printf("play %d: %s\n", playdate.Unix(), result)
+	}
 	return nil
 }
 
+// isInvalidPlay reports whether err is from a play rejected
+// by the ValidationMode of the play db
+func isInvalidPlay(err error) bool {
+	_, ok := err.(*database.InvalidPlayError)
+	return ok
+}
+
+// isUnknownChart reports whether err is from a play of a song or chart
+// that isn't in the song db (yet)
+func isUnknownChart(err error) bool {
+	switch err.(type) {
+	case *database.SongNotFoundError, *database.ChartNotFoundError:
+		return true
+	default:
+		return false
+	}
+}
+
+func quarantine(playdb database.PlayStore, playlogDetail *apiPlaylogDetail, playlogApiId string, playdate time.Time, reason error) error {
+	log.Printf("warning: play %d (%s): quarantined: %s\n", playdate.Unix(), playlogApiId, reason)
+
+	return playdb.QuarantinePlay(database.QuarantinedPlay{
+		UserPlayDate : playdate.Unix(),
+		SongId       : playlogDetail.MaimaiPlaylogDetail.Info.MusicId,
+		Source       : source,
+		SourceId     : playlogApiId,
+		Reason       : reason.Error(),
+		Payload      : playlogDetail.raw,
+	})
+}
+
+// PromoteQuarantined validates the quarantined plays from solips again
+// and adds the ones whose song is now in the song db to the play db,
+// unless the play db still rejects them, e.g. until the validation mode
+// is changed to warn or flag.
+// Plays that fail validation for another reason stay in quarantine.
+// env requires Playdb, Songdb, Verbose
+func PromoteQuarantined(ctx context.Context, env app.Env) error {
+	env.Playdb = env.Playdb.WithContext(ctx)
+	env.Songdb = env.Songdb.WithContext(ctx)
+
+	plays, err := env.Playdb.GetQuarantinedPlays(source)
+	if err != nil {
+		return err
+	}
+
+	for _, play := range plays {
+		playlogDetail := &apiPlaylogDetail{raw: play.Payload}
+		err = json.Unmarshal(play.Payload, &playlogDetail.MaimaiPlaylogDetail)
+		if err != nil {
+			return err
+		}
+
+		variant, err := validatePlaylogDetail(playlogDetail, env.Songdb)
+		if isUnknownChart(err) {
+			if env.Verbose >= 2 {
+				log.Printf("play %d: still quarantined: %s\n", play.UserPlayDate, err)
+			}
+			continue
+		} else if err != nil {
+			log.Printf("warning: play %d (%s): stays quarantined: %s\n", play.UserPlayDate, play.SourceId, err)
+			play.Reason = err.Error()
+			err = env.Playdb.QuarantinePlay(play)
+			if err != nil {
+				return err
+			}
+			continue
+		}
+
+		result, err := addMaimaiPlaylogDetailToPlayDB(env.Playdb, playlogDetail.MaimaiPlaylogDetail, play.SourceId, variant)
+		if isInvalidPlay(err) {
+			if env.Verbose >= 2 {
+				log.Printf("play %d: still quarantined: %s\n", play.UserPlayDate, err)
+			}
+			play.Reason = err.Error()
+			err = env.Playdb.QuarantinePlay(play)
+			if err != nil {
+				return err
+			}
+			continue
+		} else if err != nil {
+			return err
+		}
+
+		if result == database.PlayConflict {
+			log.Printf("warning: play %d (%s): conflicts with a different play in db\n", play.UserPlayDate, play.SourceId)
+		} else if env.Verbose >= 1 {
+			log.Printf("play %d: released from quarantine: %s\n", play.UserPlayDate, result)
+		}
+
+		err = env.Playdb.ReleaseQuarantinedPlay(play.QuarantineId)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// levelToDifficulty maps a solips level to a difficulty.
+// Levels don't tell variants of the same difficulty apart,
+// see validatePlaylogDetail for how the variant is found.
 func levelToDifficulty(lvl string) (database.Difficulty, error) {
 	var difficulty database.Difficulty
 	switch lvl {
@@ -219,15 +366,27 @@ func levelToDifficulty(lvl string) (database.Difficulty, error) {
 	return difficulty, nil
 }
 
-func addMaimaiPlaylogDetailToPlayDB(playdb *database.PlayDB, maimai maimaiPlaylogDetail) error {
+// addMaimaiPlaylogDetailToPlayDB adds a play to playdb.
+// playlogApiId is the play's id at solips, or "" if unknown.
+// variant is the database.ChartInfo.Variant of the chart played.
+func addMaimaiPlaylogDetailToPlayDB(playdb database.PlayStore, maimai maimaiPlaylogDetail, playlogApiId string, variant int) (database.AddPlayResult, error) {
+	playinfo, err := toPlayInfo(maimai, playlogApiId, variant)
+	if err != nil {
+		return database.PlayNotAdded, err
+	}
+
+	return playdb.AddPlayWithResult(playinfo)
+}
+
+func toPlayInfo(maimai maimaiPlaylogDetail, playlogApiId string, variant int) (database.PlayInfo, error) {
 	playdate, err := time.Parse(time.RFC3339, maimai.Info.UserPlayDate)
 	if err != nil {
-		return err
+		return database.PlayInfo{}, err
 	}
 
 	difficulty, err := levelToDifficulty(maimai.Info.Level)
 	if err != nil {
-		return err
+		return database.PlayInfo{}, err
 	}
 
 	var comboStatus database.ComboStatus
@@ -243,7 +402,7 @@ func addMaimaiPlaylogDetailToPlayDB(playdb *database.PlayDB, maimai maimaiPlaylo
 	case "MAIMAI_COMBO_STATUS_ALL_PERFECT_PLUS":
 		comboStatus = database.AllPerfectPlus
 	default:
-		return errors.New("addMaimaiPlaylogDetailToPlayDB: invalid combo status: " + maimai.Info.ComboStatus)
+		return database.PlayInfo{}, errors.New("toPlayInfo: invalid combo status: " + maimai.Info.ComboStatus)
 	}
 
 	var syncStatus database.SyncStatus
@@ -259,7 +418,7 @@ func addMaimaiPlaylogDetailToPlayDB(playdb *database.PlayDB, maimai maimaiPlaylo
 	case "MAIMAI_SYNC_STATUS_FULL_SYNC_DX_PLUS":
 		syncStatus = database.FullSyncDxPlus
 	default:
-		return errors.New("addMaimaiPlaylogDetailToPlayDB: invalid sync status: " + maimai.Info.SyncStatus)
+		return database.PlayInfo{}, errors.New("toPlayInfo: invalid sync status: " + maimai.Info.SyncStatus)
 	}
 
 	matchingUsers := make([]string, 0, 1)
@@ -271,6 +430,10 @@ func addMaimaiPlaylogDetailToPlayDB(playdb *database.PlayDB, maimai maimaiPlaylo
 		UserPlayDate : playdate.Unix(),
 		SongId       : maimai.Info.MusicId,
 		Difficulty   : difficulty,
+		Variant      : variant,
+
+		Source   : source,
+		SourceId : playlogApiId,
 
 		Score         : maimai.Info.Achievement,
 		DxScore       : maimai.Info.Deluxscore,
@@ -353,24 +516,19 @@ func addMaimaiPlaylogDetailToPlayDB(playdb *database.PlayDB, maimai maimaiPlaylo
 				maimai.Detail.JudgeBreak.BreakMiss,
 	}
 
-	err = playdb.AddPlay(playinfo)
-	if err != nil {
-		return err
-	}
-
-	return nil
+	return playinfo, nil
 }
 
 // getPlaylog gets the non-detailed playlog of the most recent 100 plays.
 // only the playlogApiId and userPlayDate values matter in this case.
-func getPlaylog(accessCode string) (*apiPlaylog, error) {
+func getPlaylog(ctx context.Context, accessCode string) (*apiPlaylog, error) {
 	// POST to loginUrl and save cookie
 	client := &http.Client{
 		Jar: globalCookieJar,
 	}
 
 	loginData := fmt.Sprintf(`{"0":{"json":"%s"}}`, accessCode)
-	req, err := http.NewRequest("POST", loginUrl, strings.NewReader(loginData))
+	req, err := http.NewRequestWithContext(ctx, "POST", loginUrl, strings.NewReader(loginData))
 	if err != nil {
 		return nil, err
 	}
@@ -388,7 +546,7 @@ func getPlaylog(accessCode string) (*apiPlaylog, error) {
 	resp.Body.Close()
 
 	// GET with authentication cookie
-	req, err = http.NewRequest("GET", apiUrl, nil)
+	req, err = http.NewRequestWithContext(ctx, "GET", apiUrl, nil)
 	if err != nil {
 		return nil, err
 	}
@@ -458,13 +616,13 @@ func printPlaylog(playlog *apiPlaylog) {
 }
 
 // getPlaylogDetail depends on globalCookieJar to work correctly.
-func getPlaylogDetail(accessCode, playlogApiId string) (*apiPlaylogDetail, error) {
+func getPlaylogDetail(ctx context.Context, accessCode, playlogApiId string) (*apiPlaylogDetail, error) {
 	client := &http.Client{
 		Jar: globalCookieJar,
 	}
 
 	url := fmt.Sprintf(detailUrlFmt, playlogApiId)
-	req, err := http.NewRequest("GET", url, nil)
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
 	if err != nil {
 		return nil, err
 	}
@@ -493,42 +651,70 @@ func getPlaylogDetail(accessCode, playlogApiId string) (*apiPlaylogDetail, error
 
 	// convert from V2 to V1
 	playlogDetail := &apiPlaylogDetail{
-		MaimaiPlaylogDetail: playlogDetailV2.Result.Data.JSON,
+		raw: playlogDetailV2.Result.Data.JSON,
+	}
+	err = json.Unmarshal(playlogDetail.raw, &playlogDetail.MaimaiPlaylogDetail)
+	if err != nil {
+		return nil, err
 	}
 
 	return playlogDetail, nil
 }
 
-func validatePlaylogDetail(playlogDetail *apiPlaylogDetail, songdb *database.SongDB) error {
+// validatePlaylogDetail checks the play against its chart in songdb and
+// returns the chart's variant. If a difficulty has more than one variant,
+// the one whose MaxNotes matches the play's TotalCombo is used.
+func validatePlaylogDetail(playlogDetail *apiPlaylogDetail, songdb database.SongStore) (int, error) {
 	detail := playlogDetail.MaimaiPlaylogDetail
 
 	if len(detail.Info.UserPlayDate) <= 0 {
-		return errors.New("error validating playlogDetail, userPlayDate not found")
+		return 0, errors.New("error validating playlogDetail, userPlayDate not found")
 	}
 
 	song, err := songdb.GetSong(detail.Info.MusicId)
 	if err != nil {
-		return err
+		return 0, err
 	}
 
 	difficulty, err := levelToDifficulty(detail.Info.Level)
 	if err != nil {
-		return err
+		return 0, err
 	}
 
 	totalCombo := detail.Detail.TotalCombo
-	if totalCombo == 0 {
-		// TODO add up all the notes instead
-		return nil
-	}
 
+	found := false
 	for _, chart := range song.Charts {
-		if chart.Difficulty == difficulty {
-			if chart.MaxNotes == totalCombo || chart.MaxNotes == 0 {
-				return nil
-			}
+		if chart.Difficulty != difficulty {
+			continue
 		}
+		found = true
+
+		if totalCombo != 0 && chart.MaxNotes != 0 && chart.MaxNotes != totalCombo {
+			continue
+		}
+
+		play, err := toPlayInfo(detail, "", chart.Variant)
+		if err != nil {
+			return 0, err
+		}
+
+		return chart.Variant, database.ValidatePlayAgainstChart(play, chart)
 	}
 
-	return errors.New(fmt.Sprintf("error validating playlogDetail, invalid songId: %d", detail.Info.MusicId))
+	if !found {
+		return 0, &database.ChartNotFoundError{SongId: detail.Info.MusicId, Difficulty: difficulty}
+	}
+
+	if totalCombo == 0 {
+		// TODO add up all the notes instead
+		return 0, nil
+	}
+
+	playdate, err := time.Parse(time.RFC3339, detail.Info.UserPlayDate)
+	if err != nil {
+		return 0, err
+	}
+	return 0, &database.InvalidPlayError{UserPlayDate: playdate.Unix(), Problems: []string{
+		fmt.Sprintf("total combo %d matches no chart of song %d", totalCombo, detail.Info.MusicId)}}
 }
@@ -17,12 +17,19 @@ package solips
 
 import (
 	"os"
+	"fmt"
+	"strings"
+	"context"
+	"time"
 	"testing"
+	"path/filepath"
+	"encoding/json"
 
 	"database/sql"
 	_ "github.com/mattn/go-sqlite3"
 
 	"github.com/yadayadajaychan/playlog/database"
+	"github.com/yadayadajaychan/playlog/internal/app"
 )
 
 // TestGetPlaylog tests that the returned playlog has 100 entries
@@ -44,7 +51,7 @@ func TestGetPlaylog(t *testing.T) {
 		t.Fatal(err)
 	}
 
-	playlog, err := getPlaylog(accessCode)
+	playlog, err := getPlaylog(context.Background(), accessCode)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -54,13 +61,338 @@ func TestGetPlaylog(t *testing.T) {
 		t.Fatal(err)
 	}
 
-	playlogDetail, err := getPlaylogDetail(accessCode, playlog.Playlog[0].PlaylogApiId)
+	playlogDetail, err := getPlaylogDetail(context.Background(), accessCode, playlog.Playlog[0].PlaylogApiId)
 	if err != nil {
 		t.Fatal(err)
 	}
 
-	err = validatePlaylogDetail(playlogDetail, songdb)
+	_, err = validatePlaylogDetail(playlogDetail, songdb)
 	if err != nil {
 		t.Fatal(err)
 	}
 }
+
+// TestPromoteQuarantined tests that a play of a song that isn't in the
+// song db is quarantined, and added once the song is
+func TestPromoteQuarantined(t *testing.T) {
+	dir := t.TempDir()
+
+	db, err := sql.Open("sqlite3", filepath.Join(dir, "plays.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	db2, err := sql.Open("sqlite3", filepath.Join(dir, "songs.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db2.Close()
+
+	playdb, err := database.NewPlayDB(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	songdb, err := database.NewSongDB(db2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	env := app.Env{Playdb: playdb, Songdb: songdb}
+
+	detail := maimaiPlaylogDetail{}
+	detail.Info.MusicId = 11441
+	detail.Info.Level = "MAIMAI_LEVEL_MASTER"
+	detail.Info.Achievement = 1010000
+	detail.Info.Deluxscore = 2349
+	detail.Info.ComboStatus = "MAIMAI_COMBO_STATUS_ALL_PERFECT_PLUS"
+	detail.Info.SyncStatus = "MAIMAI_SYNC_STATUS_NONE"
+	detail.Info.UserPlayDate = "2025-03-28T05:40:03+09:00"
+	detail.Detail.MaxCombo = 783
+	detail.Detail.TotalCombo = 783
+	detail.Detail.JudgeTap.TapCriticalPerfect = 539
+	detail.Detail.JudgeHold.HoldCriticalPerfect = 79
+	detail.Detail.JudgeSlide.SlideCriticalPerfect = 99
+	detail.Detail.JudgeTouch.TouchCriticalPerfect = 20
+	detail.Detail.JudgeBreak.BreakCriticalPerfect = 46
+
+	raw, err := json.Marshal(detail)
+	if err != nil {
+		t.Fatal(err)
+	}
+	playlogDetail := &apiPlaylogDetail{MaimaiPlaylogDetail: detail, raw: raw}
+
+	_, reason := validatePlaylogDetail(playlogDetail, songdb)
+	if !isUnknownChart(reason) {
+		t.Fatal("expected unknown chart, got:", reason)
+	}
+
+	playdate, err := time.Parse(time.RFC3339, detail.Info.UserPlayDate)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = quarantine(playdb, playlogDetail, "b8e8d5a1-playlog", playdate, reason)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// song is still unknown
+	err = PromoteQuarantined(context.Background(), env)
+	if err != nil {
+		t.Fatal(err)
+	}
+	quarantined, err := playdb.GetQuarantinedPlays(source)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(quarantined) != 1 {
+		t.Fatal("expected 1 quarantined play, got", len(quarantined))
+	}
+
+	err = songdb.AddSong(database.SongInfo{
+		SongId: 11441,
+		Name:   "終焉逃避行",
+		Type:   "dx",
+		Charts: []database.ChartInfo{{Difficulty: database.Master, Level: 13, InternalLevel: 137, MaxNotes: 783}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = PromoteQuarantined(context.Background(), env)
+	if err != nil {
+		t.Fatal(err)
+	}
+	quarantined, err = playdb.GetQuarantinedPlays(source)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(quarantined) != 0 {
+		t.Error("play still quarantined after its song was added")
+	}
+
+	play, err := playdb.GetPlayBySourceId(source, "b8e8d5a1-playlog")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if play.UserPlayDate != playdate.Unix() || play.Score != 1010000 || play.TotalCriticalPerfect != 783 {
+		t.Error("unexpected play:", play)
+	}
+}
+
+// TestInvalidPlayIsQuarantined tests that a play rejected by validation
+// is quarantined without stopping the plays after it from being added,
+// and is added once the validation mode lets it
+func TestInvalidPlayIsQuarantined(t *testing.T) {
+	dir := t.TempDir()
+
+	db, err := sql.Open("sqlite3", filepath.Join(dir, "plays.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	db2, err := sql.Open("sqlite3", filepath.Join(dir, "songs.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db2.Close()
+
+	playdb, err := database.NewPlayDB(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	songdb, err := database.NewSongDB(db2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = songdb.AddSong(database.SongInfo{
+		SongId: 11441,
+		Name:   "終焉逃避行",
+		Type:   "dx",
+		Charts: []database.ChartInfo{{Difficulty: database.Master, Level: 13, InternalLevel: 137, MaxNotes: 783}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	env := app.Env{Playdb: playdb.WithValidationMode(database.RejectInvalid), Songdb: songdb}
+
+	dates := []string{"2025-03-28T05:40:03+09:00", "2025-03-28T05:45:03+09:00", "2025-03-28T05:50:03+09:00"}
+	for i, date := range dates {
+		detail := maimaiPlaylogDetail{}
+		detail.Info.MusicId = 11441
+		detail.Info.Level = "MAIMAI_LEVEL_MASTER"
+		detail.Info.Achievement = 1010000
+		detail.Info.Deluxscore = 2349
+		detail.Info.ComboStatus = "MAIMAI_COMBO_STATUS_ALL_PERFECT_PLUS"
+		detail.Info.SyncStatus = "MAIMAI_SYNC_STATUS_NONE"
+		detail.Info.UserPlayDate = date
+		detail.Detail.MaxCombo = 783
+		detail.Detail.TotalCombo = 783
+		detail.Detail.JudgeTap.TapCriticalPerfect = 539
+		detail.Detail.JudgeHold.HoldCriticalPerfect = 79
+		detail.Detail.JudgeSlide.SlideCriticalPerfect = 99
+		detail.Detail.JudgeTouch.TouchCriticalPerfect = 20
+		detail.Detail.JudgeBreak.BreakCriticalPerfect = 46
+		if i == 0 {
+			// doesn't match the judgements
+			detail.Info.Deluxscore = 2000
+		}
+
+		raw, err := json.Marshal(detail)
+		if err != nil {
+			t.Fatal(err)
+		}
+		playdate, err := time.Parse(time.RFC3339, date)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		err = addPlaylogDetail(env, &apiPlaylogDetail{MaimaiPlaylogDetail: detail, raw: raw}, fmt.Sprintf("play%d", i), playdate)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	count, err := playdb.GetCount()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != 2 {
+		t.Errorf("expected 2 plays, got %d", count)
+	}
+	quarantined, err := playdb.GetQuarantinedPlays(source)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(quarantined) != 1 || quarantined[0].SourceId != "play0" {
+		t.Fatal("expected play0 to be quarantined, got", quarantined)
+	}
+	if !strings.Contains(quarantined[0].Reason, "dx score") {
+		t.Error("expected the problems as the reason, got", quarantined[0].Reason)
+	}
+
+	// still rejected
+	err = PromoteQuarantined(context.Background(), env)
+	if err != nil {
+		t.Fatal(err)
+	}
+	quarantined, err = playdb.GetQuarantinedPlays(source)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(quarantined) != 1 {
+		t.Fatal("expected 1 quarantined play, got", len(quarantined))
+	}
+
+	env.Playdb = playdb.WithValidationMode(database.FlagInvalid)
+	err = PromoteQuarantined(context.Background(), env)
+	if err != nil {
+		t.Fatal(err)
+	}
+	play, err := playdb.GetPlayBySourceId(source, "play0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(play.ValidationErrors) == 0 {
+		t.Error("expected the play to be flagged")
+	}
+}
+
+func TestMismatchedChartIsQuarantined(t *testing.T) {
+	dir := t.TempDir()
+
+	db, err := sql.Open("sqlite3", filepath.Join(dir, "plays.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	db2, err := sql.Open("sqlite3", filepath.Join(dir, "songs.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db2.Close()
+
+	playdb, err := database.NewPlayDB(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	songdb, err := database.NewSongDB(db2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the breakdown of the first song is wrong, one hold is counted as a tap
+	for i, songId := range []int{11441, 11442} {
+		chart := database.ChartInfo{
+			Difficulty: database.Master, Level: 13, InternalLevel: 137, MaxNotes: 783,
+			TapNotes: 539, HoldNotes: 79, SlideNotes: 99, TouchNotes: 20, BreakNotes: 46,
+		}
+		if i == 0 {
+			chart.TapNotes, chart.HoldNotes = 540, 78
+		}
+		err = songdb.AddSong(database.SongInfo{
+			SongId: songId,
+			Name:   fmt.Sprintf("song %d", songId),
+			Type:   "dx",
+			Charts: []database.ChartInfo{chart},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	env := app.Env{Playdb: playdb, Songdb: songdb}
+
+	dates := []string{"2025-03-28T05:40:03+09:00", "2025-03-28T05:45:03+09:00", "2025-03-28T05:50:03+09:00"}
+	for i, date := range dates {
+		detail := maimaiPlaylogDetail{}
+		detail.Info.MusicId = 11442
+		if i == 0 {
+			detail.Info.MusicId = 11441
+		}
+		detail.Info.Level = "MAIMAI_LEVEL_MASTER"
+		detail.Info.Achievement = 1010000
+		detail.Info.Deluxscore = 2349
+		detail.Info.ComboStatus = "MAIMAI_COMBO_STATUS_ALL_PERFECT_PLUS"
+		detail.Info.SyncStatus = "MAIMAI_SYNC_STATUS_NONE"
+		detail.Info.UserPlayDate = date
+		detail.Detail.MaxCombo = 783
+		detail.Detail.TotalCombo = 783
+		detail.Detail.JudgeTap.TapCriticalPerfect = 539
+		detail.Detail.JudgeHold.HoldCriticalPerfect = 79
+		detail.Detail.JudgeSlide.SlideCriticalPerfect = 99
+		detail.Detail.JudgeTouch.TouchCriticalPerfect = 20
+		detail.Detail.JudgeBreak.BreakCriticalPerfect = 46
+
+		raw, err := json.Marshal(detail)
+		if err != nil {
+			t.Fatal(err)
+		}
+		playdate, err := time.Parse(time.RFC3339, date)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		err = addPlaylogDetail(env, &apiPlaylogDetail{MaimaiPlaylogDetail: detail, raw: raw}, fmt.Sprintf("play%d", i), playdate)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	count, err := playdb.GetCount()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != 2 {
+		t.Errorf("expected the 2 plays after the mismatched one, got %d", count)
+	}
+	quarantined, err := playdb.GetQuarantinedPlays(source)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(quarantined) != 1 || quarantined[0].SourceId != "play0" {
+		t.Fatal("expected play0 to be quarantined, got", quarantined)
+	}
+	if !strings.Contains(quarantined[0].Reason, "Tap judgements") {
+		t.Error("expected the mismatch as the reason, got", quarantined[0].Reason)
+	}
+}
@@ -17,35 +17,101 @@
 package update
 
 import (
+	"context"
 	"log"
-	"os"
+	"fmt"
 	"errors"
 
-	"github.com/yadayadajaychan/playlog/internal/context"
+	"github.com/yadayadajaychan/playlog/database"
+	"github.com/yadayadajaychan/playlog/internal/app"
 	"github.com/yadayadajaychan/playlog/internal/update/solips"
 	"github.com/yadayadajaychan/playlog/internal/update/kamai"
 )
 
-// Update requires ctx.DataSource
-func Update(ctx context.PlaylogCtx) error {
-	if ctx.Verbose >= 1 {
+// Update updates the plays of every player in env.Playdb, one after another.
+// Players without a data source of their own use env.DataSource.
+// An error updating one player doesn't stop the others from being updated,
+// but cancelling ctx stops the update after the play being added.
+func Update(ctx context.Context, env app.Env) error {
+	if env.Verbose >= 1 {
 		log.Print("starting update")
 	}
 
-	switch (ctx.DataSource) {
-	case context.Solips:
-		ctx.AccessCode = os.Getenv("PLAYLOG_ACCESS_CODE")
-		if ctx.AccessCode == "" {
-			log.Fatal("missing 'PLAYLOG_ACCESS_CODE' environment variable")
+	players, err := env.Playdb.WithContext(ctx).GetPlayers()
+	if err != nil {
+		return err
+	}
+
+	errs := make([]error, 0)
+	for _, player := range players {
+		if ctx.Err() != nil {
+			errs = append(errs, ctx.Err())
+			break
+		}
+
+		err = updatePlayer(ctx, env, player, len(players) == 1)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("player %s: %w", player.Name, err))
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// updatePlayer updates the plays of one player.
+// The default player falls back to the credentials in env,
+// and is skipped if it has none and isn't the only player.
+func updatePlayer(ctx context.Context, env app.Env, player database.PlayerInfo, onlyPlayer bool) error {
+	isDefault := player.PlayerId == database.DefaultPlayerId
+	accessCode, kamaiUser := env.AccessCode, env.KamaiUser
+
+	env.Playdb = env.Playdb.WithPlayer(player.PlayerId)
+	if player.DataSource != "" {
+		var err error
+		env.DataSource, err = app.ParseDataSource(player.DataSource)
+		if err != nil {
+			return err
+		}
+	}
+
+	switch (env.DataSource) {
+	case app.Solips:
+		env.AccessCode = player.AccessCode
+		if env.AccessCode == "" && isDefault {
+			env.AccessCode = accessCode
+		}
+		if env.AccessCode == "" {
+			if isDefault && onlyPlayer {
+				log.Fatal("missing access code, set 'PLAYLOG_ACCESS_CODE' or source.access_code in the config file")
+			} else if isDefault {
+				return nil
+			}
+			return errors.New("missing access code")
+		}
+
+		if env.Verbose >= 1 {
+			log.Printf("updating player %s from solips", player.Name)
+		}
+		return solips.Update(ctx, env)
+
+	case app.Kamai:
+		env.KamaiUser = player.KamaiUser
+		if env.KamaiUser == "" && isDefault {
+			env.KamaiUser = kamaiUser
+		}
+		if env.KamaiUser == "" {
+			if isDefault && onlyPlayer {
+				log.Fatal("missing kamaitachi username, set 'PLAYLOG_KAMAI_USER' or source.kamai_user in the config file")
+			} else if isDefault {
+				return nil
+			}
+			return errors.New("missing kamaitachi username")
 		}
-		return solips.Update(ctx)
 
-	case context.Kamai:
-		ctx.KamaiUser = os.Getenv("PLAYLOG_KAMAI_USER")
-		if ctx.KamaiUser == "" {
-			log.Fatal("missing 'PLAYLOG_KAMAI_USER' environment variable")
+		if env.Verbose >= 1 {
+			log.Printf("updating player %s from kamai", player.Name)
 		}
-		return kamai.Update(ctx)
+		return kamai.Update(ctx, env)
 
 	default:
 		return errors.New("invalid data source")
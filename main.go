@@ -13,60 +13,57 @@
 // You should have received a copy of the GNU Affero General Public License
 // along with this program.  If not, see <https://www.gnu.org/licenses/>.
 
+
 package main
 
 import (
+	"context"
 	"time"
 	"os"
 	"log"
 	"fmt"
+	"sync"
+	"os/signal"
+	"path/filepath"
 	"database/sql"
 	_ "github.com/mattn/go-sqlite3"
 
 	"github.com/yadayadajaychan/playlog/internal/update"
-	"github.com/yadayadajaychan/playlog/database"
-	"github.com/yadayadajaychan/playlog/internal/context"
+	"github.com/yadayadajaychan/playlog/internal/app"
 	"github.com/yadayadajaychan/playlog/internal/backend"
+	"github.com/yadayadajaychan/playlog/internal/config"
+	"github.com/yadayadajaychan/playlog/internal/backup"
 	"github.com/pborman/getopt/v2"
-	"github.com/joho/godotenv"
 )
 
 var programVersion = "0.0.0" // default version
 
 func main() {
-	godotenv.Load()
+	dotenv := newDotenv(".env")
+	err := dotenv.load()
+	if err != nil {
+		log.Fatal(err)
+	}
 
-	ctx := context.PlaylogCtx{}
+	ctx, stop := signalContext()
+	defer stop()
 
 	help := getopt.BoolLong("help", 'h', "display help")
 	version := getopt.BoolLong("version", 'V', "display version")
-	songdbFilename := getopt.StringLong("songdb", 's', "songs.db", "filename of song db")
-	playdbFilename := getopt.StringLong("playdb", 'p', "plays.db", "filename of play db")
-	dataSource := getopt.StringLong("data-source", 'd', "solips", "valid options: solips, kamai")
-
-	verbose := getopt.CounterLong("verbose", 'v', "verbosity level (errors only, info, debug)")
-	listenPort := getopt.IntLong("listen-port", 'l', 5000, "port to listen on")
+	configFile := getopt.StringLong("config", 'f', "", "config file (default "+config.DefaultFilename+" if it exists)")
+	f := defineFlags()
+	dryRun := getopt.BoolLong("dry-run", 'n', "print pending database migrations (or the changes of a command) & exit")
 
-	updateInterval := getopt.IntLong("update-interval", 't', 900, "seconds to wait between updates")
-	apiInterval := getopt.IntLong("api-interval", 'a', 3, "seconds to wait between api requests")
-
-	getopt.FlagLong(&ctx.UpdateOnly, "update-only", 'u', "only update the play db & exit").SetGroup("action")
-	getopt.FlagLong(&ctx.BackendOnly, "backend-only", 'b', "only run the backend").SetGroup("action")
+	// from before there were commands
+	updateOnly := getopt.BoolLong("update-only", 'u', "same as the update command")
+	backendOnly := getopt.BoolLong("backend-only", 'b', "same as serve --no-update")
+	getopt.Lookup("update-only").SetGroup("action")
+	getopt.Lookup("backend-only").SetGroup("action")
 
+	getopt.SetParameters("[command [command options] [args]]")
+	getopt.SetUsage(usage)
 	getopt.Parse()
 
-	ctx.Verbose = *verbose
-	ctx.ListenPort = *listenPort
-
-	ctx.UpdateInterval = time.Duration(*updateInterval) * time.Second
-	ctx.ApiInterval = time.Duration(*apiInterval) * time.Second
-
-	if !ctx.UpdateOnly && !ctx.BackendOnly {
-		ctx.UpdateAndBackend = true
-	} else {
-		ctx.UpdateAndBackend = false
-	}
-
 	if *help {
 		getopt.Usage()
 		os.Exit(0)
@@ -75,79 +72,205 @@ func main() {
 		os.Exit(0)
 	}
 
-	if ctx.UpdateInterval <= 0 {
-		log.Fatal("update interval must be greater than 0")
-	}
-	if ctx.ApiInterval <= 0 {
-		log.Fatal("api interval must be greater than 0")
+	args := getopt.Args()
+	if len(args) == 0 {
+		switch {
+		case *updateOnly:
+			args = []string{"update"}
+		case *backendOnly:
+			args = []string{"serve", "--no-update"}
+		case *dryRun:
+			args = []string{"db", "migrate"}
+		default:
+			args = []string{"serve"}
+		}
 	}
 
-	switch (*dataSource) {
-	case "solips":
-		ctx.DataSource = context.Solips
-	case "kamai":
-		ctx.DataSource = context.Kamai
-	default:
-		log.Fatal("invalid data source")
+	c := &cli{
+		ctx:    ctx,
+		dryRun: *dryRun,
+		dotenv: dotenv,
+		loadConfig: func() (config.Config, error) {
+			return loadConfig(*configFile, f)
+		},
+		logOut: &logOutput{},
 	}
 
-	// open playdb
-	db, err := sql.Open("sqlite3", *playdbFilename)
+	err = c.run(args)
+	c.close()
+	c.logOut.close()
 	if err != nil {
-		panic(err)
+		log.Fatal(err)
 	}
-	defer db.Close()
+}
 
-	ctx.Playdb, err = database.NewPlayDB(db)
+// serveCommand runs the backend, and unless --no-update is given,
+// updates the play db every update interval. Backups are made
+// every backup interval if the backup dir is set.
+// SIGHUP reloads the configuration, and SIGINT or SIGTERM stop everything.
+func serveCommand(c *cli, set *getopt.Set, args []string) error {
+	// opening the dbs can take a while, e.g. to migrate them,
+	// and SIGHUP would kill playlog until it's caught
+	hup := notifyHangup()
+	defer signal.Stop(hup)
+
+	noUpdate := set.BoolLong("no-update", 0, "only run the backend")
+	err := c.parse(set, args)
 	if err != nil {
-		panic(err)
+		return err
 	}
-
-	// open songdb
-	db2, err := sql.Open("sqlite3", *songdbFilename)
+	err = nargs(set, 0, 0)
 	if err != nil {
-		panic(err)
+		return err
 	}
-	defer db2.Close()
 
-	ctx.Songdb, err = database.NewSongDB(db2)
+	env, err := c.stores()
 	if err != nil {
-		panic(err)
+		return err
+	}
+	db, _, err := c.conns()
+	if err != nil {
+		return err
 	}
 
+	// the updates and backups are stopped along with the backend,
+	// and waited for before the dbs are closed
+	var wg sync.WaitGroup
+	defer wg.Wait()
 
-	if ctx.UpdateAndBackend || ctx.UpdateOnly {
-		if ctx.UpdateOnly {
-			err = update.Update(ctx)
-			if err != nil {
-				log.Fatal(err)
-			}
+	ctx, cancel := context.WithCancel(c.ctx)
+	defer cancel()
 
-			if ctx.Verbose >= 1 {
-				log.Print("finished update")
-			}
-		} else {
-			go updateLoop(ctx)
+	live := &liveEnv{env: env}
+	go reloadOnHangup(ctx, hup, func() error {
+		next, err := c.reload()
+		if err != nil {
+			return err
 		}
+		live.set(next)
+		return nil
+	})
+
+	if env.BackupDir != "" {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			backupLoop(ctx, live, db)
+		}()
+	}
+
+	if !*noUpdate {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			updateLoop(ctx, live)
+		}()
+	}
+
+	err = backend.Serve(ctx, live.get)
+	if ctx.Err() != nil && live.get().Verbose >= 1 {
+		log.Print("shutting down")
+	}
+	return err
+}
+
+// reload reloads .env and the configuration, and returns the env to use
+// from then on. The listen port, databases and backup dir are kept.
+func (c *cli) reload() (app.Env, error) {
+	err := c.dotenv.load()
+	if err != nil {
+		return c.env, err
+	}
+
+	next, err := c.loadConfig()
+	if err != nil {
+		return c.env, err
+	}
+	env, err := next.Env()
+	if err != nil {
+		return c.env, err
+	}
+
+	if next.Listen.Port != c.cfg.Listen.Port || next.Playdb != c.cfg.Playdb ||
+		next.Songdb != c.cfg.Songdb || next.Backup.Dir != c.cfg.Backup.Dir {
+		log.Print("warning: the listen port, databases and backup dir are only changed on restart")
 	}
 
-	if ctx.UpdateAndBackend || ctx.BackendOnly {
-		backend.Entrypoint(ctx)
+	err = c.logOut.open(next.Log.File)
+	if err != nil {
+		return c.env, err
 	}
+
+	env.ListenPort = c.env.ListenPort
+	env.PlaydbFilename = c.env.PlaydbFilename
+	env.BackupDir = c.env.BackupDir
+	env.Playdb = c.playdb.WithValidationMode(env.ValidationMode)
+	env.Songdb = c.songdb
+	return env, nil
 }
 
-func updateLoop(ctx context.PlaylogCtx) {
+// updateLoop updates the play db every env.UpdateInterval until ctx is done,
+// with the env at the start of each update.
+// An update in progress is stopped after the play being added.
+func updateLoop(ctx context.Context, live *liveEnv) {
 	for {
-		err := update.Update(ctx)
-		if err != nil {
+		env := live.get()
+		err := update.Update(ctx, env)
+		if ctx.Err() != nil {
+			return
+		} else if err != nil {
 			log.Print("ERROR: ", err) // do not exit program
-		} else if ctx.Verbose >= 1 {
+		} else if env.Verbose >= 1 {
 			log.Print("finished update")
 		}
-		time.Sleep(ctx.UpdateInterval)
+
+		if app.Sleep(ctx, env.UpdateInterval) != nil {
+			return
+		}
 	}
 }
 
+// backupLoop backs up the play db every env.BackupInterval until ctx is done
+func backupLoop(ctx context.Context, live *liveEnv, db *sql.DB) {
+	for {
+		env := live.get()
+		err := backupPlaydb(env, db, env.BackupDir)
+		if err != nil {
+			log.Print("ERROR: backup: ", err) // do not exit program
+		}
+
+		if app.Sleep(ctx, env.BackupInterval) != nil {
+			return
+		}
+	}
+}
+
+// backupPlaydb backs up the play db to dir,
+// then deletes the backups env.BackupRetention doesn't keep
+func backupPlaydb(env app.Env, db *sql.DB, dir string) error {
+	name := filepath.Base(env.PlaydbFilename)
+
+	file, err := backup.Backup(db, dir, name, time.Now())
+	if err != nil {
+		return err
+	}
+	if env.Verbose >= 1 {
+		log.Print("backed up play db to ", file)
+	}
+
+	removed, err := backup.Prune(dir, name, env.BackupRetention, time.Now())
+	if err != nil {
+		return err
+	}
+	for _, f := range removed {
+		if env.Verbose >= 1 {
+			log.Print("removed old backup ", f)
+		}
+	}
+
+	return nil
+}
+
 func printVersion() {
 	fmt.Printf("Playlog version %s\n", programVersion)
 	fmt.Println(`
@@ -0,0 +1,589 @@
+// Copyright (C) 2025 Ethan Cheng <ethan@nijika.org>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+
+package main
+
+import (
+	"io"
+	"os"
+	"fmt"
+	"sort"
+	"time"
+	"strings"
+	"encoding/json"
+
+	"github.com/yadayadajaychan/playlog/database"
+	"github.com/yadayadajaychan/playlog/internal/score"
+	"github.com/pborman/getopt/v2"
+)
+
+// shownPlay is a play as shown by 'show recent'
+type shownPlay struct {
+	Date          int64 // Unix timestamp
+	SongId        int
+	Song          string
+	Difficulty    string
+	Variant       int
+	Level         string // e.g. "13+"
+	InternalLevel int    // of the chart when it was played, multiplied by 10
+	Score         int
+	Rank          string
+	DxScore       int
+	DxStars       int
+	ComboLamp     string
+	SyncLamp      string
+	PreviousBest  int // best score on the chart before the play, 0 if none
+	Delta         int // Score - PreviousBest
+	Rating        int
+}
+
+// shownChart is the best of the plays of a chart, as shown by
+// 'show bests' and 'show song'. Each field is the best of any play,
+// so they can come from different plays.
+type shownChart struct {
+	SongId        int
+	Song          string
+	Difficulty    string
+	Variant       int
+	Level         string
+	InternalLevel int // current, multiplied by 10
+	Score         int
+	Rank          string
+	DxScore       int
+	DxStars       int
+	ComboLamp     string
+	SyncLamp      string
+	Rating        int
+	Plays         int
+	LastPlayed    int64 // Unix timestamp, 0 if never played
+}
+
+type shownSong struct {
+	SongId  int
+	Name    string
+	Artist  string
+	Type    string
+	Version string
+	Bpm     int
+	Charts  []shownChart
+}
+
+// showFlags adds the options every show command has
+func showFlags(set *getopt.Set) (player *string, jsonOutput *bool) {
+	player = set.StringLong("player", 'P', "", "show the plays of this player instead of the default player")
+	jsonOutput = set.BoolLong("json", 'j', "print json instead of a table")
+	return
+}
+
+// showRecentCommand shows the latest plays, newest first,
+// with how much each improved on the best before it
+func showRecentCommand(c *cli, set *getopt.Set, args []string) error {
+	player, jsonOutput := showFlags(set)
+	count := set.IntLong("count", 'N', 20, "no. of plays to show")
+	err := c.parse(set, args)
+	if err != nil {
+		return err
+	}
+	err = nargs(set, 0, 0)
+	if err != nil {
+		return err
+	}
+
+	playdb, err := c.playerStore(*player)
+	if err != nil {
+		return err
+	}
+	songdb := c.env.Songdb
+
+	plays, err := playdb.GetPlays(false, *count, 0)
+	if err != nil {
+		return err
+	}
+
+	songs := songCache{songdb: songdb}
+	shown := make([]shownPlay, 0, len(plays))
+	for _, play := range plays {
+		song, chart, err := songs.chart(play.SongId, play.Difficulty, play.Variant)
+		if err != nil {
+			return err
+		}
+
+		previousBest, err := playdb.GetBestScoreOfVariantBeforeDate(play.SongId, play.Difficulty, play.Variant, play.UserPlayDate)
+		if err != nil {
+			return err
+		}
+
+		internalLevel, err := songdb.GetInternalLevelAt(play.SongId, play.Difficulty, play.UserPlayDate)
+		if _, ok := err.(*database.ChartNotFoundError); ok {
+			internalLevel = chart.InternalLevel
+		} else if err != nil {
+			return err
+		}
+		derived := score.Derive(play, chart, internalLevel)
+
+		shown = append(shown, shownPlay{
+			Date:          play.UserPlayDate,
+			SongId:        play.SongId,
+			Song:          song.Name,
+			Difficulty:    play.Difficulty.String(),
+			Variant:       play.Variant,
+			Level:         score.LevelLabel(internalLevel),
+			InternalLevel: internalLevel,
+			Score:         play.Score,
+			Rank:          derived.Rank,
+			DxScore:       play.DxScore,
+			DxStars:       derived.DxStars,
+			ComboLamp:     derived.ComboLamp,
+			SyncLamp:      derived.SyncLamp,
+			PreviousBest:  previousBest,
+			Delta:         play.Score - previousBest,
+			Rating:        derived.Rating,
+		})
+	}
+
+	if *jsonOutput {
+		return printJSON(shown)
+	}
+
+	rows := make([][]string, 0, len(shown))
+	for _, p := range shown {
+		delta := "new"
+		if p.PreviousBest > 0 {
+			delta = formatDelta(p.Delta)
+		}
+
+		rows = append(rows, []string{
+			time.Unix(p.Date, 0).Format("2006-01-02 15:04"),
+			p.Song, p.Difficulty, p.Level, formatLevel(p.InternalLevel),
+			formatAchievement(p.Score), p.Rank, delta,
+			strings.Repeat("*", p.DxStars), p.ComboLamp, p.SyncLamp,
+			fmt.Sprint(p.Rating),
+		})
+	}
+
+	printTable(os.Stdout, []string{"DATE", "SONG", "DIFFICULTY", "LV", "CONST",
+		"SCORE", "RANK", "DELTA", "DX", "LAMP", "SYNC", "RATING"}, rows)
+	return nil
+}
+
+// showBestsCommand shows the best play of each chart, highest rating first
+func showBestsCommand(c *cli, set *getopt.Set, args []string) error {
+	player, jsonOutput := showFlags(set)
+	count := set.IntLong("count", 'N', 50, "no. of charts to show, 0 for all")
+	level := set.StringLong("level", 'L', "", "only show charts of this level, e.g. 13, 13+ or 13.7")
+	err := c.parse(set, args)
+	if err != nil {
+		return err
+	}
+	err = nargs(set, 0, 0)
+	if err != nil {
+		return err
+	}
+
+	minLevel, maxLevel := 0, 1000
+	if *level != "" {
+		minLevel, maxLevel, err = score.ParseLevel(*level)
+		if err != nil {
+			return err
+		}
+	}
+
+	playdb, err := c.playerStore(*player)
+	if err != nil {
+		return err
+	}
+
+	charts, err := bestsByChart(playdb, songCache{songdb: c.env.Songdb}, func(int) bool { return true })
+	if err != nil {
+		return err
+	}
+
+	shown := make([]shownChart, 0, len(charts))
+	for _, chart := range charts {
+		if chart.InternalLevel >= minLevel && chart.InternalLevel <= maxLevel {
+			shown = append(shown, chart)
+		}
+	}
+
+	sort.SliceStable(shown, func(i, j int) bool {
+		if shown[i].Rating != shown[j].Rating {
+			return shown[i].Rating > shown[j].Rating
+		}
+		return shown[i].Score > shown[j].Score
+	})
+	if *count > 0 && len(shown) > *count {
+		shown = shown[:*count]
+	}
+
+	if *jsonOutput {
+		return printJSON(shown)
+	}
+
+	printTable(os.Stdout, chartHeader(true), chartRows(shown, true))
+	return nil
+}
+
+// showSongCommand shows every chart of the songs named name,
+// with the best plays on them
+func showSongCommand(c *cli, set *getopt.Set, args []string) error {
+	player, jsonOutput := showFlags(set)
+	err := c.parse(set, args)
+	if err != nil {
+		return err
+	}
+	err = nargs(set, 1, 1000)
+	if err != nil {
+		return err
+	}
+	name := strings.Join(set.Args(), " ")
+
+	playdb, err := c.playerStore(*player)
+	if err != nil {
+		return err
+	}
+
+	songInfos, err := findSongs(c.env.Songdb, name)
+	if err != nil {
+		return err
+	}
+
+	ids := make(map[int]bool, len(songInfos))
+	for _, song := range songInfos {
+		ids[song.SongId] = true
+	}
+
+	songs := songCache{songdb: c.env.Songdb}
+	charts, err := bestsByChart(playdb, songs, func(songId int) bool { return ids[songId] })
+	if err != nil {
+		return err
+	}
+
+	shown := make([]shownSong, 0, len(songInfos))
+	for _, song := range songInfos {
+		s := shownSong{
+			SongId:  song.SongId,
+			Name:    song.Name,
+			Artist:  song.Artist,
+			Type:    song.Type,
+			Version: song.Version,
+			Bpm:     song.Bpm,
+			Charts:  make([]shownChart, 0, len(song.Charts)),
+		}
+
+		for _, chart := range song.Charts {
+			best, ok := charts[chartKey{song.SongId, chart.Difficulty, chart.Variant}]
+			if !ok {
+				best = shownChart{
+					SongId:        song.SongId,
+					Song:          song.Name,
+					Difficulty:    chart.Difficulty.String(),
+					Variant:       chart.Variant,
+					Level:         score.LevelLabel(chart.InternalLevel),
+					InternalLevel: chart.InternalLevel,
+				}
+			}
+			s.Charts = append(s.Charts, best)
+		}
+
+		shown = append(shown, s)
+	}
+
+	if *jsonOutput {
+		return printJSON(shown)
+	}
+
+	for i, s := range shown {
+		if i > 0 {
+			fmt.Println()
+		}
+		fmt.Printf("%s / %s (%s, %s, %d bpm, id %d)\n", s.Name, s.Artist, s.Type, s.Version, s.Bpm, s.SongId)
+		printTable(os.Stdout, chartHeader(false), chartRows(s.Charts, false))
+	}
+	return nil
+}
+
+// findSongs returns the songs named name, or if there are none,
+// the songs that match it best when searching for it
+func findSongs(songdb database.SongStore, name string) ([]database.SongInfo, error) {
+	songs, err := songdb.GetSongsByName(name)
+	if _, ok := err.(*database.SongNotFoundError); !ok {
+		return songs, err
+	}
+
+	matches, err := songdb.SearchSongs(name, 0)
+	if err != nil {
+		return nil, err
+	}
+	if len(matches) == 0 {
+		return nil, fmt.Errorf("no song named %s", name)
+	}
+
+	songs = make([]database.SongInfo, 0)
+	for _, m := range matches {
+		if m.Score == matches[0].Score {
+			songs = append(songs, m.SongInfo)
+		}
+	}
+	return songs, nil
+}
+
+type chartKey struct {
+	songId     int
+	difficulty database.Difficulty
+	variant    int
+}
+
+// songCache looks up the songs of plays once each
+type songCache struct {
+	songdb database.SongStore
+	songs  map[int]database.SongInfo
+}
+
+// chart returns the song and chart of a play. Songs that aren't
+// in the song db get their id as name, and charts an empty ChartInfo.
+func (sc *songCache) chart(songId int, difficulty database.Difficulty, variant int) (database.SongInfo, database.ChartInfo, error) {
+	if sc.songs == nil {
+		sc.songs = make(map[int]database.SongInfo)
+	}
+
+	song, ok := sc.songs[songId]
+	if !ok {
+		var err error
+		song, err = sc.songdb.GetSong(songId)
+		if _, notFound := err.(*database.SongNotFoundError); notFound {
+			song = database.SongInfo{SongId: songId, Name: fmt.Sprintf("(song %d)", songId)}
+		} else if err != nil {
+			return song, database.ChartInfo{}, err
+		}
+		sc.songs[songId] = song
+	}
+
+	chart, _ := song.Chart(difficulty, variant)
+	return song, chart, nil
+}
+
+// bestsByChart returns the best of the plays of each chart
+// of the songs include returns true for
+func bestsByChart(playdb database.PlayStore, songs songCache, include func(songId int) bool) (map[chartKey]shownChart, error) {
+	count, err := playdb.GetCount()
+	if err != nil {
+		return nil, err
+	}
+	plays, err := playdb.GetPlays(true, count, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	// the best of each value of the plays,
+	// and the no. of notes in case the chart isn't in the song db
+	type best struct {
+		database.PlayInfo
+		plays int
+		last  int64
+	}
+
+	bests := make(map[chartKey]*best)
+	for _, play := range plays {
+		if !include(play.SongId) {
+			continue
+		}
+
+		key := chartKey{play.SongId, play.Difficulty, play.Variant}
+		b, ok := bests[key]
+		if !ok {
+			b = &best{}
+			bests[key] = b
+		}
+
+		b.Score = max(b.Score, play.Score)
+		b.DxScore = max(b.DxScore, play.DxScore)
+		b.ComboStatus = max(b.ComboStatus, play.ComboStatus)
+		b.SyncStatus = max(b.SyncStatus, play.SyncStatus)
+		b.IsClear = b.IsClear || play.IsClear
+		b.TotalCombo = max(b.TotalCombo, play.TotalCombo)
+		b.plays++
+		b.last = max(b.last, play.UserPlayDate)
+	}
+
+	charts := make(map[chartKey]shownChart, len(bests))
+	for key, b := range bests {
+		song, chart, err := songs.chart(key.songId, key.difficulty, key.variant)
+		if err != nil {
+			return nil, err
+		}
+		derived := score.Derive(b.PlayInfo, chart, chart.InternalLevel)
+
+		charts[key] = shownChart{
+			SongId:        key.songId,
+			Song:          song.Name,
+			Difficulty:    key.difficulty.String(),
+			Variant:       key.variant,
+			Level:         score.LevelLabel(chart.InternalLevel),
+			InternalLevel: chart.InternalLevel,
+			Score:         b.Score,
+			Rank:          derived.Rank,
+			DxScore:       b.DxScore,
+			DxStars:       derived.DxStars,
+			ComboLamp:     derived.ComboLamp,
+			SyncLamp:      derived.SyncLamp,
+			Rating:        derived.Rating,
+			Plays:         b.plays,
+			LastPlayed:    b.last,
+		}
+	}
+
+	return charts, nil
+}
+
+func chartHeader(withSong bool) []string {
+	header := []string{"DIFFICULTY", "LV", "CONST", "SCORE", "RANK", "DX", "LAMP", "SYNC", "RATING", "PLAYS", "LAST PLAYED"}
+	if withSong {
+		header = append([]string{"SONG"}, header...)
+	}
+	return header
+}
+
+func chartRows(charts []shownChart, withSong bool) [][]string {
+	rows := make([][]string, 0, len(charts))
+	for _, c := range charts {
+		difficulty := c.Difficulty
+		if c.Variant > 0 {
+			difficulty += fmt.Sprintf(" (%d)", c.Variant)
+		}
+
+		row := []string{difficulty, c.Level, formatLevel(c.InternalLevel)}
+		if c.Plays == 0 {
+			row = append(row, "-", "", "", "", "", "", "0", "")
+		} else {
+			row = append(row,
+				formatAchievement(c.Score), c.Rank, strings.Repeat("*", c.DxStars),
+				c.ComboLamp, c.SyncLamp, fmt.Sprint(c.Rating), fmt.Sprint(c.Plays),
+				time.Unix(c.LastPlayed, 0).Format(time.DateOnly))
+		}
+
+		if withSong {
+			row = append([]string{c.Song}, row...)
+		}
+		rows = append(rows, row)
+	}
+	return rows
+}
+
+// formatAchievement formats a score like 1005000 as 100.5000%
+func formatAchievement(achievement int) string {
+	return fmt.Sprintf("%d.%04d%%", achievement/10000, achievement%10000)
+}
+
+// formatDelta formats a difference of scores like 1234 as +0.1234
+func formatDelta(delta int) string {
+	sign := "+"
+	if delta < 0 {
+		sign = "-"
+		delta = -delta
+	}
+	return fmt.Sprintf("%s%d.%04d", sign, delta/10000, delta%10000)
+}
+
+// formatLevel formats an internal level like 137 as 13.7, or "?" if it's unknown
+func formatLevel(internalLevel int) string {
+	if internalLevel == 0 {
+		return "?"
+	}
+	return fmt.Sprintf("%d.%d", internalLevel/10, internalLevel%10)
+}
+
+func printJSON(v any) error {
+	j, err := json.MarshalIndent(v, "", "\t")
+	if err != nil {
+		return err
+	}
+	fmt.Println(string(j))
+	return nil
+}
+
+// maxCellWidth is the width long cells, e.g. song names, are cut to
+const maxCellWidth = 32
+
+// printTable prints rows aligned in columns under header.
+// Wide characters, e.g. in Japanese song names, count as two columns.
+func printTable(w io.Writer, header []string, rows [][]string) {
+	rows = append([][]string{header}, rows...)
+
+	widths := make([]int, len(header))
+	for _, row := range rows {
+		for i, cell := range row {
+			row[i] = truncate(cell, maxCellWidth)
+			widths[i] = max(widths[i], displayWidth(row[i]))
+		}
+	}
+
+	for _, row := range rows {
+		var b strings.Builder
+		for i, cell := range row {
+			b.WriteString(cell)
+			if i < len(row)-1 {
+				b.WriteString(strings.Repeat(" ", widths[i]-displayWidth(cell)+2))
+			}
+		}
+		fmt.Fprintln(w, strings.TrimRight(b.String(), " "))
+	}
+}
+
+// truncate cuts s to width columns, ending it with "…" if it was cut
+func truncate(s string, width int) string {
+	if displayWidth(s) <= width {
+		return s
+	}
+
+	var b strings.Builder
+	w := 0
+	for _, r := range s {
+		if w+runeWidth(r) > width-1 {
+			break
+		}
+		b.WriteRune(r)
+		w += runeWidth(r)
+	}
+	return b.String() + "…"
+}
+
+func displayWidth(s string) int {
+	w := 0
+	for _, r := range s {
+		w += runeWidth(r)
+	}
+	return w
+}
+
+// runeWidth returns 2 for the east asian wide and fullwidth characters
+// common in song names, and 1 otherwise
+func runeWidth(r rune) int {
+	switch {
+	case r >= 0x1100 && r <= 0x115f, // hangul jamo
+		r >= 0x2e80 && r <= 0xa4cf && r != 0x303f, // cjk, kana
+		r >= 0xac00 && r <= 0xd7a3, // hangul
+		r >= 0xf900 && r <= 0xfaff, // cjk compatibility
+		r >= 0xfe30 && r <= 0xfe4f,
+		r >= 0xff00 && r <= 0xff60, // fullwidth forms
+		r >= 0xffe0 && r <= 0xffe6,
+		r >= 0x1f300 && r <= 0x1f64f, // emoji
+		r >= 0x1f900 && r <= 0x1f9ff,
+		r >= 0x20000 && r <= 0x3fffd:
+		return 2
+	default:
+		return 1
+	}
+}
@@ -0,0 +1,109 @@
+// Copyright (C) 2025 Ethan Cheng <ethan@nijika.org>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+
+package main
+
+import (
+	"context"
+	"errors"
+	"io/fs"
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/joho/godotenv"
+)
+
+// dotenv loads .env into the environment.
+// Variables already set in the environment are left alone,
+// like godotenv.Load, but those set from .env are
+// updated (or unset) when .env is loaded again.
+type dotenv struct {
+	filename string
+	loaded   map[string]bool // variables set from the file
+}
+
+func newDotenv(filename string) *dotenv {
+	return &dotenv{filename: filename, loaded: make(map[string]bool)}
+}
+
+// load (re)loads the file, which doesn't have to exist
+func (d *dotenv) load() error {
+	vars, err := godotenv.Read(d.filename)
+	if errors.Is(err, fs.ErrNotExist) {
+		vars = map[string]string{}
+	} else if err != nil {
+		return err
+	}
+
+	for key := range d.loaded {
+		if _, ok := vars[key]; !ok {
+			os.Unsetenv(key)
+			delete(d.loaded, key)
+		}
+	}
+
+	for key, value := range vars {
+		if _, set := os.LookupEnv(key); set && !d.loaded[key] {
+			continue
+		}
+		os.Setenv(key, value)
+		d.loaded[key] = true
+	}
+
+	return nil
+}
+
+// signalContext returns a context that's cancelled on SIGINT or SIGTERM.
+// A second signal isn't caught, so it kills the program
+// if shutting down takes too long.
+func signalContext() (context.Context, context.CancelFunc) {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-ctx.Done()
+		stop()
+	}()
+	return ctx, stop
+}
+
+// notifyHangup catches SIGHUP from now on, instead of being killed by it,
+// and returns the channel it's sent to for reloadOnHangup.
+// Call signal.Stop on it when done.
+func notifyHangup() chan os.Signal {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	return hup
+}
+
+// reloadOnHangup calls reload on every SIGHUP sent to hup until ctx is done,
+// including one caught before it was called.
+// Errors are logged, and the previous configuration is kept.
+func reloadOnHangup(ctx context.Context, hup <-chan os.Signal, reload func() error) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-hup:
+			err := reload()
+			if err != nil {
+				log.Print("ERROR: reload: ", err)
+			} else {
+				log.Print("reloaded configuration")
+			}
+		}
+	}
+}